@@ -2,34 +2,74 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 
 	"github.com/jaeger-ai-assist-prototype/internal"
 	"github.com/jaeger-ai-assist-prototype/internal/ai"
+	"github.com/jaeger-ai-assist-prototype/internal/eval"
+	"github.com/jaeger-ai-assist-prototype/internal/jaeger"
 	"github.com/jaeger-ai-assist-prototype/internal/llm"
 	"github.com/jaeger-ai-assist-prototype/internal/llm/langchain"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/redact"
+	"github.com/jaeger-ai-assist-prototype/internal/server"
 	"github.com/jaeger-ai-assist-prototype/internal/synthetic"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
 )
 
 func main() {
 	cfgPath := flag.String("config", "config.yaml", "path to config file")
 
-	explainTraceIdx := flag.Int("explaintrace", -1, "explain a whole trace by index")
-	explainSpanTraceIdx := flag.Int("explainspan-trace", -1, "trace index for span explanation")
-	explainSpanIdx := flag.Int("explainspan", -1, "span index within trace")
+	explainTraceID := flag.String("explaintrace", "", "explain a whole trace by hex trace ID")
+	explainSpanTraceID := flag.String("explainspan-trace", "", "trace ID for span explanation")
+	explainSpanID := flag.String("explainspan", "", "hex span ID within that trace")
+	criticalPathID := flag.String("criticalpath", "", "print the critical path of a trace by hex trace ID")
+	serve := flag.Bool("serve", false, "run the HTTP API server instead of a one-shot query")
+	debug := flag.Bool("debug", false, "log at debug level, including LLM prompts and replies")
+	recordDir := flag.String("record", "", "record every LLM reply to fixtures in this directory")
+	replayDir := flag.String("replay", "", "answer from the LLM fixtures in this directory instead of a model")
+	generateOut := flag.String("generate", "", "write synthetic traces to this OTLP JSON file and exit")
+	scenarioPath := flag.String("scenarios", "", "scenario file for -generate (default: the traces_bench.json scenarios)")
+	traceCount := flag.Int("count", 100, "number of traces for -generate")
+	evalQueries := flag.String("evalqueries", "", "score search extraction against this dataset and exit")
+	evalExplain := flag.String("evalexplain", "", "score trace and span explanations on these labeled traces and exit")
+	labelsPath := flag.String("labels", "", "ground truth for -evalexplain (default: <traces>.labels.json)")
+	reportPath := flag.String("report", "", "write the evaluation report to this JSON file")
+	baselinePath := flag.String("baseline", "", "compare the evaluation with this earlier report (-evalexplain: a comma-separated list)")
+	runLabel := flag.String("label", "", "name of the evaluation run in its report")
 
 	flag.Parse()
 
-	if flag.NArg() != 1 && *explainTraceIdx < 0 && *explainSpanIdx < 0 {
+	if *generateOut != "" {
+		if err := generateTraces(*generateOut, *scenarioPath, *traceCount); err != nil {
+			log.Fatalf("generate failed: %v", err)
+		}
+		return
+	}
+
+	if flag.NArg() != 1 && *explainTraceID == "" && *explainSpanID == "" && *criticalPathID == "" && !*serve && *evalQueries == "" && *evalExplain == "" {
 		fmt.Println(`usage:
   ai-query -config config.yaml "natural language query"
-  ai-query -config config.yaml --explaintrace 1 
-  ai-query -config config.yaml --explainspan-trace 1 --explainspan 4 
+  ai-query -config config.yaml --explaintrace 4bf92f3577b34da6a3ce929d0e0e4736
+  ai-query -config config.yaml --explainspan-trace 4bf92f3577b34da6a3ce929d0e0e4736 --explainspan 00f067aa0ba902b7
+  ai-query -config config.yaml --criticalpath 4bf92f3577b34da6a3ce929d0e0e4736
+  ai-query -config config.yaml --serve
+  ai-query --generate traces.json [--scenarios scenarios.yaml] [--count 100]
+  ai-query -config config.yaml --evalqueries dataset.yaml [--report run.json] [--baseline earlier.json] [--label name]
+  ai-query -config config.yaml --evalexplain traces.json [--labels traces.labels.json] [--report run.json] [--baseline a.json,b.json] [--label name]
   `)
 		os.Exit(1)
 	}
@@ -45,147 +85,459 @@ func main() {
 		log.Fatalf("config load failed: %v", err)
 	}
 
+	// --- Logging ---
+	if *debug {
+		cfg.Logging.Level = "debug"
+		cfg.Logging.Prompts = true
+	}
+	logger, err := newLogger(cfg.Logging)
+	if err != nil {
+		log.Fatalf("logging config: %v", err)
+	}
+	slog.SetDefault(logger)
+
 	// --- LLM factory ---
+	switch {
+	case *recordDir != "" && *replayDir != "":
+		log.Fatalf("--record and --replay are exclusive")
+	case *recordDir != "":
+		cfg.LLM.Replay = langchain.ReplayConfig{Mode: "record", Dir: *recordDir}
+	case *replayDir != "":
+		cfg.LLM.Replay = langchain.ReplayConfig{Mode: "replay", Dir: *replayDir}
+	}
 	model, err := llm.NewLLM(cfg.LLM)
 	if err != nil {
 		log.Fatalf("LLM init failed: %v", err)
 	}
 
-	extractor := langchain.NewSearchExtractor(model)
+	extractor := langchain.NewSearchExtractor(model, cfg.LLM)
+	extractor.ExtractionLLM, err = llm.NewExtractionLLM(cfg.LLM)
+	if err != nil {
+		log.Fatalf("LLM init failed: %v", err)
+	}
+	extractor.Redactor, err = newRedactor(cfg.Redaction, logger)
+	if err != nil {
+		log.Fatalf("redaction config: %v", err)
+	}
+	extractor.Logger = logger
+	extractor.LogPrompts = cfg.Logging.Prompts
+
+	// CASE 0a: Extraction eval, no trace backend involved
+	if *evalQueries != "" {
+		run := eval.Run{
+			Label:  *runLabel,
+			Model:  cfg.LLM.Provider + "/" + cfg.LLM.Model,
+			Prompt: eval.Fingerprint(cfg.LLM.ExtractionPrompt()),
+		}
+		if err := evalExtraction(extractor, *evalQueries, run, *reportPath, *baselinePath); err != nil {
+			log.Fatalf("eval failed: %v", err)
+		}
+		return
+	}
 
-	// --- Synthetic backend ---
-	traces, err := synthetic.LoadTracesFromFile("./traces_bench.json")
+	// --- Trace backend ---
+	reader, err := newTraceReader(cfg.Backend, logger)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("backend init failed: %v", err)
 	}
-	reader := synthetic.NewSyntheticTraceReader(traces)
 	querySvc := internal.NewQueryService(reader)
 
+	attrs, err := ai.NewAttributePolicy(cfg.LLM.ContextAttributes.Allow, cfg.LLM.ContextAttributes.Deny)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
 	// --- AI query service ---
 	aiSvc := &ai.AIQueryService{
-		LLM:   extractor,
-		Query: querySvc,
+		LLM:                   extractor,
+		Query:                 querySvc,
+		MaxExtractionAttempts: cfg.LLM.MaxExtractionAttempts,
+		KeepPartialResults:    true,
+		TraceContextTokens:    cfg.LLM.TraceContextTokens,
+		Attributes:            attrs,
+		Logger:                logger,
 	}
 
-	ctx := context.Background()
+	// Ctrl-C cancels in-flight LLM calls and stops the server.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// CASE 1: Explain whole trace
-	if *explainTraceIdx >= 0 {
-		if *explainTraceIdx >= len(traces) {
-			log.Fatalf("trace index out of range")
+	// CASE 0b: Explanation eval over labeled traces
+	if *evalExplain != "" {
+		run := eval.Run{
+			Label:  *runLabel,
+			Model:  cfg.LLM.Provider + "/" + cfg.LLM.Model,
+			Prompt: eval.Fingerprint(langchain.TraceExplainPrompt + langchain.SpanExplainPrompt),
+		}
+		labels := *labelsPath
+		if labels == "" {
+			labels = strings.TrimSuffix(*evalExplain, ".json") + ".labels.json"
+		}
+		if err := evalExplanations(ctx, aiSvc, *evalExplain, labels, run, *reportPath, *baselinePath); err != nil {
+			log.Fatalf("eval failed: %v", err)
+		}
+		return
+	}
+
+	// CASE 0: HTTP API
+	if *serve {
+		srv := &server.Server{
+			AI:              aiSvc,
+			RequestTimeout:  cfg.Server.RequestTimeout,
+			ShutdownTimeout: cfg.Server.ShutdownTimeout,
+			Logger:          logger,
+		}
+
+		addr := cfg.Server.Addr
+		if addr == "" {
+			addr = ":8080"
 		}
 
-		fmt.Printf("=== EXPLAIN TRACE %d ===\n", *explainTraceIdx)
-		explanation, err := aiSvc.ExplainTrace(ctx, traces[*explainTraceIdx])
+		logger.Info("listening", "addr", addr)
+		if err := srv.ListenAndServe(ctx, addr); err != nil {
+			log.Fatalf("server failed: %v", err)
+		}
+		return
+	}
+
+	// One-shot commands log under a single request ID.
+	ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+
+	// CASE 1a: Critical path, no LLM involved
+	if *criticalPathID != "" {
+		trace := getTrace(ctx, aiSvc, *criticalPathID)
+		printCriticalPath(trace)
+		return
+	}
+
+	// CASE 1: Explain whole trace
+	if *explainTraceID != "" {
+		trace := getTrace(ctx, aiSvc, *explainTraceID)
+
+		fmt.Printf("=== EXPLAIN TRACE %s ===\n", traceutil.TraceID(trace))
+		printRootCauses(ai.RankRootCauses(trace))
+		_, err := aiSvc.ExplainTraceStream(ctx, trace, printChunk)
+		fmt.Println()
 		if err != nil {
 			log.Fatalf("explain trace failed: %v", err)
 		}
-
-		fmt.Println(explanation)
 		return
 	}
 
 	// CASE 2: Explain single span
-	if *explainSpanIdx >= 0 {
-		if *explainSpanTraceIdx < 0 {
+	if *explainSpanID != "" {
+		if *explainSpanTraceID == "" {
 			log.Fatalf("--explainspan requires --explainspan-trace")
 		}
 
-		if *explainSpanTraceIdx >= len(traces) {
-			log.Fatalf("trace index out of range")
+		spanID, err := traceutil.ParseSpanID(*explainSpanID)
+		if err != nil {
+			log.Fatal(err)
 		}
+		trace := getTrace(ctx, aiSvc, *explainSpanTraceID)
 
-		trace := traces[*explainSpanTraceIdx]
-
-		span, svcName := pickSpan(trace, *explainSpanIdx)
-		if span == nil {
-			log.Fatalf("span index out of range")
+		span, res, ok := traceutil.FindSpan(trace, spanID)
+		if !ok {
+			log.Fatalf("span %s not found in trace %s", spanID, traceutil.TraceID(trace))
+		}
+		svcName := traceutil.ServiceName(res, span)
+		if svcName == "" {
+			svcName = "unknown"
 		}
 
-		fmt.Printf("=== EXPLAIN SPAN %d (TRACE %d) SERVICE: %s ===\n",
-			*explainSpanIdx, *explainSpanTraceIdx, svcName)
+		fmt.Printf("=== EXPLAIN SPAN %s (TRACE %s) SERVICE: %s ===\n",
+			spanID, traceutil.TraceID(trace), svcName)
 
 		// Pass svcName to the service
-		explanation, err := aiSvc.ExplainSpan(ctx, *span, svcName)
+		_, err = aiSvc.ExplainSpanStream(ctx, span, res, svcName, printChunk)
+		fmt.Println()
 		if err != nil {
 			log.Fatalf("explain span failed: %v", err)
 		}
-
-		fmt.Println(explanation)
 		return
 	}
 
 	// CASE 3: Default → Natural language search
 	result, err := aiSvc.Search(ctx, queryText)
-	if err != nil {
+	if err != nil && !result.Partial {
+		for i, a := range result.Attempts {
+			logger.ErrorContext(ctx, "extraction attempt", "attempt", i+1, "output", extractor.Redactor.Redact(a.Output), "error", a.Error)
+		}
 		log.Fatalf("search failed: %v", err)
 	}
+	if err != nil {
+		logger.WarnContext(ctx, "search incomplete, showing partial results", "error", err)
+	}
 
 	fmt.Println("=== SEARCH RESULTS ===")
-	fmt.Printf("Traces returned: %d\n\n", len(result.Traces))
+	fmt.Printf("Traces returned: %d (queries: %d, batches: %d, truncated: %v, partial: %v, took %v)\n\n",
+		len(result.Traces), len(result.SubQueries), result.Batches,
+		result.Truncated, result.Partial, result.Elapsed.Round(time.Millisecond))
 
 	for i, trace := range result.Traces {
-		fmt.Printf("Trace #%d\n", i+1)
+		fmt.Printf("Trace #%d %s matched by %s\n", i+1, traceutil.TraceID(trace), matchedBy(result, i))
 		printTraceSummary(trace)
 		fmt.Println()
 	}
 }
 
-func pickSpan(t ptrace.Traces, idx int) (*ptrace.Span, string) {
-	count := 0
-	rss := t.ResourceSpans()
-	for i := 0; i < rss.Len(); i++ {
-		rs := rss.At(i)
+// printChunk writes explanation output as it arrives.
+func printChunk(chunk string) error {
+	_, err := fmt.Print(chunk)
+	return err
+}
+
+// generateTraces writes count synthetic traces from the scenario file, or
+// from the default scenarios when scenarioPath is empty, to out, and the
+// ground truth of the labeled ones next to it as <out>.labels.json.
+func generateTraces(out, scenarioPath string, count int) error {
+	scenarios := synthetic.DefaultConfig()
+	if scenarioPath != "" {
+		var err error
+		if scenarios, err = synthetic.LoadConfig(scenarioPath); err != nil {
+			return err
+		}
+	}
+	traces, truth, err := synthetic.GenerateLabeled(scenarios, count)
+	if err != nil {
+		return err
+	}
+	if err := synthetic.WriteTracesToFile(out, traces); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d traces to %s\n", len(traces), out)
+	if len(truth) == 0 {
+		return nil
+	}
+	labels := strings.TrimSuffix(out, filepath.Ext(out)) + ".labels.json"
+	if err := synthetic.WriteGroundTruthToFile(labels, truth); err != nil {
+		return err
+	}
+	fmt.Printf("wrote the ground truth of %d traces to %s\n", len(truth), labels)
+	return nil
+}
 
-		serviceName := "unknown"
-		if sn, ok := rs.Resource().Attributes().Get("service.name"); ok {
-			serviceName = sn.Str()
+// evalExtraction scores model on the dataset, prints the result and, when
+// given, writes the report and compares it with the baseline report.
+func evalExtraction(model ai.LLM, dataset string, run eval.Run, reportPath, baselinePath string) error {
+	ds, err := eval.LoadQueryDataset(dataset)
+	if err != nil {
+		return err
+	}
+	var base *eval.QueryReport
+	if baselinePath != "" {
+		if base, err = eval.LoadQueryReport(baselinePath); err != nil {
+			return err
 		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := eval.RunQueries(ctx, model, ds, run)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(report.Summary())
+	if base != nil {
+		fmt.Println()
+		fmt.Print(eval.CompareQueryReports(base, report))
+	}
+	if reportPath != "" {
+		return eval.WriteReport(reportPath, report)
+	}
+	return nil
+}
 
-		ss := rs.ScopeSpans()
-		for j := 0; j < ss.Len(); j++ {
-			spans := ss.At(j).Spans()
-			for k := 0; k < spans.Len(); k++ {
-				if count == idx {
-					s := spans.At(k)
-					return &s, serviceName
-				}
-				count++
-			}
+// evalExplanations explains every labeled trace and its root-cause span,
+// prints the scores and, when given, writes the report and compares it with
+// the comma-separated baseline reports.
+func evalExplanations(ctx context.Context, model eval.Explainer, tracesPath, labelsPath string, run eval.Run, reportPath, baselinePaths string) error {
+	cases, err := eval.LoadExplainCases(tracesPath, labelsPath)
+	if err != nil {
+		return err
+	}
+	var reports []*eval.ExplainReport
+	for _, path := range strings.Split(baselinePaths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		r, err := eval.LoadExplainReport(path)
+		if err != nil {
+			return err
 		}
+		reports = append(reports, r)
 	}
-	return nil, ""
+
+	report, err := eval.RunExplanations(ctx, model, cases, run)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(report.Summary())
+	if len(reports) > 0 {
+		fmt.Println()
+		fmt.Print(eval.CompareExplainReports(append(reports, report)))
+	}
+	if reportPath != "" {
+		return eval.WriteReport(reportPath, report)
+	}
+	return nil
 }
 
-func printTraceSummary(t ptrace.Traces) {
-	rs := t.ResourceSpans()
-	for i := 0; i < rs.Len(); i++ {
-		ss := rs.At(i).ScopeSpans()
-		for j := 0; j < ss.Len(); j++ {
-			spans := ss.At(j).Spans()
-			for k := 0; k < spans.Len(); k++ {
-				span := spans.At(k)
-				fmt.Printf(
-					"trace=%s span=%s service=%s error=%v\n",
-					span.TraceID().String(),
-					span.Name(),
-					getService(span),
-					isError(span),
-				)
-			}
+// newTraceReader builds the reader selected by cfg.
+func newTraceReader(cfg langchain.BackendConfig, logger *slog.Logger) (internal.TraceReader, error) {
+	switch cfg.Type {
+	case "", "synthetic":
+		path := cfg.TracesFile
+		if path == "" {
+			path = "./traces_bench.json"
 		}
+		traces, err := synthetic.LoadTracesFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+		r := synthetic.NewSyntheticTraceReader(traces)
+		r.Logger = logger
+		return r, nil
+
+	case "jaeger":
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("backend %q requires an endpoint", cfg.Type)
+		}
+		conn, err := jaeger.Dial(cfg.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		r := jaeger.NewGRPCTraceReader(conn)
+		r.Lookback = cfg.Lookback
+		r.Logger = logger
+		return r, nil
+
+	default:
+		return nil, errors.New("unsupported backend: " + cfg.Type)
+	}
+}
+
+// newLogger builds the stderr logger selected by cfg.
+func newLogger(cfg langchain.LoggingConfig) (*slog.Logger, error) {
+	level, err := logging.ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(os.Stderr, level, cfg.Format)
+}
+
+// newRedactor builds the redactor selected by cfg, or nil when redaction is
+// turned off. A key_env naming an empty or unset variable is an error; only
+// without key_env is a random per-process key used.
+func newRedactor(cfg langchain.RedactionConfig, logger *slog.Logger) (*redact.Redactor, error) {
+	if cfg.Enabled != nil && !*cfg.Enabled {
+		return nil, nil
+	}
+	opts := redact.Options{}
+	if len(cfg.Detectors) > 0 {
+		opts.Detectors = cfg.Detectors
+	}
+	for _, r := range cfg.Rules {
+		opts.Rules = append(opts.Rules, redact.Rule{Name: r.Name, Pattern: r.Pattern})
 	}
+	if cfg.KeyEnv == "" {
+		logger.Warn("redaction.key_env is not set, using a random key; pseudonyms change on restart")
+		return redact.New(opts)
+	}
+	key := os.Getenv(cfg.KeyEnv)
+	if key == "" {
+		return nil, fmt.Errorf("key_env %s is empty or not set", cfg.KeyEnv)
+	}
+	opts.Key = []byte(key)
+	return redact.New(opts)
 }
-func getService(span ptrace.Span) string {
-	if v, ok := span.Attributes().Get("service.name"); ok {
-		return v.Str()
+
+// getTrace resolves a hex trace ID, as printed by a search or copied from
+// the Jaeger UI, or exits.
+func getTrace(ctx context.Context, aiSvc *ai.AIQueryService, rawID string) ptrace.Traces {
+	trace, err := aiSvc.GetTrace(ctx, rawID)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return ""
+	return trace
 }
 
-func isError(span ptrace.Span) bool {
-	if v, ok := span.Attributes().Get("error"); ok {
-		return v.Bool()
+// printRootCauses lists the ranked root cause candidates, if the trace failed.
+func printRootCauses(ranked []ai.RootCause) {
+	if len(ranked) == 0 {
+		return
 	}
-	return false
+	fmt.Println("Root cause candidates:")
+	for i, rc := range ranked {
+		fmt.Printf("  %d. [%d] %s %s span_id=%s %s\n     %s\n",
+			i+1, rc.Score, rc.Service, rc.Operation, rc.SpanID, rc.Message, strings.Join(rc.Reasons, ", "))
+	}
+	fmt.Println()
+}
+
+// printCriticalPath lists the spans on the critical path, indented by depth,
+// with their start offset and the time each holds the path.
+func printCriticalPath(t ptrace.Traces) {
+	tree := traceutil.BuildTree(t)
+	path := tree.CriticalPath()
+
+	var total time.Duration
+	for _, c := range path {
+		total += c.Exclusive
+	}
+	fmt.Printf("=== CRITICAL PATH %s (%v) ===\n", traceutil.TraceID(t), total.Round(time.Millisecond))
+
+	origin := tree.Start()
+	for _, c := range path {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(c.Exclusive) / float64(total)
+		}
+		fmt.Printf("%9v %9v %5.1f%%  %s%s %s span_id=%s\n",
+			c.Node.Span.StartTimestamp().AsTime().Sub(origin).Round(time.Microsecond),
+			c.Exclusive.Round(time.Microsecond),
+			share,
+			strings.Repeat("  ", c.Node.Depth),
+			traceutil.ServiceName(c.Node.Resource, c.Node.Span),
+			c.Node.Span.Name(),
+			c.Node.Span.SpanID(),
+		)
+	}
+}
+
+// matchedBy describes the sub-queries that found the i-th trace of result,
+// e.g. "[service=payment-svc operation=Authorize] [service=catalog-svc]".
+func matchedBy(result ai.SearchResult, i int) string {
+	var parts []string
+	for _, q := range result.MatchedBy[i] {
+		var filters []string
+		sub := result.SubQueries[q]
+		if sub.Service != nil {
+			filters = append(filters, "service="+*sub.Service)
+		}
+		if sub.Operation != nil {
+			filters = append(filters, "operation="+*sub.Operation)
+		}
+		if len(filters) == 0 {
+			filters = append(filters, "all services")
+		}
+		parts = append(parts, "["+strings.Join(filters, " ")+"]")
+	}
+	return strings.Join(parts, " ")
+}
+
+func printTraceSummary(t ptrace.Traces) {
+	traceutil.ForEachSpan(t, func(res pcommon.Resource, span ptrace.Span) bool {
+		fmt.Printf(
+			"trace=%s span_id=%s span=%s service=%s error=%v\n",
+			span.TraceID().String(),
+			span.SpanID().String(),
+			span.Name(),
+			traceutil.ServiceName(res, span),
+			traceutil.IsError(span),
+		)
+		return true
+	})
 }
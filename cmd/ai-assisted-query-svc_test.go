@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/jaeger-ai-assist-prototype/internal/llm/langchain"
+)
+
+const runMainEnv = "AI_QUERY_RUN_MAIN"
+
+// TestMain runs main instead of the tests when re-executed by runCLI.
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCLI runs the CLI in a subprocess from the repository root, so the paths
+// in config/config.yaml resolve, and returns its combined output.
+func runCLI(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, append([]string{"-config", "config/config.yaml"}, args...)...)
+	cmd.Dir = ".."
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestCLI_CriticalPathByID(t *testing.T) {
+	out, err := runCLI(t, "-criticalpath", "00000000000000000000000000000001")
+	if err != nil {
+		t.Fatalf("cli failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "=== CRITICAL PATH 00000000000000000000000000000001") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	// Short IDs, such as 64-bit Jaeger IDs, are left-padded.
+	if out, err := runCLI(t, "-criticalpath", "1"); err != nil || !strings.Contains(out, "00000000000000000000000000000001") {
+		t.Fatalf("short ID: %v\n%s", err, out)
+	}
+}
+
+// The replay tests run the model-backed flows from the fixtures in
+// testdata/replay; record them again with --record after changing a prompt.
+func TestCLI_ReplaySearch(t *testing.T) {
+	out, err := runCLI(t, "-replay", "testdata/replay", "failed checkouts in payment-svc")
+	if err != nil {
+		t.Fatalf("cli failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "=== SEARCH RESULTS ===") ||
+		!strings.Contains(out, "Trace #1 00000000000000000000000000000001 matched by [service=payment-svc]\n") ||
+		!strings.Contains(out, "span=Authorize service=payment-svc error=true") ||
+		strings.Contains(out, "service=catalog-svc") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestCLI_ReplayFanOutSearch(t *testing.T) {
+	out, err := runCLI(t, "-replay", "testdata/replay", "traces in payment-svc or frontend")
+	if err != nil {
+		t.Fatalf("cli failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"(queries: 2,",
+		"Trace #1 00000000000000000000000000000001 matched by [service=payment-svc] [service=frontend]\n",
+		"matched by [service=frontend]\n",
+		"matched by [service=payment-svc]\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCLI_ReplayExplainTrace(t *testing.T) {
+	out, err := runCLI(t, "-replay", "testdata/replay", "-explaintrace", "00000000000000000000000000000001")
+	if err != nil {
+		t.Fatalf("cli failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "payment-svc Authorize span_id=8872b44b9fbb971b") ||
+		!strings.Contains(out, "the card decline is the root cause") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestCLI_LookupErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"bad trace ID", []string{"-criticalpath", "not-hex"}, "invalid trace ID"},
+		{"unknown trace", []string{"-criticalpath", "ffffffffffffffffffffffffffffffff"}, "trace not found"},
+		{"bad explain trace ID", []string{"-explaintrace", "zz"}, "invalid trace ID"},
+		{"bad span ID", []string{"-explainspan-trace", "1", "-explainspan", "zz"}, "invalid span ID"},
+		{"unknown span", []string{"-explainspan-trace", "1", "-explainspan", "0000000000000bad"}, "span 0000000000000bad not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runCLI(t, tt.args...)
+			if err == nil {
+				t.Fatalf("expected the CLI to fail, got:\n%s", out)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Fatalf("output does not mention %q:\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestNewRedactor_Key(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	r, err := newRedactor(langchain.RedactionConfig{}, logger)
+	if err != nil || r == nil {
+		t.Fatalf("no key_env: %v", err)
+	}
+	if !strings.Contains(logs.String(), "random key") {
+		t.Fatalf("expected a warning about the random key, got %q", logs.String())
+	}
+
+	t.Setenv("TEST_REDACTION_KEY", "")
+	if _, err := newRedactor(langchain.RedactionConfig{KeyEnv: "TEST_REDACTION_KEY"}, logger); err == nil {
+		t.Fatalf("expected an error for an empty key_env variable")
+	}
+	if _, err := newRedactor(langchain.RedactionConfig{KeyEnv: "TEST_REDACTION_KEY_UNSET"}, logger); err == nil {
+		t.Fatalf("expected an error for an unset key_env variable")
+	}
+
+	t.Setenv("TEST_REDACTION_KEY", "secret")
+	logs.Reset()
+	a, err := newRedactor(langchain.RedactionConfig{KeyEnv: "TEST_REDACTION_KEY"}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newRedactor(langchain.RedactionConfig{KeyEnv: "TEST_REDACTION_KEY"}, logger)
+	if a.Redact("alice@example.com") != b.Redact("alice@example.com") {
+		t.Fatalf("the same key must give the same pseudonyms")
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("unexpected warning with a key: %q", logs.String())
+	}
+}
@@ -0,0 +1,147 @@
+package ai
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// semconvGroups maps the group names accepted in attribute rules to the
+// OpenTelemetry semantic-convention keys they cover. A key ending in "."
+// matches by prefix; any other key matches exactly.
+var semconvGroups = map[string][]string{
+	"http":       {"http.", "url.", "user_agent."},
+	"db":         {"db."},
+	"rpc":        {"rpc."},
+	"messaging":  {"messaging."},
+	"network":    {"net.", "network.", "server.", "client.", "peer."},
+	"exception":  {"exception."},
+	"error":      {"error", "error."},
+	"log":        {"event", "message", "level", "log."},
+	"code":       {"code."},
+	"deployment": {"deployment.", "service.version", "service.namespace", "service.instance.id"},
+	"host":       {"host.", "os."},
+	"k8s":        {"k8s."},
+	"container":  {"container."},
+	"cloud":      {"cloud."},
+	"process":    {"process."},
+}
+
+// DefaultAttributeAllow is the allow list used when a policy names none:
+// protocol details, errors and logs on spans, and where the code ran on
+// resources.
+var DefaultAttributeAllow = []string{
+	"group:http", "group:db", "group:rpc", "group:messaging",
+	"group:exception", "group:error", "group:log",
+	"group:deployment", "group:host", "group:k8s", "group:container",
+}
+
+// AttributePolicy selects which span, event, link and resource attributes
+// reach the model. A key is shown when an allow rule matches it and no deny
+// rule does. The zero value and a nil policy use DefaultAttributeAllow.
+type AttributePolicy struct {
+	allow, deny []attrRule
+}
+
+type attrRule struct {
+	keys []string
+	re   *regexp.Regexp
+}
+
+// NewAttributePolicy compiles allow and deny rules. Each rule is one of
+//
+//	group:<name>   a semantic-convention group such as http, db or k8s
+//	re:<regexp>    a regular expression matched against the key
+//	<prefix>       a key prefix such as "http." or "db.system"
+//
+// An empty allow list means DefaultAttributeAllow.
+func NewAttributePolicy(allow, deny []string) (*AttributePolicy, error) {
+	if len(allow) == 0 {
+		allow = DefaultAttributeAllow
+	}
+	p := &AttributePolicy{}
+	var err error
+	if p.allow, err = compileAttrRules(allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = compileAttrRules(deny); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+var defaultAttributePolicy, _ = NewAttributePolicy(nil, nil)
+
+func compileAttrRules(rules []string) ([]attrRule, error) {
+	out := make([]attrRule, 0, len(rules))
+	for _, r := range rules {
+		switch {
+		case strings.HasPrefix(r, "group:"):
+			keys, ok := semconvGroups[strings.TrimPrefix(r, "group:")]
+			if !ok {
+				return nil, fmt.Errorf("attribute rule %q: unknown group, want one of %s", r, strings.Join(groupNames(), ", "))
+			}
+			out = append(out, attrRule{keys: keys})
+		case strings.HasPrefix(r, "re:"):
+			re, err := regexp.Compile(strings.TrimPrefix(r, "re:"))
+			if err != nil {
+				return nil, fmt.Errorf("attribute rule %q: %w", r, err)
+			}
+			out = append(out, attrRule{re: re})
+		case r == "":
+			return nil, fmt.Errorf("empty attribute rule")
+		default:
+			out = append(out, attrRule{keys: []string{r + "*"}})
+		}
+	}
+	return out, nil
+}
+
+func groupNames() []string {
+	names := make([]string, 0, len(semconvGroups))
+	for n := range semconvGroups {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r attrRule) match(key string) bool {
+	if r.re != nil {
+		return r.re.MatchString(key)
+	}
+	for _, k := range r.keys {
+		switch {
+		case strings.HasSuffix(k, "*"):
+			if strings.HasPrefix(key, k[:len(k)-1]) {
+				return true
+			}
+		case strings.HasSuffix(k, "."):
+			if strings.HasPrefix(key, k) {
+				return true
+			}
+		case key == k:
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the attribute key may be shown.
+func (p *AttributePolicy) Allows(key string) bool {
+	if p == nil || p.allow == nil {
+		p = defaultAttributePolicy
+	}
+	for _, r := range p.deny {
+		if r.match(key) {
+			return false
+		}
+	}
+	for _, r := range p.allow {
+		if r.match(key) {
+			return true
+		}
+	}
+	return false
+}
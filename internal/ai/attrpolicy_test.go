@@ -0,0 +1,112 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestAttributePolicy(t *testing.T) {
+	p, err := NewAttributePolicy(
+		[]string{"group:http", "group:k8s", "app.", "re:^tenant\\.(id|tier)$"},
+		[]string{"http.request.header.", "re:(?i)token"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{
+		"http.status_code":                  true,
+		"url.path":                          true,
+		"k8s.pod.name":                      true,
+		"app.feature_flag":                  true,
+		"tenant.id":                         true,
+		"tenant.name":                       false,
+		"http.request.header.authorization": false,
+		"k8s.token_secret":                  false,
+		"db.statement":                      false,
+		"httpx":                             false,
+	} {
+		if got := p.Allows(key); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestAttributePolicy_Default(t *testing.T) {
+	var p *AttributePolicy
+	for key, want := range map[string]bool{
+		"http.status_code":     true,
+		"db.system":            true,
+		"exception.stacktrace": true,
+		"error":                true,
+		"errors.count":         false,
+		"service.version":      true,
+		"k8s.pod.name":         true,
+		"process.command_line": false,
+	} {
+		if got := p.Allows(key); got != want {
+			t.Errorf("default Allows(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestNewAttributePolicy_Invalid(t *testing.T) {
+	for _, rule := range []string{"group:nope", "re:(", ""} {
+		if _, err := NewAttributePolicy([]string{rule}, nil); err == nil {
+			t.Errorf("rule %q: expected an error", rule)
+		}
+	}
+}
+
+func TestBuildTraceContext_EventsLinksResources(t *testing.T) {
+	td := ptrace.NewTraces()
+	root := addTestSpan(td, "checkout", "PlaceOrder", 1, 0, 0, 50*time.Millisecond)
+	res := td.ResourceSpans().At(0).Resource().Attributes()
+	res.PutStr("service.version", "2.3.1")
+	res.PutStr("k8s.pod.name", "checkout-6f9c")
+	res.PutStr("process.command_line", "/app --secret=x")
+
+	root.Attributes().PutStr("app.cart_id", "c-42")
+	ev := root.Events().AppendEmpty()
+	ev.SetName("exception")
+	ev.SetTimestamp(pcommon.NewTimestampFromTime(spanBase.Add(30 * time.Millisecond)))
+	ev.Attributes().PutStr("exception.type", "NullPointerException")
+	var stack []string
+	for i := 0; i < 20; i++ {
+		stack = append(stack, "at frame"+string(rune('A'+i)))
+	}
+	ev.Attributes().PutStr("exception.stacktrace", strings.Join(stack, "\n"))
+	link := root.Links().AppendEmpty()
+	link.SetTraceID(pcommon.TraceID{15: 9})
+	link.SetSpanID(pcommon.SpanID{7: 9})
+
+	out, _ := buildTraceContext(td, defaultTraceContextTokens, nil)
+	for _, want := range []string{
+		"Resources:\n  checkout: k8s.pod.name=checkout-6f9c, service.version=2.3.1\n",
+		"  Event: +30ms exception\n",
+		"    exception.type = NullPointerException\n",
+		"at frameH\n... (12 more lines)\n",
+		"  Link: trace 00000000000000000000000000000009 span 0000000000000009\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("context is missing %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"process.command_line", "app.cart_id", "frameI"} {
+		if strings.Contains(out, unwanted) {
+			t.Fatalf("context should not contain %q:\n%s", unwanted, out)
+		}
+	}
+
+	// A custom policy applies to the span context too.
+	p, _ := NewAttributePolicy([]string{"app.", "group:exception"}, []string{"exception.stacktrace"})
+	span := buildSpanContext(root, td.ResourceSpans().At(0).Resource(), "checkout", p)
+	if !strings.Contains(span, "- app.cart_id: c-42") || strings.Contains(span, "frameA") ||
+		strings.Contains(span, "k8s.pod.name") || !strings.Contains(span, "- trace 00000000000000000000000000000009") {
+		t.Fatalf("unexpected span context:\n%s", span)
+	}
+}
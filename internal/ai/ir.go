@@ -1,24 +1,188 @@
 package ai
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/jaeger-ai-assist-prototype/internal"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 )
 
+// maxSubQueries bounds the services x operations fan-out of one question.
+const maxSubQueries = 16
+
 type SearchIR struct {
-	Service       *string           `json:"service"`
-	Operation     *string           `json:"operation"`
+	Service       *string           `json:"service" schema:"string_or_list"`
+	Operation     *string           `json:"operation" schema:"string_or_list"`
 	MinDurationMs *string           `json:"min_duration_ms"`
 	MaxDurationMs *string           `json:"max_duration_ms"`
 	StartTime     *string           `json:"start_time"`
 	EndTime       *string           `json:"end_time"`
 	Tags          map[string]string `json:"tags"`
+
+	// Services and Operations hold additional names when the question
+	// mentions several; the LLM emits them as JSON arrays in "service" and
+	// "operation". See SubQueries.
+	Services   []string `json:"services,omitempty" schema:"-"`
+	Operations []string `json:"operations,omitempty" schema:"-"`
+}
+
+// UnmarshalJSON accepts "service" and "operation" either as a string or as an
+// array of strings. A single-element array is treated as a string. "tags" is
+// an object or, as strict JSON schemas require, a list of {"key", "value"}
+// pairs. Empty strings mean "no filter", like null, for schema-constrained
+// output that cannot emit null.
+func (ir *SearchIR) UnmarshalJSON(data []byte) error {
+	type plain SearchIR
+	aux := struct {
+		*plain
+		Service   json.RawMessage `json:"service"`
+		Operation json.RawMessage `json:"operation"`
+		Tags      json.RawMessage `json:"tags"`
+	}{plain: (*plain)(ir)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if ir.Service, ir.Services, err = splitNames(aux.Service, ir.Services); err != nil {
+		return fmt.Errorf("service: %w", err)
+	}
+	if ir.Operation, ir.Operations, err = splitNames(aux.Operation, ir.Operations); err != nil {
+		return fmt.Errorf("operation: %w", err)
+	}
+	if ir.Tags, err = decodeTags(aux.Tags); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+	for _, p := range []**string{&ir.MinDurationMs, &ir.MaxDurationMs, &ir.StartTime, &ir.EndTime} {
+		if *p != nil && **p == "" {
+			*p = nil
+		}
+	}
+	return nil
+}
+
+func decodeTags(raw json.RawMessage) (map[string]string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if raw[0] != '[' {
+		var tags map[string]string
+		err := json.Unmarshal(raw, &tags)
+		return tags, err
+	}
+
+	var pairs []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &pairs); err != nil {
+		return nil, errors.New("must be an object or an array of key/value pairs")
+	}
+	var tags map[string]string
+	for _, p := range pairs {
+		if p.Key == "" {
+			continue
+		}
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags[p.Key] = p.Value
+	}
+	return tags, nil
+}
+
+func splitNames(raw json.RawMessage, extra []string) (*string, []string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, extra, nil
+	}
+
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, nil, err
+		}
+		if s == "" {
+			return nil, extra, nil
+		}
+		return &s, extra, nil
+	}
+
+	var names []string
+	if err := json.Unmarshal(raw, &names); err != nil {
+		return nil, nil, errors.New("must be a string, an array of strings or null")
+	}
+	var list []string
+	for _, n := range names {
+		if n != "" {
+			list = append(list, n)
+		}
+	}
+	if len(list) == 0 {
+		return nil, extra, nil
+	}
+	first := list[0]
+	return &first, append(list[1:], extra...), nil
+}
+
+// SubQueries expands ir into one IR per (service, operation) pair. Each
+// returned IR has scalar Service/Operation only and shares the remaining
+// filters. An IR without lists expands to itself.
+func (ir SearchIR) SubQueries() []SearchIR {
+	services := uniqueNames(ir.Service, ir.Services)
+	operations := uniqueNames(ir.Operation, ir.Operations)
+
+	out := make([]SearchIR, 0, len(services)*len(operations))
+	for _, svc := range services {
+		for _, op := range operations {
+			sub := ir
+			sub.Service, sub.Operation = svc, op
+			sub.Services, sub.Operations = nil, nil
+			out = append(out, sub)
+		}
+	}
+	return out
+}
+
+// uniqueNames returns the de-duplicated names, or a single nil entry meaning
+// "no filter" when there are none.
+func uniqueNames(first *string, rest []string) []*string {
+	var out []*string
+	seen := map[string]bool{}
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, &s)
+		}
+	}
+	if first != nil {
+		add(*first)
+	}
+	for _, s := range rest {
+		add(s)
+	}
+	if len(out) == 0 {
+		return []*string{nil}
+	}
+	return out
 }
 
+// MapIRToQueryParams maps ir to query parameters, resolving relative time
+// expressions against the current wall clock. Only the scalar Service and
+// Operation are used; expand lists with SubQueries first.
 func MapIRToQueryParams(ir SearchIR) (internal.TraceQueryParams, error) {
+	return MapIRToQueryParamsAt(ir, time.Now())
+}
+
+// MapIRToQueryParamsAt is MapIRToQueryParams with an explicit anchor for
+// relative time expressions such as "2h ago" or "yesterday".
+func MapIRToQueryParamsAt(ir SearchIR, now time.Time) (internal.TraceQueryParams, error) {
 	var qp internal.TraceQueryParams
 
 	if ir.Service != nil {
@@ -45,21 +209,12 @@ func MapIRToQueryParams(ir SearchIR) (internal.TraceQueryParams, error) {
 		qp.DurationMax = d
 	}
 
-	if ir.StartTime != nil {
-		t, err := time.Parse(time.RFC3339, *ir.StartTime)
-		if err != nil {
-			return qp, err
-		}
-		qp.StartTimeMin = t
-	}
-
-	if ir.EndTime != nil {
-		t, err := time.Parse(time.RFC3339, *ir.EndTime)
-		if err != nil {
-			return qp, err
-		}
-		qp.StartTimeMax = t
+	minT, maxT, err := ResolveTimeRange(ir.StartTime, ir.EndTime, now)
+	if err != nil {
+		return qp, err
 	}
+	qp.StartTimeMin = minT
+	qp.StartTimeMax = maxT
 
 	if len(ir.Tags) > 0 {
 		qp.Attributes = pcommon.NewMap()
@@ -71,7 +226,15 @@ func MapIRToQueryParams(ir SearchIR) (internal.TraceQueryParams, error) {
 	return qp, nil
 }
 
+// ValidateSearchIR checks ir, resolving relative time expressions against
+// the current wall clock.
 func ValidateSearchIR(ir SearchIR) error {
+	return ValidateSearchIRAt(ir, time.Now())
+}
+
+// ValidateSearchIRAt is ValidateSearchIR with an explicit anchor for relative
+// time expressions; pass the one MapIRToQueryParamsAt will use.
+func ValidateSearchIRAt(ir SearchIR, now time.Time) error {
 	var minDur, maxDur time.Duration
 	var err error
 
@@ -101,23 +264,31 @@ func ValidateSearchIR(ir SearchIR) error {
 		}
 	}
 
-	if ir.StartTime != nil {
-		if _, err := time.Parse(time.RFC3339, *ir.StartTime); err != nil {
-			return errors.New("start_time must be RFC3339")
+	if _, _, err := ResolveTimeRange(ir.StartTime, ir.EndTime, now); err != nil {
+		return fmt.Errorf("%w (use RFC3339 or an expression such as '2h ago', 'yesterday', 'last tuesday' or '2pm')", err)
+	}
+
+	for k, v := range ir.Tags {
+		if k == "" || v == "" {
+			return errors.New("tag keys and values must be non-empty")
 		}
 	}
 
-	if ir.EndTime != nil {
-		if _, err := time.Parse(time.RFC3339, *ir.EndTime); err != nil {
-			return errors.New("end_time must be RFC3339")
+	for _, s := range ir.Services {
+		if s == "" {
+			return errors.New("service names must be non-empty")
 		}
 	}
 
-	for k, v := range ir.Tags {
-		if k == "" || v == "" {
-			return errors.New("tag keys and values must be non-empty")
+	for _, o := range ir.Operations {
+		if o == "" {
+			return errors.New("operation names must be non-empty")
 		}
 	}
 
+	if n := len(ir.SubQueries()); n > maxSubQueries {
+		return fmt.Errorf("too many service/operation combinations (%d, max %d)", n, maxSubQueries)
+	}
+
 	return nil
 }
@@ -1,6 +1,7 @@
 package ai
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -11,16 +12,12 @@ func strptr(s string) *string {
 	return &s
 }
 
-func int64ptr(v int64) *int64 {
-	return &v
-}
-
 func TestSearchIR_ValidMapping(t *testing.T) {
 	ir := SearchIR{
 		Service:       strptr("payment-service"),
 		Operation:     strptr("POST /charge"),
-		MinDurationMs: int64ptr(2000),
-		MaxDurationMs: int64ptr(5000),
+		MinDurationMs: strptr("2s"),
+		MaxDurationMs: strptr("5s"),
 		Tags: map[string]string{
 			"http.status_code": "500",
 			"error":            "true",
@@ -64,9 +61,9 @@ func TestSearchIR_ValidMapping(t *testing.T) {
 
 func TestSearchIR_ValidationFailures(t *testing.T) {
 	tests := []SearchIR{
-		{MinDurationMs: int64ptr(-1)},
-		{MaxDurationMs: int64ptr(-10)},
-		{MinDurationMs: int64ptr(5000), MaxDurationMs: int64ptr(1000)},
+		{MinDurationMs: strptr("-1ms")},
+		{MaxDurationMs: strptr("-10ms")},
+		{MinDurationMs: strptr("5s"), MaxDurationMs: strptr("1s")},
 		{StartTime: strptr("not-a-time")},
 		{Tags: map[string]string{"": "500"}},
 		{Tags: map[string]string{"http.status_code": ""}},
@@ -143,3 +140,111 @@ func TestSearchIR_TagsAreIndependent(t *testing.T) {
 		t.Fatalf("mapping should not alias IR tags")
 	}
 }
+
+func TestSearchIR_UnmarshalServiceAndOperationLists(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantSvc  []string
+		wantOps  []string
+		wantSubs int
+	}{
+		{`{"service": null, "operation": null}`, nil, nil, 1},
+		{`{"service": "payments"}`, []string{"payments"}, nil, 1},
+		{`{"service": ["payments"]}`, []string{"payments"}, nil, 1},
+		{`{"service": ["payments", "orders"], "operation": "GetCart"}`, []string{"payments", "orders"}, []string{"GetCart"}, 2},
+		{`{"service": ["a", "b"], "operation": ["x", "y", "z"]}`, []string{"a", "b"}, []string{"x", "y", "z"}, 6},
+		{`{"service": ["a", "a"], "services": ["b"]}`, []string{"a", "b"}, nil, 2},
+	}
+
+	for _, tt := range tests {
+		var ir SearchIR
+		if err := json.Unmarshal([]byte(tt.in), &ir); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.in, err)
+		}
+		if err := ValidateSearchIR(ir); err != nil {
+			t.Fatalf("%s: unexpected validation error: %v", tt.in, err)
+		}
+
+		subs := ir.SubQueries()
+		if len(subs) != tt.wantSubs {
+			t.Fatalf("%s: got %d sub-queries, want %d", tt.in, len(subs), tt.wantSubs)
+		}
+
+		svcs := map[string]bool{}
+		ops := map[string]bool{}
+		for _, sub := range subs {
+			if len(sub.Services) != 0 || len(sub.Operations) != 0 {
+				t.Fatalf("%s: sub-queries must be scalar", tt.in)
+			}
+			if sub.Service != nil {
+				svcs[*sub.Service] = true
+			}
+			if sub.Operation != nil {
+				ops[*sub.Operation] = true
+			}
+		}
+		if len(svcs) != len(tt.wantSvc) || len(ops) != len(tt.wantOps) {
+			t.Fatalf("%s: got services %v operations %v", tt.in, svcs, ops)
+		}
+		for _, s := range tt.wantSvc {
+			if !svcs[s] {
+				t.Fatalf("%s: missing service %q", tt.in, s)
+			}
+		}
+		for _, o := range tt.wantOps {
+			if !ops[o] {
+				t.Fatalf("%s: missing operation %q", tt.in, o)
+			}
+		}
+	}
+}
+
+func TestSearchIR_UnmarshalRejectsBadServiceType(t *testing.T) {
+	var ir SearchIR
+	if err := json.Unmarshal([]byte(`{"service": 42}`), &ir); err == nil {
+		t.Fatalf("expected error for numeric service")
+	}
+}
+
+// Strict JSON schemas cannot express null or free-form maps, so the model
+// answers with empty strings and key/value pairs instead.
+func TestSearchIR_UnmarshalStrictSchemaOutput(t *testing.T) {
+	in := `{"service": ["payments", ""], "operation": [], "min_duration_ms": "2s", "max_duration_ms": "",
+		"start_time": "", "end_time": "", "tags": [{"key": "error", "value": "true"}, {"key": "", "value": ""}]}`
+
+	var ir SearchIR
+	if err := json.Unmarshal([]byte(in), &ir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ir.Service == nil || *ir.Service != "payments" || len(ir.Services) != 0 || ir.Operation != nil {
+		t.Fatalf("unexpected names %+v", ir)
+	}
+	if ir.MinDurationMs == nil || ir.MaxDurationMs != nil || ir.StartTime != nil || ir.EndTime != nil {
+		t.Fatalf("empty strings must mean no filter, got %+v", ir)
+	}
+	if len(ir.Tags) != 1 || ir.Tags["error"] != "true" {
+		t.Fatalf("unexpected tags %v", ir.Tags)
+	}
+	if err := ValidateSearchIR(ir); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"tags": ["error"]}`), &ir); err == nil {
+		t.Fatalf("expected an error for malformed tags")
+	}
+}
+
+func TestSearchIR_FanOutLimit(t *testing.T) {
+	ir := SearchIR{
+		Services:   []string{"a", "b", "c", "d", "e"},
+		Operations: []string{"1", "2", "3", "4"},
+	}
+	if err := ValidateSearchIR(ir); err == nil {
+		t.Fatalf("expected fan-out limit error")
+	}
+
+	ir = SearchIR{Services: []string{"a", ""}}
+	if err := ValidateSearchIR(ir); err == nil {
+		t.Fatalf("expected error for empty service name")
+	}
+}
@@ -7,3 +7,33 @@ type LLM interface {
 	ExplainTrace(ctx context.Context, context string) (string, error)
 	ExplainSpan(ctx context.Context, context string) (string, error)
 }
+
+// SearchIRRepairer is implemented by LLMs that can retry an extraction given
+// their previous output and what was wrong with it.
+type SearchIRRepairer interface {
+	RepairSearchIR(ctx context.Context, input, previous, problem string) (SearchIR, error)
+}
+
+// ExplanationStreamer is implemented by LLMs that can deliver explanations
+// piece by piece while they are generated. Each call to onChunk carries the
+// next piece; an error from onChunk aborts generation. The full text is
+// returned at the end.
+type ExplanationStreamer interface {
+	ExplainTraceStream(ctx context.Context, context string, onChunk func(chunk string) error) (string, error)
+	ExplainSpanStream(ctx context.Context, context string, onChunk func(chunk string) error) (string, error)
+}
+
+// ExtractionError reports LLM output that could not be turned into a
+// SearchIR. Output is kept so it can be fed back for repair.
+type ExtractionError struct {
+	Output string
+	Err    error
+}
+
+func (e *ExtractionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExtractionError) Unwrap() error {
+	return e.Err
+}
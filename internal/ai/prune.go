@@ -0,0 +1,322 @@
+package ai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+// defaultTraceContextTokens leaves room for the prompt and the answer in a
+// 4k-token model such as phi3:mini.
+const defaultTraceContextTokens = 2000
+
+// footerReserve is held back from the budget for the pruning note.
+const footerReserve = 48
+
+// criticalShare: a critical path span is kept ahead of other spans when it
+// holds at least 1/criticalShare of the trace's latency.
+const criticalShare = 20
+
+// maxCriticalSteps caps the critical path summary at the head of a context.
+const maxCriticalSteps = 8
+
+// maxResourceLines caps the distinct resources listed in a trace context.
+const maxResourceLines = 10
+
+// Stack traces are clipped to this many lines in the trace context, and to
+// maxSpanStackLines when a single span is explained.
+const (
+	maxStackLines     = 8
+	maxSpanStackLines = 40
+)
+
+// PruneReport describes how a trace context was cut down to its budget.
+type PruneReport struct {
+	TotalSpans int
+	// KeptSpans are rendered in full.
+	KeptSpans int
+	// CollapsedSpans were folded into Groups aggregate lines.
+	CollapsedSpans int
+	Groups         int
+	// DroppedSpans appear nowhere in the context.
+	DroppedSpans int
+	// EstimatedTokens is the estimated size of the final context.
+	EstimatedTokens int
+}
+
+// Pruned reports whether any span is missing from the full rendering.
+func (r PruneReport) Pruned() bool {
+	return r.CollapsedSpans+r.DroppedSpans > 0
+}
+
+// estimateTokens approximates the token count of s with the usual four
+// characters per token for English text and JSON-ish payloads.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// spanRef is a span with the resource that emitted it and its place in the
+// call tree.
+type spanRef struct {
+	res  pcommon.Resource
+	span ptrace.Span
+	node *traceutil.Node
+}
+
+// collectSpans lists the spans of tree depth first, parents before children,
+// which is the order the context renders them in.
+func collectSpans(tree *traceutil.Tree) []spanRef {
+	var spans []spanRef
+	tree.Walk(func(n *traceutil.Node) bool {
+		spans = append(spans, spanRef{res: n.Resource, span: n.Span, node: n})
+		return true
+	})
+	return spans
+}
+
+func (r spanRef) service() string {
+	if svc := traceutil.ServiceName(r.res, r.span); svc != "" {
+		return svc
+	}
+	return "unknown"
+}
+
+// spanGroup is a run of sibling spans with the same service and name.
+type spanGroup struct {
+	members []int
+	total   time.Duration
+}
+
+// prunePlan says, per span index, how the span appears in the context.
+type prunePlan struct {
+	keep []bool
+	// groupOf[i] is the group span i was collapsed into, or -1.
+	groupOf []int
+	groups  []spanGroup
+	// groupKept[g] is false when group g did not fit either.
+	groupKept []bool
+}
+
+// planPruning decides which spans of a trace fit into budget tokens, given
+// the cost of rendering each span in full. Priority goes, in order, to error
+// spans and their ancestors, the critical path, repeated siblings collapsed
+// into aggregate lines, and finally the remaining spans by duration.
+func planPruning(spans []spanRef, path []traceutil.CriticalSpan, cost []int, budget int, aggregateCost func(spanGroup) int) prunePlan {
+	n := len(spans)
+	plan := prunePlan{keep: make([]bool, n), groupOf: make([]int, n)}
+	for i := range plan.groupOf {
+		plan.groupOf[i] = -1
+	}
+
+	used := 0
+	take := func(i int) {
+		if !plan.keep[i] && used+cost[i] <= budget {
+			plan.keep[i] = true
+			used += cost[i]
+		}
+	}
+
+	index := make(map[pcommon.SpanID]int, n)
+	for i, s := range spans {
+		index[s.span.SpanID()] = i
+	}
+
+	// 1. Failing spans, then their ancestors so the model sees how the failure
+	// was reached.
+	priority := make([]bool, n)
+	for _, s := range spans {
+		if !isFailing(s.span) {
+			continue
+		}
+		for a := s.node; a != nil && !priority[index[a.Span.SpanID()]]; a = a.Parent {
+			priority[index[a.Span.SpanID()]] = true
+		}
+	}
+	for i := range spans {
+		if priority[i] && isFailing(spans[i].span) {
+			take(i)
+		}
+	}
+	for i := range spans {
+		if priority[i] {
+			take(i)
+		}
+	}
+
+	// 2. The critical path spans that hold a real share of the latency, with
+	// the ancestors they hang from, biggest contributors first. Long chains of
+	// tiny serial calls are left to grouping.
+	var total time.Duration
+	for _, c := range path {
+		total += c.Exclusive
+	}
+	major := make([]traceutil.CriticalSpan, 0, len(path))
+	for _, c := range path {
+		if c.Exclusive*criticalShare >= total {
+			major = append(major, c)
+		}
+	}
+	sort.SliceStable(major, func(a, b int) bool { return major[a].Exclusive > major[b].Exclusive })
+	for _, c := range major {
+		var chain []int
+		for a := c.Node; a != nil && !priority[index[a.Span.SpanID()]]; a = a.Parent {
+			chain = append(chain, index[a.Span.SpanID()])
+		}
+		for k := len(chain) - 1; k >= 0; k-- {
+			priority[chain[k]] = true
+			take(chain[k])
+		}
+	}
+
+	// 3. Repeated siblings outside the priority set become one line each.
+	byKey := map[string]int{}
+	for i, s := range spans {
+		if priority[i] {
+			continue
+		}
+		key := s.span.ParentSpanID().String() + "|" + s.service() + "|" + s.span.Name()
+		g, ok := byKey[key]
+		if !ok {
+			g = len(plan.groups)
+			byKey[key] = g
+			plan.groups = append(plan.groups, spanGroup{})
+		}
+		plan.groups[g].members = append(plan.groups[g].members, i)
+		plan.groups[g].total += traceutil.SpanDuration(s.span)
+	}
+
+	plan.groupKept = make([]bool, len(plan.groups))
+	order := make([]int, 0, len(plan.groups))
+	for g, grp := range plan.groups {
+		if len(grp.members) > 1 {
+			order = append(order, g)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return plan.groups[order[a]].total > plan.groups[order[b]].total
+	})
+	for _, g := range order {
+		for _, i := range plan.groups[g].members {
+			plan.groupOf[i] = g
+		}
+		if c := aggregateCost(plan.groups[g]); used+c <= budget {
+			plan.groupKept[g] = true
+			used += c
+		}
+	}
+
+	// 4. Everything else, longest first.
+	var rest []int
+	for i := range spans {
+		if !plan.keep[i] && !priority[i] && plan.groupOf[i] < 0 {
+			rest = append(rest, i)
+		}
+	}
+	sort.SliceStable(rest, func(a, b int) bool {
+		return traceutil.SpanDuration(spans[rest[a]].span) > traceutil.SpanDuration(spans[rest[b]].span)
+	})
+	for _, i := range rest {
+		take(i)
+	}
+
+	return plan
+}
+
+// renderCriticalPath summarises where the root's time went, in path order,
+// keeping the maxSteps biggest contributors, e.g.
+// "  catalog-svc ListProducts: 310ms (62%)".
+func renderCriticalPath(path []traceutil.CriticalSpan, maxSteps int) string {
+	var total time.Duration
+	var steps []int
+	for i, c := range path {
+		total += c.Exclusive
+		if c.Exclusive > 0 {
+			steps = append(steps, i)
+		}
+	}
+	if total <= 0 || maxSteps <= 0 {
+		return ""
+	}
+
+	shown := append([]int(nil), steps...)
+	if len(shown) > maxSteps {
+		sort.SliceStable(shown, func(a, b int) bool { return path[shown[a]].Exclusive > path[shown[b]].Exclusive })
+		shown = shown[:maxSteps]
+		sort.Ints(shown)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nCritical path (%v, time each span spends on it outside its children):\n", roundDuration(total))
+	var hidden time.Duration
+	for _, i := range shown {
+		c := path[i]
+		fmt.Fprintf(&b, "  %s %s: %v (%d%%)\n",
+			spanRef{res: c.Node.Resource, span: c.Node.Span}.service(), c.Node.Span.Name(),
+			roundDuration(c.Exclusive), int(100*c.Exclusive/total))
+		hidden -= c.Exclusive
+	}
+	if n := len(steps) - len(shown); n > 0 {
+		for _, i := range steps {
+			hidden += path[i].Exclusive
+		}
+		fmt.Fprintf(&b, "  ... %d more spans: %v\n", n, roundDuration(hidden))
+	}
+	return b.String()
+}
+
+// renderGroup summarises collapsed siblings, e.g.
+// "42x SELECT products | Service: catalog-db | p50 3ms, p99 20ms, max 25ms".
+// Error spans are never collapsed, so groups hold only successful calls.
+func renderGroup(spans []spanRef, g spanGroup) string {
+	first := spans[g.members[0]]
+	durations := make([]time.Duration, len(g.members))
+	for k, i := range g.members {
+		durations[k] = traceutil.SpanDuration(spans[i].span)
+	}
+	sort.Slice(durations, func(a, b int) bool { return durations[a] < durations[b] })
+
+	return fmt.Sprintf("\n%s[Group] %dx %s | Service: %s | p50 %v, p99 %v, max %v\n",
+		indent(first.node.Depth), len(g.members), first.span.Name(), first.service(),
+		roundDuration(percentile(durations, 50)),
+		roundDuration(percentile(durations, 99)),
+		roundDuration(durations[len(durations)-1]))
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d >= time.Millisecond {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// pruneNote tells the model what it is not seeing.
+func pruneNote(r PruneReport, budget int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n[Context pruned to ~%d tokens: %d of %d spans shown in full", budget, r.KeptSpans, r.TotalSpans)
+	if r.CollapsedSpans > 0 {
+		fmt.Fprintf(&b, ", %d collapsed into %d groups", r.CollapsedSpans, r.Groups)
+	}
+	if r.DroppedSpans > 0 {
+		fmt.Fprintf(&b, ", %d omitted", r.DroppedSpans)
+	}
+	b.WriteString("]\n")
+	return b.String()
+}
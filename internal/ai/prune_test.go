@@ -0,0 +1,153 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/synthetic"
+)
+
+var spanBase = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// addTestSpan appends a span to td under its own resource. IDs are small
+// integers; parent 0 means a root span.
+func addTestSpan(td ptrace.Traces, svc, name string, id, parent uint16, start, dur time.Duration) ptrace.Span {
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().PutStr("service.name", svc)
+	s := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	s.SetTraceID(pcommon.TraceID{15: 1})
+	s.SetSpanID(pcommon.SpanID{6: byte(id >> 8), 7: byte(id)})
+	if parent != 0 {
+		s.SetParentSpanID(pcommon.SpanID{6: byte(parent >> 8), 7: byte(parent)})
+	}
+	s.SetName(name)
+	s.SetStartTimestamp(pcommon.NewTimestampFromTime(spanBase.Add(start)))
+	s.SetEndTimestamp(pcommon.NewTimestampFromTime(spanBase.Add(start + dur)))
+	return s
+}
+
+// fanOutTrace is a request that issues 300 small queries, one of which
+// fails, before rendering the page.
+func fanOutTrace() ptrace.Traces {
+	td := ptrace.NewTraces()
+	addTestSpan(td, "frontend", "GET /products", 1, 0, 0, 500*time.Millisecond)
+	addTestSpan(td, "catalog-svc", "ListProducts", 2, 1, 5*time.Millisecond, 400*time.Millisecond)
+	for i := uint16(0); i < 300; i++ {
+		s := addTestSpan(td, "catalog-db", "SELECT products", 100+i, 2,
+			10*time.Millisecond+time.Duration(i)*time.Millisecond, time.Duration(1+i%20)*time.Millisecond)
+		s.Attributes().PutStr("db.system", "postgres")
+		if i == 150 {
+			s.Status().SetCode(ptrace.StatusCodeError)
+			s.Status().SetMessage("deadlock detected")
+		}
+	}
+	addTestSpan(td, "frontend", "render", 3, 1, 420*time.Millisecond, 70*time.Millisecond)
+	return td
+}
+
+func TestBuildTraceContext_PrunesLargeTrace(t *testing.T) {
+	const budget = 400
+	out, report := buildTraceContext(fanOutTrace(), budget, nil)
+
+	if !report.Pruned() {
+		t.Fatalf("expected pruning, got %+v", report)
+	}
+	if report.EstimatedTokens > budget {
+		t.Fatalf("context is %d tokens, over the %d budget", report.EstimatedTokens, budget)
+	}
+	if got := report.KeptSpans + report.CollapsedSpans + report.DroppedSpans; got != report.TotalSpans || got != 303 {
+		t.Fatalf("span accounting does not add up: %+v", report)
+	}
+
+	for _, want := range []string{
+		"Status: ERROR (deadlock detected)", // the error span
+		"Name: ListProducts",                // its ancestor
+		"Name: render",                      // the critical path
+		"[Group] 299x SELECT products | Service: catalog-db | p50 10ms, p99 20ms, max 20ms",
+		"[Context pruned to ~400 tokens: 4 of 303 spans shown in full, 299 collapsed into 1 groups]",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("context is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestBuildTraceContext_DropsWhatDoesNotFit(t *testing.T) {
+	_, report := buildTraceContext(fanOutTrace(), 150, nil)
+
+	if report.DroppedSpans == 0 {
+		t.Fatalf("expected dropped spans at a tiny budget, got %+v", report)
+	}
+	if report.KeptSpans == 0 {
+		t.Fatalf("the error span should still fit, got %+v", report)
+	}
+}
+
+func TestBuildTraceContext_SmallTraceUnchanged(t *testing.T) {
+	traces, err := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatalf("load bench traces: %v", err)
+	}
+
+	out, report := buildTraceContext(traces[0], defaultTraceContextTokens, nil)
+	if report.Pruned() || report.KeptSpans != report.TotalSpans {
+		t.Fatalf("bench traces fit the default budget, got %+v", report)
+	}
+	if strings.Contains(out, "[Context pruned") {
+		t.Fatalf("unexpected pruning note:\n%s", out)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var d []time.Duration
+	for i := 1; i <= 100; i++ {
+		d = append(d, time.Duration(i)*time.Millisecond)
+	}
+	if p := percentile(d, 50); p != 50*time.Millisecond {
+		t.Fatalf("p50 = %v", p)
+	}
+	if p := percentile(d, 99); p != 99*time.Millisecond {
+		t.Fatalf("p99 = %v", p)
+	}
+	if p := percentile(d[:1], 99); p != time.Millisecond {
+		t.Fatalf("single sample p99 = %v", p)
+	}
+}
+
+func TestBuildTraceContext_SpanTree(t *testing.T) {
+	td := ptrace.NewTraces()
+	addTestSpan(td, "frontend", "GET /checkout", 1, 0, 0, 100*time.Millisecond)
+	addTestSpan(td, "cart-svc", "LoadCart", 2, 1, 10*time.Millisecond, 30*time.Millisecond)
+	addTestSpan(td, "payment-svc", "Charge", 3, 1, -4*time.Millisecond, 20*time.Millisecond)
+	addTestSpan(td, "mailer", "SendReceipt", 4, 9, 60*time.Millisecond, 5*time.Millisecond)
+
+	out, _ := buildTraceContext(td, defaultTraceContextTokens, nil)
+
+	for _, want := range []string{
+		"Critical path (100ms, time each span spends on it outside its children):\n" +
+			"  frontend GET /checkout: 70ms (70%)\n" +
+			"  cart-svc LoadCart: 30ms (30%)\n",
+		"\n[Span] Name: GET /checkout | Service: frontend",
+		"  Start: +0s | Duration: 100ms | Self: 60ms\n",
+		"\n  [Span] Name: LoadCart | Service: cart-svc",
+		"    Start: +10ms | Duration: 30ms | Self: 30ms\n",
+		"    Note: clock skew, starts 4ms before its parent\n",
+		"  Start: -4ms",
+		"\n[Span] Name: SendReceipt | Service: mailer",
+		"  Note: orphan, parent span 0000000000000009 could not be linked\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("context is missing %q:\n%s", want, out)
+		}
+	}
+
+	// Children follow their parent in start order, orphans come last.
+	charge, load, orphan := strings.Index(out, "Name: Charge"), strings.Index(out, "Name: LoadCart"), strings.Index(out, "Name: SendReceipt")
+	if !(charge < load && load < orphan) {
+		t.Fatalf("unexpected span order:\n%s", out)
+	}
+}
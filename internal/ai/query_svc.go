@@ -2,138 +2,556 @@ package ai
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jaeger-ai-assist-prototype/internal"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 )
 
+// defaultSearchDepth is the per-sub-query cap when none is configured, the
+// Jaeger UI's default limit (jaeger.DefaultSearchDepth).
+const defaultSearchDepth = 20
+
 type AIQueryService struct {
 	LLM   LLM
 	Query *internal.QueryService
+
+	// Now anchors relative time expressions ("2h ago", "yesterday").
+	// Defaults to time.Now.
+	Now func() time.Time
+
+	// MaxExtractionAttempts bounds the extraction repair loop, counting the
+	// first attempt. Defaults to 3.
+	MaxExtractionAttempts int
+
+	// SearchDepth caps the traces returned per sub-query; 0 uses
+	// defaultSearchDepth.
+	SearchDepth int
+
+	// KeepPartialResults makes Search return the traces gathered before a
+	// reader failure, flagged as Partial, alongside the error.
+	KeepPartialResults bool
+
+	// TraceContextTokens is the estimated token budget for the trace context
+	// in explanation prompts. Larger traces are pruned; 0 uses 2000.
+	TraceContextTokens int
+
+	// Attributes selects the attributes shown in explanation prompts; nil
+	// uses DefaultAttributeAllow.
+	Attributes *AttributePolicy
+
+	// Logger records searches and explanations; nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+func (s *AIQueryService) log() *slog.Logger {
+	return logging.OrDefault(s.Logger)
+}
+
+func (s *AIQueryService) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
 }
 
 func (s *AIQueryService) Search(
 	ctx context.Context,
 	text string,
 ) (SearchResult, error) {
-	ir, err := s.LLM.ExtractSearchIR(ctx, text)
+	began := time.Now()
+	now := s.now()
+
+	ir, attempts, err := s.extractSearchIR(ctx, text, now)
 	if err != nil {
-		return SearchResult{}, err
+		s.log().WarnContext(ctx, "extraction failed", "attempts", len(attempts), "duration", time.Since(began), "error", err)
+		return SearchResult{Attempts: attempts, Elapsed: time.Since(began)}, err
 	}
 
-	if err := ValidateSearchIR(ir); err != nil {
-		return SearchResult{}, err
-	}
+	subs := ir.SubQueries()
 
-	qp, err := MapIRToQueryParams(ir)
-	if err != nil {
-		return SearchResult{}, err
+	params := make([]internal.TraceQueryParams, len(subs))
+	for i, sub := range subs {
+		qp, err := MapIRToQueryParamsAt(sub, now)
+		if err != nil {
+			return SearchResult{Attempts: attempts, Elapsed: time.Since(began)}, err
+		}
+		if qp.SearchDepth == 0 {
+			qp.SearchDepth = s.SearchDepth
+		}
+		if qp.SearchDepth <= 0 {
+			qp.SearchDepth = defaultSearchDepth
+		}
+		params[i] = qp
 	}
 
-	iter := s.Query.FindTraces(ctx, qp)
+	found := make([][]ptrace.Traces, len(subs))
+	batches := make([]int, len(subs))
+	errs := make([]error, len(subs))
 
-	var result SearchResult
+	// Unless partial results are kept, the first failure cancels the other
+	// sub-queries and is the only one reported.
+	qctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu     sync.Mutex
+		failed bool
+	)
 
-	iter(func(batch []ptrace.Traces, err error) bool {
+	var wg sync.WaitGroup
+	for i := range subs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// One trace past the depth tells a capped result from one
+			// that happens to fill it; it is dropped below.
+			probe := params[i]
+			probe.SearchDepth++
+			iter := s.Query.FindTraces(qctx, probe)
+			iter(func(batch []ptrace.Traces, err error) bool {
+				if err != nil {
+					mu.Lock()
+					if s.KeepPartialResults || !failed {
+						errs[i] = err
+						failed = true
+						if !s.KeepPartialResults {
+							cancel()
+						}
+					}
+					mu.Unlock()
+					return false
+				}
+				batches[i]++
+				found[i] = append(found[i], batch...)
+				return true
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	result := SearchResult{
+		IR:          ir,
+		SubQueries:  subs,
+		QueryParams: params,
+		Attempts:    attempts,
+	}
+
+	var failures []error
+	for i, err := range errs {
 		if err != nil {
-			result = SearchResult{}
-			return false
+			s.log().WarnContext(ctx, "trace query failed", "sub_query", i, "service", params[i].ServiceName, "error", err)
+			if len(subs) > 1 {
+				err = fmt.Errorf("sub-query %d: %w", i, err)
+			}
+			failures = append(failures, err)
 		}
+		result.Batches += batches[i]
+		if depth := params[i].SearchDepth; len(found[i]) > depth {
+			found[i] = found[i][:depth]
+			result.Truncated = true
+		}
+	}
 
-		result.Traces = append(result.Traces, batch...)
-		return true
-	})
+	if len(failures) > 0 {
+		err := fmt.Errorf("trace search failed: %w", errors.Join(failures...))
+		if s.KeepPartialResults {
+			result.Partial = true
+			mergeFound(&result, found)
+		}
+		result.Elapsed = time.Since(began)
+		s.logSearch(ctx, result)
+		return result, err
+	}
 
+	mergeFound(&result, found)
+	result.Elapsed = time.Since(began)
+	s.logSearch(ctx, result)
 	return result, nil
 }
 
+func (s *AIQueryService) logSearch(ctx context.Context, r SearchResult) {
+	s.log().InfoContext(ctx, "search",
+		"attempts", len(r.Attempts),
+		"sub_queries", len(r.SubQueries),
+		"traces", len(r.Traces),
+		"batches", r.Batches,
+		"truncated", r.Truncated,
+		"partial", r.Partial,
+		"duration", r.Elapsed)
+}
+
+// mergeFound merges per-sub-query traces in sub-query order so the output is
+// deterministic, keeping the first copy of every trace and recording each
+// sub-query that hit it.
+func mergeFound(result *SearchResult, found [][]ptrace.Traces) {
+	index := map[pcommon.TraceID]int{}
+	for i, traces := range found {
+		for _, t := range traces {
+			id := traceutil.TraceID(t)
+			if at, ok := index[id]; ok {
+				if m := result.MatchedBy[at]; m[len(m)-1] != i {
+					result.MatchedBy[at] = append(m, i)
+				}
+				continue
+			}
+			index[id] = len(result.Traces)
+			result.Traces = append(result.Traces, t)
+			result.MatchedBy = append(result.MatchedBy, []int{i})
+		}
+	}
+}
+
+// GetTrace looks a trace up by its hex ID, as printed by a search or shown in
+// the Jaeger UI. Malformed IDs fail with traceutil.ErrInvalidTraceID and
+// unknown ones with internal.ErrTraceNotFound.
+func (s *AIQueryService) GetTrace(ctx context.Context, hexID string) (ptrace.Traces, error) {
+	id, err := traceutil.ParseTraceID(hexID)
+	if err != nil {
+		return ptrace.Traces{}, err
+	}
+	trace, err := s.Query.GetTrace(ctx, id)
+	if err != nil {
+		return ptrace.Traces{}, fmt.Errorf("trace %s: %w", id, err)
+	}
+	return trace, nil
+}
+
 func (s *AIQueryService) ExplainTrace(
 	ctx context.Context,
 	trace ptrace.Traces,
 ) (string, error) {
-	ctxData := buildTraceContext(trace)
+	ctxData := s.traceContext(ctx, trace)
 	return s.LLM.ExplainTrace(ctx, ctxData)
 }
 
 func (s *AIQueryService) ExplainSpan(
 	ctx context.Context,
 	span ptrace.Span,
+	res pcommon.Resource,
 	serviceName string,
 ) (string, error) {
-	ctxData := buildSpanContext(span, serviceName)
+	ctxData := buildSpanContext(span, res, serviceName, s.Attributes)
 	return s.LLM.ExplainSpan(ctx, ctxData)
 }
 
-func buildTraceContext(t ptrace.Traces) string {
+// ExplainTraceStream is ExplainTrace with the explanation passed to onChunk
+// as it is generated. LLMs that cannot stream deliver it as one chunk. A nil
+// onChunk is the same as ExplainTrace.
+func (s *AIQueryService) ExplainTraceStream(
+	ctx context.Context,
+	trace ptrace.Traces,
+	onChunk func(chunk string) error,
+) (string, error) {
+	ctxData := s.traceContext(ctx, trace)
+	if st, ok := s.LLM.(ExplanationStreamer); ok && onChunk != nil {
+		return st.ExplainTraceStream(ctx, ctxData, onChunk)
+	}
+	out, err := s.LLM.ExplainTrace(ctx, ctxData)
+	return deliverWhole(out, err, onChunk)
+}
+
+// ExplainSpanStream is ExplainSpan with the explanation passed to onChunk as
+// it is generated. A nil onChunk is the same as ExplainSpan.
+func (s *AIQueryService) ExplainSpanStream(
+	ctx context.Context,
+	span ptrace.Span,
+	res pcommon.Resource,
+	serviceName string,
+	onChunk func(chunk string) error,
+) (string, error) {
+	ctxData := buildSpanContext(span, res, serviceName, s.Attributes)
+	if st, ok := s.LLM.(ExplanationStreamer); ok && onChunk != nil {
+		return st.ExplainSpanStream(ctx, ctxData, onChunk)
+	}
+	out, err := s.LLM.ExplainSpan(ctx, ctxData)
+	return deliverWhole(out, err, onChunk)
+}
+
+// deliverWhole hands a non-streamed explanation to onChunk in one piece.
+func deliverWhole(out string, err error, onChunk func(chunk string) error) (string, error) {
+	if err != nil || onChunk == nil {
+		return out, err
+	}
+	if err := onChunk(out); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// traceContext is TraceContext, logging how much of the trace was kept.
+func (s *AIQueryService) traceContext(ctx context.Context, trace ptrace.Traces) string {
+	out, report := s.TraceContext(trace)
+	level := slog.LevelDebug
+	if report.Pruned() {
+		level = slog.LevelInfo
+	}
+	s.log().Log(ctx, level, "trace context",
+		"trace_id", traceutil.TraceID(trace).String(),
+		"spans", report.TotalSpans,
+		"kept", report.KeptSpans,
+		"collapsed", report.CollapsedSpans,
+		"dropped", report.DroppedSpans,
+		"tokens", report.EstimatedTokens)
+	return out
+}
+
+// TraceContext renders trace for the explanation prompt, pruned to
+// TraceContextTokens, and reports what was left out.
+func (s *AIQueryService) TraceContext(trace ptrace.Traces) (string, PruneReport) {
+	budget := s.TraceContextTokens
+	if budget <= 0 {
+		budget = defaultTraceContextTokens
+	}
+	return buildTraceContext(trace, budget, s.Attributes)
+}
+
+// buildTraceContext renders t for the prompt: root cause candidates, the
+// critical path, resources, then one indented block per span with the
+// attributes attrs allows. Over budget tokens, planPruning picks the spans
+// that stay and a closing note lists what was cut.
+func buildTraceContext(t ptrace.Traces, budget int, attrs *AttributePolicy) (string, PruneReport) {
+	const header = "Trace Analysis Context:\n" +
+		"(children nested under parents; Start: offset from root; Self: time outside children)\n"
+
+	tree := traceutil.BuildTree(t)
+	origin := tree.Start()
+	path := tree.CriticalPath()
+	causes := rankRootCauses(tree)
+
+	// The summary shrinks to a quarter of the budget, giving up critical path
+	// steps before root cause candidates.
+	nCauses, steps := maxPromptRootCauses, maxCriticalSteps
+	resources := renderResources(tree, attrs)
+	summary := header + renderRootCauses(causes, nCauses) + renderCriticalPath(path, steps) + resources
+	for estimateTokens(summary) > budget/4 && (steps > 0 || nCauses > 0) {
+		if steps > 0 {
+			steps--
+		} else {
+			nCauses--
+		}
+		summary = header + renderRootCauses(causes, nCauses) + renderCriticalPath(path, steps) + resources
+	}
+
+	spans := collectSpans(tree)
+	lines := make([]string, len(spans))
+	cost := make([]int, len(spans))
+	total := estimateTokens(summary)
+	for i, s := range spans {
+		lines[i] = renderTraceSpan(s, origin, attrs)
+		cost[i] = estimateTokens(lines[i])
+		total += cost[i]
+	}
+
+	report := PruneReport{TotalSpans: len(spans), KeptSpans: len(spans), EstimatedTokens: total}
+	if total <= budget {
+		return summary + strings.Join(lines, ""), report
+	}
+
+	groupLines := map[int]string{}
+	plan := planPruning(spans, path, cost, budget-estimateTokens(summary)-footerReserve, func(g spanGroup) int {
+		return estimateTokens(renderGroup(spans, g))
+	})
+
 	b := strings.Builder{}
-	b.WriteString("Trace Analysis Context:\n")
-
-	rs := t.ResourceSpans()
-	for i := 0; i < rs.Len(); i++ {
-		res := rs.At(i)
-		svc, _ := res.Resource().Attributes().Get("service.name")
-
-		ss := res.ScopeSpans()
-		for j := 0; j < ss.Len(); j++ {
-			spans := ss.At(j).Spans()
-			for k := 0; k < spans.Len(); k++ {
-				span := spans.At(k)
-
-				// Basic Info
-				b.WriteString(fmt.Sprintf("\n[Span] Name: %s | Service: %s | Kind: %s\n",
-					span.Name(), svc.Str(), span.Kind().String()))
-				b.WriteString(fmt.Sprintf("  Duration: %dms\n",
-					span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime()).Milliseconds()))
-
-				// CRITICAL: Include HTTP and Error Attributes
-				span.Attributes().Range(func(k string, v pcommon.Value) bool {
-					if strings.HasPrefix(k, "http.") || k == "db.system" || k == "error" {
-						b.WriteString(fmt.Sprintf("  Tag: %s = %s\n", k, v.AsString()))
-					}
-					return true
-				})
+	b.WriteString(summary)
+	report.KeptSpans = 0
+	for i := range spans {
+		switch g := plan.groupOf[i]; {
+		case plan.keep[i]:
+			b.WriteString(lines[i])
+			report.KeptSpans++
+		case g >= 0 && plan.groupKept[g]:
+			if _, done := groupLines[g]; !done {
+				groupLines[g] = renderGroup(spans, plan.groups[g])
+				b.WriteString(groupLines[g])
+				report.Groups++
+			}
+			report.CollapsedSpans++
+		default:
+			report.DroppedSpans++
+		}
+	}
+	b.WriteString(pruneNote(report, budget))
 
-				// Status Detail
-				if span.Status().Code() == ptrace.StatusCodeError {
-					b.WriteString(fmt.Sprintf("  Status: ERROR (%s)\n", span.Status().Message()))
-				}
+	out := b.String()
+	report.EstimatedTokens = estimateTokens(out)
+	return out, report
+}
+
+func renderTraceSpan(s spanRef, origin time.Time, attrs *AttributePolicy) string {
+	b := strings.Builder{}
+	span := s.span
+	pad := indent(s.node.Depth)
+
+	// Basic Info
+	b.WriteString(fmt.Sprintf("\n%s[Span] Name: %s | Service: %s | Kind: %s\n",
+		pad, span.Name(), s.service(), span.Kind().String()))
+	b.WriteString(fmt.Sprintf("%s  Start: %s | Duration: %v | Self: %v\n",
+		pad,
+		formatOffset(span.StartTimestamp().AsTime().Sub(origin)),
+		roundDuration(s.node.Duration()),
+		roundDuration(s.node.SelfTime())))
+
+	// Structure problems the model should not read as latency.
+	if s.node.Orphan {
+		b.WriteString(fmt.Sprintf("%s  Note: orphan, parent span %s could not be linked\n",
+			pad, span.ParentSpanID()))
+	}
+	if skew := s.node.ClockSkew(); skew > 0 {
+		b.WriteString(fmt.Sprintf("%s  Note: clock skew, starts %v before its parent\n",
+			pad, roundDuration(skew)))
+	}
+
+	// CRITICAL: Include the attributes the policy allows
+	span.Attributes().Range(func(k string, v pcommon.Value) bool {
+		if attrs.Allows(k) {
+			b.WriteString(fmt.Sprintf("%s  Tag: %s = %s\n", pad, k, clipLines(v.AsString(), maxStackLines)))
+		}
+		return true
+	})
+
+	// Status Detail
+	if traceutil.IsError(span) {
+		b.WriteString(fmt.Sprintf("%s  Status: ERROR (%s)\n", pad, span.Status().Message()))
+	}
+
+	// Events carry exceptions and logs; links point at related traces.
+	events := span.Events()
+	for i := 0; i < events.Len(); i++ {
+		ev := events.At(i)
+		b.WriteString(fmt.Sprintf("%s  Event: +%v %s\n",
+			pad, roundDuration(ev.Timestamp().AsTime().Sub(span.StartTimestamp().AsTime())), ev.Name()))
+		ev.Attributes().Range(func(k string, v pcommon.Value) bool {
+			if attrs.Allows(k) {
+				b.WriteString(fmt.Sprintf("%s    %s = %s\n", pad, k, clipLines(v.AsString(), maxStackLines)))
+			}
+			return true
+		})
+	}
+	links := span.Links()
+	for i := 0; i < links.Len(); i++ {
+		l := links.At(i)
+		b.WriteString(fmt.Sprintf("%s  Link: trace %s span %s\n", pad, l.TraceID(), l.SpanID()))
+		l.Attributes().Range(func(k string, v pcommon.Value) bool {
+			if attrs.Allows(k) {
+				b.WriteString(fmt.Sprintf("%s    %s = %s\n", pad, k, v.AsString()))
 			}
+			return true
+		})
+	}
+	return b.String()
+}
+
+// renderResources lists the allowed resource attributes once per distinct
+// resource, e.g. "  payment-svc: service.version=1.4.2, k8s.pod.name=pay-7d9".
+func renderResources(tree *traceutil.Tree, attrs *AttributePolicy) string {
+	var lines []string
+	seen := map[string]bool{}
+	tree.Walk(func(n *traceutil.Node) bool {
+		var kv []string
+		n.Resource.Attributes().Range(func(k string, v pcommon.Value) bool {
+			if k != "service.name" && attrs.Allows(k) {
+				kv = append(kv, k+"="+v.AsString())
+			}
+			return true
+		})
+		if len(kv) == 0 {
+			return true
 		}
+		sort.Strings(kv)
+		line := fmt.Sprintf("  %s: %s\n", spanRef{res: n.Resource, span: n.Span}.service(), strings.Join(kv, ", "))
+		if !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+		return true
+	})
+	if len(lines) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\nResources:\n")
+	for i, line := range lines {
+		if i == maxResourceLines {
+			fmt.Fprintf(&b, "  ... %d more\n", len(lines)-i)
+			break
+		}
+		b.WriteString(line)
 	}
 	return b.String()
 }
 
-func buildSpanContext(span ptrace.Span, serviceName string) string {
+// clipLines keeps the first n lines of s, so a stack trace shows where it
+// was thrown without flooding the context.
+func clipLines(s string, n int) string {
+	lines := strings.SplitN(s, "\n", n+1)
+	if len(lines) <= n {
+		return s
+	}
+	rest := strings.Count(lines[n], "\n") + 1
+	return strings.Join(lines[:n], "\n") + fmt.Sprintf("\n... (%d more lines)", rest)
+}
+
+// formatOffset renders an offset from the root with its sign, e.g. "+12ms";
+// orphans from a skewed host can start before the root.
+func formatOffset(d time.Duration) string {
+	if d < 0 {
+		return "-" + roundDuration(-d).String()
+	}
+	return "+" + roundDuration(d).String()
+}
+
+// indent nests a span block under its parent.
+func indent(depth int) string {
+	return strings.Repeat("  ", depth)
+}
+
+func buildSpanContext(span ptrace.Span, res pcommon.Resource, serviceName string, attrs *AttributePolicy) string {
 	b := strings.Builder{}
 	b.WriteString("### Detailed Span Analysis\n")
 	b.WriteString(fmt.Sprintf("Operation: %s\n", span.Name()))
 	b.WriteString(fmt.Sprintf("Service: %s\n", serviceName))
 	b.WriteString(fmt.Sprintf("Kind: %s\n", span.Kind().String()))
 
-	duration := span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime())
+	duration := traceutil.SpanDuration(span)
 	b.WriteString(fmt.Sprintf("Duration: %v\n", duration))
 
-	// 1. Attributes: Keep technical context
+	// 1. Attributes: Keep the technical context the policy allows
 	b.WriteString("\nAttributes:\n")
 	span.Attributes().Range(func(k string, v pcommon.Value) bool {
-		// Include HTTP, DB, RPC, Messaging, and Error tags
-		isTechnical := strings.HasPrefix(k, "http.") ||
-			strings.HasPrefix(k, "db.") ||
-			strings.HasPrefix(k, "rpc.") ||
-			strings.HasPrefix(k, "messaging.") ||
-			k == "error" || k == "exception.type"
-
-		if isTechnical {
+		if attrs.Allows(k) {
 			b.WriteString(fmt.Sprintf("- %s: %s\n", k, valueToString(v)))
 		}
 		return true
 	})
 
+	// Where it ran: version, host, pod.
+	var resAttrs []string
+	if res != (pcommon.Resource{}) {
+		res.Attributes().Range(func(k string, v pcommon.Value) bool {
+			if k != "service.name" && attrs.Allows(k) {
+				resAttrs = append(resAttrs, fmt.Sprintf("- %s: %s\n", k, valueToString(v)))
+			}
+			return true
+		})
+	}
+	if len(resAttrs) > 0 {
+		b.WriteString("\nResource:\n")
+		b.WriteString(strings.Join(resAttrs, ""))
+	}
+
 	// 2. Status: Ensure errors are loud and clear
-	if span.Status().Code() == ptrace.StatusCodeError {
+	if traceutil.IsError(span) {
 		b.WriteString("\n[!] Status: ERROR\n")
 		b.WriteString(fmt.Sprintf("[!] Error Message: %s\n", span.Status().Message()))
 	}
@@ -148,20 +566,30 @@ func buildSpanContext(span ptrace.Span, serviceName string) string {
 
 			// Include event-specific attributes (like stack traces)
 			event.Attributes().Range(func(k string, v pcommon.Value) bool {
-				b.WriteString(fmt.Sprintf("  └ %s: %s\n", k, v.AsString()))
+				if attrs.Allows(k) {
+					b.WriteString(fmt.Sprintf("  └ %s: %s\n", k, clipLines(v.AsString(), maxSpanStackLines)))
+				}
 				return true
 			})
 		}
 	}
 
-	return b.String()
-}
-
-func getService(span ptrace.Span) string {
-	if v, ok := span.Attributes().Get("service.name"); ok {
-		return v.Str()
+	// 4. Links: related traces, e.g. the producer of a consumed message
+	if span.Links().Len() > 0 {
+		b.WriteString("\nLinks:\n")
+		for i := 0; i < span.Links().Len(); i++ {
+			link := span.Links().At(i)
+			b.WriteString(fmt.Sprintf("- trace %s span %s\n", link.TraceID(), link.SpanID()))
+			link.Attributes().Range(func(k string, v pcommon.Value) bool {
+				if attrs.Allows(k) {
+					b.WriteString(fmt.Sprintf("  └ %s: %s\n", k, v.AsString()))
+				}
+				return true
+			})
+		}
 	}
-	return "unknown"
+
+	return b.String()
 }
 
 func valueToString(v pcommon.Value) string {
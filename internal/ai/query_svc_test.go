@@ -2,10 +2,15 @@ package ai
 
 import (
 	"context"
+	"errors"
+	"iter"
 	"testing"
+	"time"
 
 	"github.com/jaeger-ai-assist-prototype/internal"
 	"github.com/jaeger-ai-assist-prototype/internal/synthetic"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 )
 
@@ -24,6 +29,14 @@ func (f *FakeLLM) ExtractSearchIR(
 	return f.IR, nil
 }
 
+func (f *FakeLLM) ExplainTrace(ctx context.Context, context string) (string, error) {
+	return "", f.Err
+}
+
+func (f *FakeLLM) ExplainSpan(ctx context.Context, context string) (string, error) {
+	return "", f.Err
+}
+
 func TestAIQueryService_Search_ServiceFilter(t *testing.T) {
 	traces := synthetic.GenerateTraces(5)
 	reader := synthetic.NewSyntheticTraceReader(traces)
@@ -31,7 +44,7 @@ func TestAIQueryService_Search_ServiceFilter(t *testing.T) {
 
 	fakeLLM := &FakeLLM{
 		IR: SearchIR{
-			Service: strptr("payment-service"),
+			Service: strptr("payment-svc"),
 		},
 	}
 
@@ -50,28 +63,14 @@ func TestAIQueryService_Search_ServiceFilter(t *testing.T) {
 	}
 
 	for _, trace := range result.Traces {
-		if !traceContainsService(trace, "payment-service") {
+		if !traceContainsService(trace, "payment-svc") {
 			t.Fatalf("returned trace does not contain expected service")
 		}
 	}
 }
 
 func traceContainsService(t ptrace.Traces, service string) bool {
-	rs := t.ResourceSpans()
-	for i := 0; i < rs.Len(); i++ {
-		ss := rs.At(i).ScopeSpans()
-		for j := 0; j < ss.Len(); j++ {
-			spans := ss.At(j).Spans()
-			for k := 0; k < spans.Len(); k++ {
-				if v, ok := spans.At(k).Attributes().Get("service.name"); ok {
-					if v.Str() == service {
-						return true
-					}
-				}
-			}
-		}
-	}
-	return false
+	return traceutil.HasService(t, service)
 }
 
 func TestAIQueryService_Search_InvalidIR(t *testing.T) {
@@ -81,7 +80,7 @@ func TestAIQueryService_Search_InvalidIR(t *testing.T) {
 
 	fakeLLM := &FakeLLM{
 		IR: SearchIR{
-			MinDurationMs: int64ptr(-10),
+			MinDurationMs: strptr("-10ms"),
 		},
 	}
 
@@ -103,7 +102,7 @@ func TestAIQueryService_Search_DurationFilter(t *testing.T) {
 
 	fakeLLM := &FakeLLM{
 		IR: SearchIR{
-			MinDurationMs: int64ptr(50),
+			MinDurationMs: strptr("50ms"),
 		},
 	}
 
@@ -121,3 +120,339 @@ func TestAIQueryService_Search_DurationFilter(t *testing.T) {
 		t.Fatalf("expected traces matching duration filter")
 	}
 }
+
+func benchQueryService(t *testing.T) *internal.QueryService {
+	t.Helper()
+	traces, err := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatalf("load bench traces: %v", err)
+	}
+	return internal.NewQueryService(synthetic.NewSyntheticTraceReader(traces))
+}
+
+func TestAIQueryService_Search_FanOut(t *testing.T) {
+	aiSvc := &AIQueryService{
+		LLM: &FakeLLM{
+			IR: SearchIR{
+				Service:  strptr("payment-svc"),
+				Services: []string{"catalog-svc", "frontend"},
+			},
+		},
+		Query:       benchQueryService(t),
+		SearchDepth: 100,
+	}
+
+	result, err := aiSvc.Search(context.Background(), "ignored")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.SubQueries) != 3 {
+		t.Fatalf("expected 3 sub-queries, got %d", len(result.SubQueries))
+	}
+
+	// frontend is on every trace, so the union is the whole bench with no
+	// duplicates.
+	if len(result.Traces) != 100 {
+		t.Fatalf("expected 100 de-duplicated traces, got %d", len(result.Traces))
+	}
+	if len(result.MatchedBy) != len(result.Traces) {
+		t.Fatalf("MatchedBy must parallel Traces")
+	}
+
+	seen := map[string]bool{}
+	for i, trace := range result.Traces {
+		id := traceutil.TraceID(trace).String()
+		if seen[id] {
+			t.Fatalf("trace %s returned twice", id)
+		}
+		seen[id] = true
+
+		for _, q := range result.MatchedBy[i] {
+			svc := *result.SubQueries[q].Service
+			if !traceutil.HasService(trace, svc) {
+				t.Fatalf("trace %s attributed to sub-query %q it does not match", id, svc)
+			}
+		}
+		if traceutil.HasService(trace, "payment-svc") && len(result.MatchedBy[i]) != 2 {
+			t.Fatalf("checkout trace %s should match payment-svc and frontend, got %v", id, result.MatchedBy[i])
+		}
+	}
+}
+
+func TestAIQueryService_Search_FanOutOperations(t *testing.T) {
+	aiSvc := &AIQueryService{
+		LLM: &FakeLLM{
+			IR: SearchIR{
+				Operations: []string{"Authorize", "GetItems", "Authorize"},
+			},
+		},
+		Query:       benchQueryService(t),
+		SearchDepth: 100,
+	}
+
+	result, err := aiSvc.Search(context.Background(), "ignored")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.SubQueries) != 2 {
+		t.Fatalf("expected duplicate operations to collapse into 2 sub-queries, got %d", len(result.SubQueries))
+	}
+	if len(result.Traces) != 67 {
+		t.Fatalf("expected 34 checkout + 33 catalog traces, got %d", len(result.Traces))
+	}
+}
+
+// blockingReader fails queries for one service and holds every other query
+// open until its context is cancelled.
+type blockingReader struct {
+	failService string
+	err         error
+}
+
+func (r *blockingReader) FindTraces(ctx context.Context, query internal.TraceQueryParams) iter.Seq2[[]ptrace.Traces, error] {
+	return func(yield func([]ptrace.Traces, error) bool) {
+		if query.ServiceName == r.failService {
+			yield(nil, r.err)
+			return
+		}
+		<-ctx.Done()
+		yield(nil, ctx.Err())
+	}
+}
+
+func (r *blockingReader) GetTrace(ctx context.Context, id pcommon.TraceID) (ptrace.Traces, error) {
+	return ptrace.Traces{}, r.err
+}
+
+func TestAIQueryService_Search_FanOutFailure(t *testing.T) {
+	backendErr := errors.New("backend unavailable")
+	aiSvc := &AIQueryService{
+		LLM: &FakeLLM{
+			IR: SearchIR{
+				Service:  strptr("payment-svc"),
+				Services: []string{"catalog-svc", "frontend"},
+			},
+		},
+		Query: internal.NewQueryService(&blockingReader{failService: "catalog-svc", err: backendErr}),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := aiSvc.Search(context.Background(), "ignored")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, backendErr) {
+			t.Fatalf("got %v, want the failing sub-query's error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the failure did not cancel the other sub-queries")
+	}
+}
+
+// failingReader yields the first traces of the bench one per batch, then
+// fails.
+type failingReader struct {
+	traces []ptrace.Traces
+	err    error
+}
+
+func (r *failingReader) FindTraces(ctx context.Context, query internal.TraceQueryParams) iter.Seq2[[]ptrace.Traces, error] {
+	return func(yield func([]ptrace.Traces, error) bool) {
+		for _, t := range r.traces {
+			if !yield([]ptrace.Traces{t}, nil) {
+				return
+			}
+		}
+		yield(nil, r.err)
+	}
+}
+
+func (r *failingReader) GetTrace(ctx context.Context, id pcommon.TraceID) (ptrace.Traces, error) {
+	return ptrace.Traces{}, r.err
+}
+
+func failingQueryService(t *testing.T, n int, err error) *internal.QueryService {
+	t.Helper()
+	traces, loadErr := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if loadErr != nil {
+		t.Fatalf("load bench traces: %v", loadErr)
+	}
+	return internal.NewQueryService(&failingReader{traces: traces[:n], err: err})
+}
+
+func TestAIQueryService_Search_ReaderErrorSurfaces(t *testing.T) {
+	storageErr := errors.New("storage unavailable")
+	aiSvc := &AIQueryService{
+		LLM:   &FakeLLM{},
+		Query: failingQueryService(t, 3, storageErr),
+	}
+
+	result, err := aiSvc.Search(context.Background(), "ignored")
+	if !errors.Is(err, storageErr) {
+		t.Fatalf("expected storage error, got %v", err)
+	}
+	if result.Partial || len(result.Traces) != 0 {
+		t.Fatalf("partial results must not be kept by default: %d traces, partial=%v", len(result.Traces), result.Partial)
+	}
+}
+
+func TestAIQueryService_Search_KeepPartialResults(t *testing.T) {
+	storageErr := errors.New("storage unavailable")
+	aiSvc := &AIQueryService{
+		LLM:                &FakeLLM{},
+		Query:              failingQueryService(t, 3, storageErr),
+		KeepPartialResults: true,
+	}
+
+	result, err := aiSvc.Search(context.Background(), "ignored")
+	if !errors.Is(err, storageErr) {
+		t.Fatalf("expected storage error, got %v", err)
+	}
+	if !result.Partial || len(result.Traces) != 3 || result.Batches != 3 {
+		t.Fatalf("expected 3 partial traces in 3 batches, got %d traces, %d batches, partial=%v",
+			len(result.Traces), result.Batches, result.Partial)
+	}
+}
+
+func TestAIQueryService_Search_CancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	aiSvc := &AIQueryService{LLM: &FakeLLM{}, Query: benchQueryService(t)}
+
+	if _, err := aiSvc.Search(ctx, "ignored"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestAIQueryService_Search_Metadata(t *testing.T) {
+	aiSvc := &AIQueryService{
+		LLM: &FakeLLM{IR: SearchIR{
+			Operation:     strptr("Authorize"),
+			MinDurationMs: strptr("100ms"),
+		}},
+		Query:       benchQueryService(t),
+		SearchDepth: 10,
+	}
+
+	result, err := aiSvc.Search(context.Background(), "ignored")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.QueryParams) != 1 {
+		t.Fatalf("expected the query params of one sub-query, got %d", len(result.QueryParams))
+	}
+	qp := result.QueryParams[0]
+	if qp.OperationName != "Authorize" || qp.DurationMin != 100*time.Millisecond || qp.SearchDepth != 10 {
+		t.Fatalf("unexpected query params %+v", qp)
+	}
+	// One trace past the depth is read to detect the cap.
+	if len(result.Traces) != 10 || result.Batches != 11 || !result.Truncated {
+		t.Fatalf("expected 10 traces in 11 batches, truncated; got %d, %d, %v",
+			len(result.Traces), result.Batches, result.Truncated)
+	}
+	if result.Elapsed <= 0 {
+		t.Fatalf("expected elapsed time to be recorded")
+	}
+}
+
+func TestAIQueryService_Search_Truncated(t *testing.T) {
+	// 34 bench traces go through payment-svc.
+	for _, tt := range []struct {
+		name      string
+		depth     int
+		traces    int
+		truncated bool
+	}{
+		{"default depth", 0, defaultSearchDepth, true},
+		{"exactly depth matches", 34, 34, false},
+		{"fewer matches", 50, 34, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			aiSvc := &AIQueryService{
+				LLM:         &FakeLLM{IR: SearchIR{Service: strptr("payment-svc")}},
+				Query:       benchQueryService(t),
+				SearchDepth: tt.depth,
+			}
+			result, err := aiSvc.Search(context.Background(), "ignored")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result.Traces) != tt.traces || result.Truncated != tt.truncated {
+				t.Fatalf("got %d traces, truncated %v; want %d, %v",
+					len(result.Traces), result.Truncated, tt.traces, tt.truncated)
+			}
+			if tt.depth == 0 && result.QueryParams[0].SearchDepth != defaultSearchDepth {
+				t.Fatalf("the effective depth must be reported, got %d", result.QueryParams[0].SearchDepth)
+			}
+		})
+	}
+}
+
+func TestAIQueryService_GetTrace(t *testing.T) {
+	traces, err := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatalf("load bench traces: %v", err)
+	}
+	aiSvc := &AIQueryService{Query: internal.NewQueryService(synthetic.NewSyntheticTraceReader(traces))}
+	want := traceutil.TraceID(traces[2])
+
+	trace, err := aiSvc.GetTrace(context.Background(), want.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := traceutil.TraceID(trace); got != want {
+		t.Fatalf("got trace %s, want %s", got, want)
+	}
+}
+
+func TestAIQueryService_GetTrace_Unknown(t *testing.T) {
+	aiSvc := &AIQueryService{Query: benchQueryService(t)}
+
+	_, err := aiSvc.GetTrace(context.Background(), "ffffffffffffffffffffffffffffffff")
+	if !errors.Is(err, internal.ErrTraceNotFound) {
+		t.Fatalf("got %v, want ErrTraceNotFound", err)
+	}
+}
+
+func TestAIQueryService_GetTrace_BadID(t *testing.T) {
+	aiSvc := &AIQueryService{Query: benchQueryService(t)}
+
+	for _, id := range []string{"not-hex", "", "00000000000000000000000000000000ff"} {
+		if _, err := aiSvc.GetTrace(context.Background(), id); !errors.Is(err, traceutil.ErrInvalidTraceID) {
+			t.Fatalf("%q: got %v, want ErrInvalidTraceID", id, err)
+		}
+	}
+}
+
+func TestAIQueryService_ExplainTraceStream_NonStreamingLLM(t *testing.T) {
+	aiSvc := &AIQueryService{LLM: &explainingLLM{reply: "all good"}}
+
+	var chunks []string
+	out, err := aiSvc.ExplainTraceStream(context.Background(), ptrace.NewTraces(), func(c string) error {
+		chunks = append(chunks, c)
+		return nil
+	})
+	if err != nil || out != "all good" || len(chunks) != 1 || chunks[0] != "all good" {
+		t.Fatalf("got chunks %q, output %q, err %v", chunks, out, err)
+	}
+
+	if out, err := aiSvc.ExplainTraceStream(context.Background(), ptrace.NewTraces(), nil); err != nil || out != "all good" {
+		t.Fatalf("nil callback: got %q, %v", out, err)
+	}
+}
+
+type explainingLLM struct {
+	FakeLLM
+	reply string
+}
+
+func (l *explainingLLM) ExplainTrace(ctx context.Context, context string) (string, error) {
+	return l.reply, nil
+}
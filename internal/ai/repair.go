@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const defaultExtractionAttempts = 3
+
+// ExtractionAttempt records one round of the extraction repair loop.
+type ExtractionAttempt struct {
+	// Output is the raw LLM output when it could not be parsed, otherwise
+	// the parsed IR re-encoded as JSON.
+	Output string    `json:"output"`
+	IR     *SearchIR `json:"ir,omitempty"`
+	// Error is what was wrong with this attempt; empty for the accepted one.
+	Error string `json:"error,omitempty"`
+}
+
+func (s *AIQueryService) maxExtractionAttempts() int {
+	if s.MaxExtractionAttempts > 0 {
+		return s.MaxExtractionAttempts
+	}
+	return defaultExtractionAttempts
+}
+
+// extractSearchIR asks the LLM for an IR and, while the output fails to parse
+// or validate, feeds the output and the problem back for another try. LLMs
+// that do not implement SearchIRRepairer get a single attempt. Errors other
+// than bad output (transport, cancellation) end the loop immediately. When the
+// budget runs out the last problem is returned as an *ExtractionError. Time
+// expressions are validated against now.
+func (s *AIQueryService) extractSearchIR(ctx context.Context, text string, now time.Time) (SearchIR, []ExtractionAttempt, error) {
+	repairer, canRepair := s.LLM.(SearchIRRepairer)
+	budget := s.maxExtractionAttempts()
+
+	var attempts []ExtractionAttempt
+	var previous string
+	var problem error
+
+	for i := 0; i < budget; i++ {
+		var ir SearchIR
+		var err error
+		if i == 0 {
+			ir, err = s.LLM.ExtractSearchIR(ctx, text)
+		} else {
+			ir, err = repairer.RepairSearchIR(ctx, text, previous, problem.Error())
+		}
+
+		if err != nil {
+			var xe *ExtractionError
+			if !errors.As(err, &xe) {
+				attempts = append(attempts, ExtractionAttempt{Error: err.Error()})
+				return SearchIR{}, attempts, err
+			}
+			previous, problem = xe.Output, xe.Err
+			attempts = append(attempts, ExtractionAttempt{Output: xe.Output, Error: xe.Err.Error()})
+		} else {
+			out, _ := json.Marshal(ir)
+			previous = string(out)
+			problem = ValidateSearchIRAt(ir, now)
+
+			attempt := ExtractionAttempt{Output: previous, IR: &ir}
+			if problem == nil {
+				attempts = append(attempts, attempt)
+				return ir, attempts, nil
+			}
+			attempt.Error = problem.Error()
+			attempts = append(attempts, attempt)
+		}
+
+		s.log().DebugContext(ctx, "extraction rejected", "attempt", i+1, "problem", problem)
+		if !canRepair {
+			break
+		}
+	}
+
+	return SearchIR{}, attempts, &ExtractionError{Output: previous, Err: problem}
+}
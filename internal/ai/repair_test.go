@@ -0,0 +1,144 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedLLM returns one scripted extraction per call and records the
+// repair requests it receives.
+type scriptedLLM struct {
+	FakeLLM
+	replies []scriptedReply
+	calls   int
+	repairs []string
+}
+
+type scriptedReply struct {
+	ir  SearchIR
+	err error
+}
+
+func (s *scriptedLLM) next() (SearchIR, error) {
+	r := s.replies[s.calls]
+	s.calls++
+	return r.ir, r.err
+}
+
+func (s *scriptedLLM) ExtractSearchIR(ctx context.Context, input string) (SearchIR, error) {
+	return s.next()
+}
+
+func (s *scriptedLLM) RepairSearchIR(ctx context.Context, input, previous, problem string) (SearchIR, error) {
+	s.repairs = append(s.repairs, problem+" | "+previous)
+	return s.next()
+}
+
+func TestAIQueryService_RepairsInvalidIR(t *testing.T) {
+	llm := &scriptedLLM{replies: []scriptedReply{
+		{ir: SearchIR{MinDurationMs: strptr("2 seconds")}},
+		{err: &ExtractionError{Output: "Sure, here you go", Err: errors.New("LLM output contains no JSON object")}},
+		{ir: SearchIR{MinDurationMs: strptr("2s")}},
+	}}
+
+	aiSvc := &AIQueryService{LLM: llm, Query: benchQueryService(t)}
+
+	result, err := aiSvc.Search(context.Background(), "traces slower than 2 seconds")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(result.Attempts))
+	}
+	if result.Attempts[2].Error != "" || result.Attempts[2].IR == nil {
+		t.Fatalf("last attempt should be the accepted one: %+v", result.Attempts[2])
+	}
+
+	if !strings.Contains(llm.repairs[0], "min_duration_ms must be a valid duration string") ||
+		!strings.Contains(llm.repairs[0], `"2 seconds"`) {
+		t.Fatalf("first repair must carry the validation error and previous IR, got %q", llm.repairs[0])
+	}
+	if !strings.Contains(llm.repairs[1], "Sure, here you go") {
+		t.Fatalf("second repair must carry the unparsable output, got %q", llm.repairs[1])
+	}
+
+	if len(result.Traces) != 0 {
+		t.Fatalf("no bench trace is longer than 2s, got %d", len(result.Traces))
+	}
+}
+
+func TestAIQueryService_ValidatesAgainstServiceClock(t *testing.T) {
+	// The range ends before it starts at testNow, but not on the wall clock.
+	inverted := SearchIR{StartTime: strptr(testNow.Add(-time.Hour).Format(time.RFC3339)), EndTime: strptr("2h ago")}
+	llm := &scriptedLLM{replies: []scriptedReply{{ir: inverted}, {ir: SearchIR{}}}}
+
+	aiSvc := &AIQueryService{
+		LLM:   llm,
+		Query: benchQueryService(t),
+		Now:   func() time.Time { return testNow },
+	}
+
+	result, err := aiSvc.Search(context.Background(), "ignored")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Attempts) != 2 || !strings.Contains(result.Attempts[0].Error, "is after end_time") {
+		t.Fatalf("the inverted range must be rejected and repaired, got %+v", result.Attempts)
+	}
+}
+
+func TestAIQueryService_RepairBudgetExhausted(t *testing.T) {
+	bad := scriptedReply{ir: SearchIR{MinDurationMs: strptr("-1s")}}
+	llm := &scriptedLLM{replies: []scriptedReply{bad, bad, bad, bad}}
+
+	aiSvc := &AIQueryService{LLM: llm, Query: benchQueryService(t), MaxExtractionAttempts: 2}
+
+	result, err := aiSvc.Search(context.Background(), "ignored")
+	var xe *ExtractionError
+	if !errors.As(err, &xe) {
+		t.Fatalf("expected ExtractionError after exhausting the budget, got %v", err)
+	}
+	if llm.calls != 2 || len(result.Attempts) != 2 {
+		t.Fatalf("expected 2 calls and attempts, got %d calls, %d attempts", llm.calls, len(result.Attempts))
+	}
+	for _, a := range result.Attempts {
+		if a.Error == "" {
+			t.Fatalf("rejected attempt without error: %+v", a)
+		}
+	}
+}
+
+func TestAIQueryService_TransportErrorIsNotRepaired(t *testing.T) {
+	llm := &scriptedLLM{replies: []scriptedReply{
+		{err: context.DeadlineExceeded},
+		{ir: SearchIR{}},
+	}}
+
+	aiSvc := &AIQueryService{LLM: llm, Query: benchQueryService(t)}
+
+	if _, err := aiSvc.Search(context.Background(), "ignored"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if llm.calls != 1 {
+		t.Fatalf("transport errors must not trigger a repair, got %d calls", llm.calls)
+	}
+}
+
+func TestAIQueryService_NoRepairWithoutRepairer(t *testing.T) {
+	aiSvc := &AIQueryService{
+		LLM:   &FakeLLM{IR: SearchIR{MinDurationMs: strptr("-10ms")}},
+		Query: benchQueryService(t),
+	}
+
+	result, err := aiSvc.Search(context.Background(), "ignored")
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	if len(result.Attempts) != 1 {
+		t.Fatalf("expected a single attempt, got %d", len(result.Attempts))
+	}
+}
@@ -1,7 +1,37 @@
 package ai
 
-import "go.opentelemetry.io/collector/pdata/ptrace"
+import (
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal"
+)
 
 type SearchResult struct {
 	Traces []ptrace.Traces
+
+	// IR is the validated extraction the search ran with.
+	IR SearchIR
+
+	// SubQueries are the scalar queries the extracted IR expanded into.
+	SubQueries []SearchIR
+	// MatchedBy[i] lists the indexes into SubQueries that returned Traces[i].
+	MatchedBy [][]int
+
+	// Attempts records each extraction attempt, including rejected ones.
+	Attempts []ExtractionAttempt
+
+	// QueryParams[i] are the reader parameters SubQueries[i] mapped to.
+	QueryParams []internal.TraceQueryParams
+	// Batches counts the batches the reader yielded over all sub-queries.
+	Batches int
+	// Truncated is set when a sub-query matched more traces than its
+	// SearchDepth; only the first SearchDepth are kept.
+	Truncated bool
+	// Partial is set when a sub-query failed and the traces gathered before
+	// the failure were kept (AIQueryService.KeepPartialResults).
+	Partial bool
+	// Elapsed is the wall time of the whole search, extraction included.
+	Elapsed time.Duration
 }
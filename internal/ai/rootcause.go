@@ -0,0 +1,220 @@
+package ai
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+// maxPromptRootCauses is how many ranked candidates go into the explanation
+// prompt.
+const maxPromptRootCauses = 3
+
+// RootCause is a failing span ranked as a likely origin of a trace's error.
+type RootCause struct {
+	SpanID    string `json:"span_id"`
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	// Message is the exception or status message, if any.
+	Message string `json:"message,omitempty"`
+	// Score orders candidates; higher is more likely.
+	Score int `json:"score"`
+	// Reasons lists the heuristics behind Score, e.g. "first failure in time".
+	Reasons []string `json:"reasons"`
+}
+
+// failure is what a span tells about its own failure.
+type failure struct {
+	status     bool
+	exception  string // exception.type, or "exception" when untyped
+	message    string
+	httpStatus int64
+	// at is when the failure happened: the first exception event, else the
+	// end of the span.
+	at pcommon.Timestamp
+}
+
+// spanFailure reports whether span failed: an error status, an exception
+// event or an HTTP status of 400 and above.
+func spanFailure(span ptrace.Span) (failure, bool) {
+	f := failure{status: traceutil.IsError(span), at: span.EndTimestamp()}
+	if f.status {
+		f.message = span.Status().Message()
+	}
+
+	events := span.Events()
+	for i := 0; i < events.Len(); i++ {
+		ev := events.At(i)
+		if ev.Name() != "exception" {
+			continue
+		}
+		f.exception = "exception"
+		if v, ok := ev.Attributes().Get("exception.type"); ok && v.AsString() != "" {
+			f.exception = v.AsString()
+		}
+		if v, ok := ev.Attributes().Get("exception.message"); ok && v.AsString() != "" {
+			f.message = v.AsString()
+		}
+		f.at = ev.Timestamp()
+		break
+	}
+
+	for _, k := range []string{"http.response.status_code", "http.status_code"} {
+		if v, ok := span.Attributes().Get(k); ok {
+			if code := httpStatus(v); code >= 400 {
+				f.httpStatus = code
+			}
+			break
+		}
+	}
+
+	return f, f.status || f.exception != "" || f.httpStatus > 0
+}
+
+func httpStatus(v pcommon.Value) int64 {
+	switch v.Type() {
+	case pcommon.ValueTypeInt:
+		return v.Int()
+	case pcommon.ValueTypeStr:
+		var code int64
+		if _, err := fmt.Sscan(v.Str(), &code); err == nil {
+			return code
+		}
+	}
+	return 0
+}
+
+func isFailing(span ptrace.Span) bool {
+	_, ok := spanFailure(span)
+	return ok
+}
+
+// RankRootCauses scores every failing span of t and returns them most likely
+// first. The heuristics favour the deepest failure on each branch, the first
+// failure in time, error status and exception events, and mark down spans
+// that only pass on the failure of a descendant. Traces without failures
+// return nil.
+func RankRootCauses(t ptrace.Traces) []RootCause {
+	return rankRootCauses(traceutil.BuildTree(t))
+}
+
+func rankRootCauses(tree *traceutil.Tree) []RootCause {
+	type candidate struct {
+		node *traceutil.Node
+		f    failure
+		// below is a failing descendant, the first in walk order.
+		below *traceutil.Node
+	}
+	var cands []*candidate
+	byNode := map[*traceutil.Node]*candidate{}
+	tree.Walk(func(n *traceutil.Node) bool {
+		if f, ok := spanFailure(n.Span); ok {
+			c := &candidate{node: n, f: f}
+			cands = append(cands, c)
+			byNode[n] = c
+		}
+		return true
+	})
+	if len(cands) == 0 {
+		return nil
+	}
+
+	maxDepth, first := 0, cands[0]
+	for _, c := range cands {
+		for a := c.node.Parent; a != nil; a = a.Parent {
+			if ac, ok := byNode[a]; ok && ac.below == nil {
+				ac.below = c.node
+			}
+		}
+		maxDepth = max(maxDepth, c.node.Depth)
+		if c.f.at < first.f.at {
+			first = c
+		}
+	}
+
+	out := make([]RootCause, len(cands))
+	for i, c := range cands {
+		rc := RootCause{
+			SpanID:    c.node.Span.SpanID().String(),
+			Service:   spanRef{res: c.node.Resource, span: c.node.Span}.service(),
+			Operation: c.node.Span.Name(),
+			Message:   c.f.message,
+		}
+		add := func(score int, reason string) {
+			rc.Score += score
+			rc.Reasons = append(rc.Reasons, reason)
+		}
+
+		if c.f.status {
+			add(2, "error status")
+		}
+		if c.f.exception != "" {
+			add(2, "exception event: "+c.f.exception)
+		}
+		if c.f.httpStatus > 0 {
+			add(1, fmt.Sprintf("HTTP %d", c.f.httpStatus))
+		}
+		if c.below == nil {
+			add(3, "deepest failure on its branch")
+		} else {
+			b := c.below
+			add(-2, fmt.Sprintf("propagates the failure of %s %s",
+				spanRef{res: b.Resource, span: b.Span}.service(), b.Span.Name()))
+		}
+		if c.node.Depth == maxDepth && len(cands) > 1 {
+			add(1, "deepest failing span")
+		}
+		if c == first && len(cands) > 1 {
+			add(2, "first failure in time")
+		}
+		out[i] = rc
+	}
+
+	order := make([]int, len(cands))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ca, cb := cands[order[a]], cands[order[b]]
+		if out[order[a]].Score != out[order[b]].Score {
+			return out[order[a]].Score > out[order[b]].Score
+		}
+		if ca.f.at != cb.f.at {
+			return ca.f.at < cb.f.at
+		}
+		ia, ib := ca.node.Span.SpanID(), cb.node.Span.SpanID()
+		return bytes.Compare(ia[:], ib[:]) < 0
+	})
+	ranked := make([]RootCause, len(order))
+	for k, i := range order {
+		ranked[k] = out[i]
+	}
+	return ranked
+}
+
+// renderRootCauses lists the top n candidates for the prompt, e.g.
+// "  1. payment-svc Authorize (span 8872b44b9fbb971b): insufficient_funds [error status, ...]".
+func renderRootCauses(ranked []RootCause, n int) string {
+	if len(ranked) == 0 || n <= 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\nRoot cause candidates (ranked by heuristics, most likely first):\n")
+	for i, rc := range ranked {
+		if i == n {
+			break
+		}
+		fmt.Fprintf(&b, "  %d. %s %s (span %s)", i+1, rc.Service, rc.Operation, rc.SpanID)
+		if rc.Message != "" {
+			fmt.Fprintf(&b, ": %s", rc.Message)
+		}
+		fmt.Fprintf(&b, " [%s]\n", strings.Join(rc.Reasons, ", "))
+	}
+	return b.String()
+}
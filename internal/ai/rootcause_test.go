@@ -0,0 +1,88 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/synthetic"
+)
+
+func TestRankRootCauses_BenchCheckout(t *testing.T) {
+	traces, err := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatalf("load bench traces: %v", err)
+	}
+
+	ranked := RankRootCauses(traces[0])
+	if len(ranked) != 2 {
+		t.Fatalf("expected the frontend and payment spans, got %+v", ranked)
+	}
+	top := ranked[0]
+	if top.Service != "payment-svc" || top.Operation != "Authorize" || top.Message != "insufficient_funds" {
+		t.Fatalf("expected payment-svc Authorize first, got %+v", ranked)
+	}
+	if ranked[1].Service != "frontend" || ranked[1].Score >= top.Score {
+		t.Fatalf("frontend only propagates the 402, got %+v", ranked)
+	}
+
+	out, _ := buildTraceContext(traces[0], defaultTraceContextTokens, nil)
+	want := "Root cause candidates (ranked by heuristics, most likely first):\n  1. payment-svc Authorize (span " + top.SpanID + "): insufficient_funds"
+	if !strings.Contains(out, want) {
+		t.Fatalf("context is missing %q:\n%s", want, out)
+	}
+}
+
+func TestRankRootCauses_ExceptionAndPropagation(t *testing.T) {
+	td := ptrace.NewTraces()
+	root := addTestSpan(td, "gateway", "POST /orders", 1, 0, 0, 200*time.Millisecond)
+	root.Status().SetCode(ptrace.StatusCodeError)
+	api := addTestSpan(td, "orders", "CreateOrder", 2, 1, 10*time.Millisecond, 150*time.Millisecond)
+	api.Status().SetCode(ptrace.StatusCodeError)
+	api.Status().SetMessage("upstream failed")
+
+	// The failing call, recorded only as an exception event.
+	db := addTestSpan(td, "orders-db", "INSERT orders", 3, 2, 20*time.Millisecond, 30*time.Millisecond)
+	ev := db.Events().AppendEmpty()
+	ev.SetName("exception")
+	ev.SetTimestamp(pcommon.NewTimestampFromTime(spanBase.Add(45 * time.Millisecond)))
+	ev.Attributes().PutStr("exception.type", "UniqueViolation")
+	ev.Attributes().PutStr("exception.message", "duplicate key")
+
+	// A later, unrelated failure on another branch.
+	late := addTestSpan(td, "mailer", "Notify", 4, 1, 170*time.Millisecond, 20*time.Millisecond)
+	late.Status().SetCode(ptrace.StatusCodeError)
+
+	ranked := RankRootCauses(td)
+	if len(ranked) != 4 {
+		t.Fatalf("expected 4 candidates, got %+v", ranked)
+	}
+	got := []string{ranked[0].Operation, ranked[1].Operation, ranked[2].Operation, ranked[3].Operation}
+	want := []string{"INSERT orders", "Notify", "CreateOrder", "POST /orders"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ranking %v, want %v: %+v", got, want, ranked)
+		}
+	}
+	if ranked[0].Message != "duplicate key" {
+		t.Fatalf("expected the exception message, got %q", ranked[0].Message)
+	}
+	reasons := ranked[0].Reasons
+	if len(reasons) != 4 || reasons[0] != "exception event: UniqueViolation" || reasons[3] != "first failure in time" {
+		t.Fatalf("unexpected reasons %q", reasons)
+	}
+	if ranked[2].Reasons[1] != "propagates the failure of orders-db INSERT orders" {
+		t.Fatalf("unexpected reasons %q", ranked[2].Reasons)
+	}
+}
+
+func TestRankRootCauses_NoFailure(t *testing.T) {
+	td := ptrace.NewTraces()
+	addTestSpan(td, "frontend", "GET /", 1, 0, 0, time.Millisecond)
+	if got := RankRootCauses(td); got != nil {
+		t.Fatalf("expected no candidates, got %+v", got)
+	}
+}
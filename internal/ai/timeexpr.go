@@ -0,0 +1,397 @@
+package ai
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeBound tells the resolver which edge of a day-sized expression
+// ("yesterday", "tuesday") the caller wants.
+type timeBound int
+
+const (
+	boundStart timeBound = iota
+	boundEnd
+)
+
+// ResolveTimeRange turns the start_time / end_time expressions emitted by the
+// extraction prompt into absolute instants anchored at now. Either side may be
+// nil. A single "between X and Y" expression in start_time fills both sides.
+// A missing end with a present start defaults to now.
+func ResolveTimeRange(start, end *string, now time.Time) (time.Time, time.Time, error) {
+	var startExpr, endExpr string
+	if start != nil {
+		startExpr = strings.TrimSpace(*start)
+	}
+	if end != nil {
+		endExpr = strings.TrimSpace(*end)
+	}
+
+	if lo, hi, ok := splitBetween(startExpr); ok && (endExpr == "" || strings.EqualFold(endExpr, startExpr)) {
+		startExpr, endExpr = lo, hi
+	}
+
+	var minT, maxT time.Time
+	var err error
+
+	if startExpr != "" {
+		minT, err = resolveTime(startExpr, now, boundStart, time.Time{})
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("start_time: %w", err)
+		}
+	}
+
+	if endExpr != "" {
+		maxT, err = resolveTime(endExpr, now, boundEnd, minT)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("end_time: %w", err)
+		}
+	} else if !minT.IsZero() {
+		maxT = now
+	}
+
+	if !minT.IsZero() && !maxT.IsZero() && minT.After(maxT) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start_time %s is after end_time %s",
+			minT.Format(time.RFC3339), maxT.Format(time.RFC3339))
+	}
+
+	return minT, maxT, nil
+}
+
+// ResolveTime resolves a single time expression anchored at now. Day-sized
+// expressions resolve to the start of the day.
+func ResolveTime(expr string, now time.Time) (time.Time, error) {
+	return resolveTime(strings.TrimSpace(expr), now, boundStart, time.Time{})
+}
+
+var betweenRe = regexp.MustCompile(`(?i)^(?:between|from)\s+(.+?)\s+(?:and|to|till|until)\s+(.+)$`)
+
+func splitBetween(expr string) (string, string, bool) {
+	m := betweenRe.FindStringSubmatch(expr)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// resolveTime resolves expr. anchor, when set, is the already resolved start
+// of the range; bare clock times on the end side are placed on its day so
+// "2pm" .. "4pm" stays on one day.
+func resolveTime(expr string, now time.Time, bound timeBound, anchor time.Time) (time.Time, error) {
+	if expr == "" {
+		return time.Time{}, fmt.Errorf("empty time expression")
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, time.RFC3339} {
+		if t, err := time.Parse(layout, expr); err == nil {
+			return t, nil
+		}
+	}
+
+	s := strings.ToLower(strings.Join(strings.Fields(expr), " "))
+	s = strings.TrimPrefix(s, "since ")
+	s = strings.TrimPrefix(s, "from ")
+	s = strings.TrimPrefix(s, "until ")
+	s = strings.TrimPrefix(s, "till ")
+	s = strings.TrimPrefix(s, "on ")
+	s = strings.TrimPrefix(s, "at ")
+
+	loc := now.Location()
+	if rest, l, ok := splitZone(s); ok {
+		s, loc = rest, l
+	}
+	local := now.In(loc)
+
+	if s == "now" || s == "right now" {
+		return now, nil
+	}
+
+	if d, ok := parseOffset(s); ok {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02t15:04:05", "2006-01-02t15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return dayEdge(t, bound), nil
+	}
+
+	dayPart, clockPart := splitDayClock(s)
+
+	if dayPart == "" {
+		h, m, ok := parseClock(clockPart)
+		if !ok {
+			return time.Time{}, fmt.Errorf("unrecognized time expression %q", expr)
+		}
+		if !anchor.IsZero() {
+			a := anchor.In(loc)
+			t := time.Date(a.Year(), a.Month(), a.Day(), h, m, 0, 0, loc)
+			if t.Before(anchor) {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+		t := time.Date(local.Year(), local.Month(), local.Day(), h, m, 0, 0, loc)
+		if t.After(now) {
+			t = t.AddDate(0, 0, -1)
+		}
+		return t, nil
+	}
+
+	day, ok := parseDay(dayPart, local)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unrecognized time expression %q", expr)
+	}
+
+	if clockPart == "" {
+		return dayEdge(day, bound), nil
+	}
+
+	h, m, ok := parseClock(clockPart)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unrecognized time of day %q in %q", clockPart, expr)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc), nil
+}
+
+func dayEdge(t time.Time, bound timeBound) time.Time {
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if bound == boundEnd {
+		return start.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return start
+}
+
+var zoneAbbrevs = map[string]int{
+	"utc": 0, "gmt": 0, "z": 0,
+	"bst": 1 * 3600, "cet": 1 * 3600, "cest": 2 * 3600, "eet": 2 * 3600, "eest": 3 * 3600,
+	"ist": 5*3600 + 1800, "jst": 9 * 3600,
+	"est": -5 * 3600, "edt": -4 * 3600,
+	"cst": -6 * 3600, "cdt": -5 * 3600,
+	"mst": -7 * 3600, "mdt": -6 * 3600,
+	"pst": -8 * 3600, "pdt": -7 * 3600,
+}
+
+var offsetRe = regexp.MustCompile(`^(?:utc|gmt)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// splitZone strips a trailing timezone (abbreviation, numeric offset or IANA
+// name) and returns the remaining expression with the location it names.
+func splitZone(s string) (string, *time.Location, bool) {
+	i := strings.LastIndexByte(s, ' ')
+	if i < 0 {
+		return s, nil, false
+	}
+	rest, tok := s[:i], s[i+1:]
+
+	if off, ok := zoneAbbrevs[tok]; ok {
+		return rest, time.FixedZone(strings.ToUpper(tok), off), true
+	}
+
+	if m := offsetRe.FindStringSubmatch(tok); m != nil {
+		h, _ := strconv.Atoi(m[2])
+		mins := 0
+		if m[3] != "" {
+			mins, _ = strconv.Atoi(m[3])
+		}
+		off := h*3600 + mins*60
+		if m[1] == "-" {
+			off = -off
+		}
+		return rest, time.FixedZone(strings.ToUpper(tok), off), true
+	}
+
+	if strings.Contains(tok, "/") {
+		// IANA names are case sensitive; recover the original casing.
+		name := canonicalZoneName(tok)
+		if l, err := time.LoadLocation(name); err == nil {
+			return rest, l, true
+		}
+	}
+
+	return s, nil, false
+}
+
+func canonicalZoneName(tok string) string {
+	parts := strings.Split(tok, "/")
+	for i, p := range parts {
+		words := strings.Split(p, "_")
+		for j, w := range words {
+			if w != "" {
+				words[j] = strings.ToUpper(w[:1]) + w[1:]
+			}
+		}
+		parts[i] = strings.Join(words, "_")
+	}
+	return strings.Join(parts, "/")
+}
+
+var numberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11,
+	"twelve": 12, "fifteen": 15, "twenty": 20, "thirty": 30, "forty": 40,
+	"forty-five": 45, "fifty": 50, "sixty": 60, "ninety": 90,
+}
+
+var unitDurations = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+var offsetPartRe = regexp.MustCompile(`^(\d+(?:\.\d+)?|[a-z-]+)\s*([a-z]+)$`)
+
+// parseOffset recognizes past offsets: "2h", "2h ago", "2 hours ago",
+// "two hours ago", "an hour ago", "last 30 minutes", "past 1h30m".
+func parseOffset(s string) (time.Duration, bool) {
+	s = strings.TrimSuffix(s, " ago")
+	s = strings.TrimPrefix(s, "last ")
+	s = strings.TrimPrefix(s, "past ")
+	s = strings.TrimPrefix(s, "the last ")
+	s = strings.TrimPrefix(s, "the past ")
+
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, true
+	}
+
+	// "last hour", "past day"
+	if unit, ok := unitDurations[s]; ok && len(s) > 1 {
+		return unit, true
+	}
+
+	m := offsetPartRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+
+	unit, ok := unitDurations[m[2]]
+	if !ok {
+		return 0, false
+	}
+
+	if n, ok := numberWords[m[1]]; ok {
+		return time.Duration(n) * unit, true
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || f <= 0 {
+		return 0, false
+	}
+	return time.Duration(f * float64(unit)), true
+}
+
+var clockRe = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?\s*(am|pm)?$`)
+
+func parseClock(s string) (int, int, bool) {
+	switch s {
+	case "noon", "midday":
+		return 12, 0, true
+	case "midnight":
+		return 0, 0, true
+	}
+
+	m := clockRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, false
+	}
+	// A bare number is only a clock time with a minute part or am/pm.
+	if m[2] == "" && m[3] == "" {
+		return 0, 0, false
+	}
+
+	h, _ := strconv.Atoi(m[1])
+	mins := 0
+	if m[2] != "" {
+		mins, _ = strconv.Atoi(m[2])
+	}
+	if mins > 59 {
+		return 0, 0, false
+	}
+
+	switch m[3] {
+	case "am":
+		if h < 1 || h > 12 {
+			return 0, 0, false
+		}
+		if h == 12 {
+			h = 0
+		}
+	case "pm":
+		if h < 1 || h > 12 {
+			return 0, 0, false
+		}
+		if h != 12 {
+			h += 12
+		}
+	default:
+		if h > 23 {
+			return 0, 0, false
+		}
+	}
+	return h, mins, true
+}
+
+// splitDayClock separates "yesterday at 2pm" into ("yesterday", "2pm").
+func splitDayClock(s string) (string, string) {
+	if i := strings.Index(s, " at "); i >= 0 {
+		return s[:i], s[i+4:]
+	}
+	if _, _, ok := parseClock(s); ok {
+		return "", s
+	}
+	fields := strings.Fields(s)
+	for i := 1; i < len(fields); i++ {
+		clock := strings.Join(fields[i:], " ")
+		if _, _, ok := parseClock(clock); ok {
+			return strings.Join(fields[:i], " "), clock
+		}
+	}
+	return s, ""
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// parseDay resolves day words relative to local. A bare weekday is its most
+// recent occurrence including today; "last <weekday>" excludes today.
+func parseDay(s string, local time.Time) (time.Time, bool) {
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+
+	switch s {
+	case "today", "this morning", "this afternoon", "this evening", "tonight":
+		return today, true
+	case "yesterday", "last night":
+		return today.AddDate(0, 0, -1), true
+	}
+
+	last := false
+	if rest, ok := strings.CutPrefix(s, "last "); ok {
+		s, last = rest, true
+	} else if rest, ok := strings.CutPrefix(s, "this "); ok {
+		s = rest
+	}
+
+	wd, ok := weekdays[s]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	back := (int(today.Weekday()) - int(wd) + 7) % 7
+	if back == 0 && last {
+		back = 7
+	}
+	return today.AddDate(0, 0, -back), true
+}
@@ -0,0 +1,137 @@
+package ai
+
+import (
+	"testing"
+	"time"
+)
+
+// Wednesday 2024-01-03 15:30 UTC.
+var testNow = time.Date(2024, 1, 3, 15, 30, 0, 0, time.UTC)
+
+func TestResolveTime(t *testing.T) {
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"now", testNow},
+		{"2h", testNow.Add(-2 * time.Hour)},
+		{"2h ago", testNow.Add(-2 * time.Hour)},
+		{"2 hours ago", testNow.Add(-2 * time.Hour)},
+		{"two hours ago", testNow.Add(-2 * time.Hour)},
+		{"an hour ago", testNow.Add(-time.Hour)},
+		{"1h30m ago", testNow.Add(-90 * time.Minute)},
+		{"last 30 minutes", testNow.Add(-30 * time.Minute)},
+		{"past hour", testNow.Add(-time.Hour)},
+		{"3d ago", testNow.Add(-72 * time.Hour)},
+		{"1 week ago", testNow.Add(-7 * 24 * time.Hour)},
+		{"yesterday", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"today", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"tuesday", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"wednesday", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"last wednesday", time.Date(2023, 12, 27, 0, 0, 0, 0, time.UTC)},
+		{"last Tuesday", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2pm", time.Date(2024, 1, 3, 14, 0, 0, 0, time.UTC)},
+		{"4pm", time.Date(2024, 1, 2, 16, 0, 0, 0, time.UTC)},
+		{"14:05", time.Date(2024, 1, 3, 14, 5, 0, 0, time.UTC)},
+		{"12am", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"noon", time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)},
+		{"yesterday at 2:30pm", time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC)},
+		{"monday 9am", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{"since yesterday", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-01 10:15", time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"2024-01-01T10:15:00Z", time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"2pm UTC", time.Date(2024, 1, 3, 14, 0, 0, 0, time.UTC)},
+		{"6am PST", time.Date(2024, 1, 3, 14, 0, 0, 0, time.UTC)},
+		{"9am PST", time.Date(2024, 1, 2, 17, 0, 0, 0, time.UTC)},
+		{"10am +05:30", time.Date(2024, 1, 3, 4, 30, 0, 0, time.UTC)},
+		{"10am UTC+2", time.Date(2024, 1, 3, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ResolveTime(tt.expr, testNow)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTime_Invalid(t *testing.T) {
+	for _, expr := range []string{"not-a-time", "", "13pm", "25:00", "someday", "500"} {
+		if _, err := ResolveTime(expr, testNow); err == nil {
+			t.Fatalf("%q: expected error, got nil", expr)
+		}
+	}
+}
+
+func TestResolveTimeRange(t *testing.T) {
+	endOfDay := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+	}
+
+	tests := []struct {
+		name           string
+		start, end     *string
+		wantLo, wantHi time.Time
+	}{
+		{"none", nil, nil, time.Time{}, time.Time{}},
+		{"start only defaults end to now", strptr("2h ago"), nil, testNow.Add(-2 * time.Hour), testNow},
+		{"end only", nil, strptr("1h ago"), time.Time{}, testNow.Add(-time.Hour)},
+		{"relative pair", strptr("2h ago"), strptr("1h ago"), testNow.Add(-2 * time.Hour), testNow.Add(-time.Hour)},
+		{"same day covers whole day", strptr("yesterday"), strptr("yesterday"),
+			time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), endOfDay(2024, 1, 2)},
+		{"weekday", strptr("tuesday"), strptr("tuesday"),
+			time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), endOfDay(2024, 1, 2)},
+		{"clock pair stays on one day", strptr("2pm"), strptr("4pm"),
+			time.Date(2024, 1, 3, 14, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 16, 0, 0, 0, time.UTC)},
+		{"between in start", strptr("between 2pm and 4pm"), nil,
+			time.Date(2024, 1, 3, 14, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 16, 0, 0, 0, time.UTC)},
+		{"between duplicated in both", strptr("between yesterday and now"), strptr("between yesterday and now"),
+			time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), testNow},
+		{"overnight clock range", strptr("10pm"), strptr("2am"),
+			time.Date(2024, 1, 2, 22, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 2, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lo, hi, err := ResolveTimeRange(tt.start, tt.end, testNow)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !lo.Equal(tt.wantLo) || !hi.Equal(tt.wantHi) {
+				t.Fatalf("got [%v, %v], want [%v, %v]", lo, hi, tt.wantLo, tt.wantHi)
+			}
+		})
+	}
+}
+
+func TestResolveTimeRange_StartAfterEnd(t *testing.T) {
+	if _, _, err := ResolveTimeRange(strptr("1h ago"), strptr("2h ago"), testNow); err == nil {
+		t.Fatalf("expected error for inverted range")
+	}
+}
+
+func TestMapIRToQueryParamsAt_RelativeTimes(t *testing.T) {
+	ir := SearchIR{
+		StartTime: strptr("2h ago"),
+		EndTime:   strptr("now"),
+	}
+
+	if err := ValidateSearchIRAt(ir, testNow); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	qp, err := MapIRToQueryParamsAt(ir, testNow)
+	if err != nil {
+		t.Fatalf("unexpected mapping error: %v", err)
+	}
+
+	if !qp.StartTimeMin.Equal(testNow.Add(-2*time.Hour)) || !qp.StartTimeMax.Equal(testNow) {
+		t.Fatalf("unexpected time range [%v, %v]", qp.StartTimeMin, qp.StartTimeMax)
+	}
+}
@@ -0,0 +1,396 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/synthetic"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+// Explainer produces the explanations under test; *ai.AIQueryService
+// implements it.
+type Explainer interface {
+	ExplainTrace(ctx context.Context, trace ptrace.Traces) (string, error)
+	ExplainSpan(ctx context.Context, span ptrace.Span, res pcommon.Resource, serviceName string) (string, error)
+}
+
+// ExplainCase is a trace with its known root cause.
+type ExplainCase struct {
+	Trace ptrace.Traces
+	Truth synthetic.GroundTruth
+}
+
+// LoadExplainCases pairs the traces of tracesPath with the ground truth in
+// labelsPath, as written by the generator. Unlabeled traces are left out.
+func LoadExplainCases(tracesPath, labelsPath string) ([]ExplainCase, error) {
+	traces, err := synthetic.LoadTracesFromFile(tracesPath)
+	if err != nil {
+		return nil, err
+	}
+	truth, err := synthetic.LoadGroundTruthFromFile(labelsPath)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]ptrace.Traces{}
+	for _, t := range traces {
+		byID[traceutil.TraceID(t).String()] = t
+	}
+	cases := make([]ExplainCase, 0, len(truth))
+	for _, gt := range truth {
+		t, ok := byID[gt.TraceID]
+		if !ok {
+			return nil, fmt.Errorf("%s: labeled trace %s is not in %s", labelsPath, gt.TraceID, tracesPath)
+		}
+		cases = append(cases, ExplainCase{Trace: t, Truth: gt})
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("%s labels no traces", labelsPath)
+	}
+	return cases, nil
+}
+
+// Explanation checks. Each passed check adds to a case's score; checks that
+// do not apply, such as the error of a latency fault, are left out.
+const (
+	CheckService       = "service"
+	CheckOperation     = "operation"
+	CheckError         = "error"
+	CheckNoHallucinate = "no_hallucination"
+)
+
+var explainChecks = []string{CheckService, CheckOperation, CheckError, CheckNoHallucinate}
+
+// ExplainResult is the outcome of one explanation.
+type ExplainResult struct {
+	TraceID  string `json:"trace_id"`
+	SpanID   string `json:"span_id,omitempty"`
+	Scenario string `json:"scenario"`
+	Text     string `json:"text,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Checks maps each applicable check to whether it passed.
+	Checks map[string]bool `json:"checks"`
+	// Hallucinated lists services named in the text that are not in the
+	// trace.
+	Hallucinated []string `json:"hallucinated,omitempty"`
+	Score        float64  `json:"score"`
+}
+
+// ExplainScore aggregates explanations.
+type ExplainScore struct {
+	Cases  int `json:"cases"`
+	Errors int `json:"errors"`
+	// Passed and Applied count each check over the cases it applied to.
+	Passed  map[string]int `json:"passed"`
+	Applied map[string]int `json:"applied"`
+	// Score is the mean case score, from 0 to 1.
+	Score float64 `json:"score"`
+}
+
+// Rate is the share of cases that passed check, or 1 when it never applied.
+func (s ExplainScore) Rate(check string) float64 {
+	return ratio(s.Passed[check], s.Applied[check])
+}
+
+func (s *ExplainScore) add(r ExplainResult) {
+	if s.Passed == nil {
+		s.Passed, s.Applied = map[string]int{}, map[string]int{}
+	}
+	s.Score = (s.Score*float64(s.Cases) + r.Score) / float64(s.Cases+1)
+	s.Cases++
+	if r.Error != "" {
+		s.Errors++
+	}
+	for check, ok := range r.Checks {
+		s.Applied[check]++
+		if ok {
+			s.Passed[check]++
+		}
+	}
+}
+
+// ExplainReport is the outcome of explaining labeled traces with one prompt
+// and model.
+type ExplainReport struct {
+	Run
+	// Trace scores whole-trace explanations; Span scores explanations of the
+	// root cause span alone.
+	Trace ExplainScore `json:"trace"`
+	Span  ExplainScore `json:"span"`
+	// Scenarios breaks Trace down by failure mode.
+	Scenarios map[string]ExplainScore `json:"scenarios"`
+	Results   []ExplainResult         `json:"results"`
+}
+
+// RunExplanations explains every case twice, the whole trace and the root
+// cause span alone, and checks each text against the ground truth: whether
+// it names the service and operation of the root cause (trace explanations
+// only), whether it names its error, and whether it names services that
+// are not in the trace. Services count as named when they are known from
+// any trace of cases or look like one ("inventory-svc", "orders-db").
+func RunExplanations(ctx context.Context, model Explainer, cases []ExplainCase, run Run) (*ExplainReport, error) {
+	r := &ExplainReport{Run: run, Scenarios: map[string]ExplainScore{}}
+
+	known := map[string]bool{}
+	for _, c := range cases {
+		for _, svc := range traceutil.Services(c.Trace) {
+			known[svc] = true
+		}
+	}
+
+	for _, c := range cases {
+		gt := c.Truth
+		present := map[string]bool{}
+		for _, svc := range traceutil.Services(c.Trace) {
+			present[svc] = true
+		}
+
+		text, err := model.ExplainTrace(ctx, c.Trace)
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		res := checkExplanation(text, err, gt, known, present, true)
+		res.TraceID, res.Scenario = gt.TraceID, gt.Scenario
+		r.Results = append(r.Results, res)
+		r.Trace.add(res)
+		sc := r.Scenarios[gt.Scenario]
+		sc.add(res)
+		r.Scenarios[gt.Scenario] = sc
+
+		id, err := traceutil.ParseSpanID(gt.SpanID)
+		if err != nil {
+			return nil, fmt.Errorf("trace %s: %w", gt.TraceID, err)
+		}
+		span, rs, ok := traceutil.FindSpan(c.Trace, id)
+		if !ok {
+			return nil, fmt.Errorf("trace %s: root cause span %s not found", gt.TraceID, gt.SpanID)
+		}
+		text, err = model.ExplainSpan(ctx, span, rs, traceutil.ServiceName(rs, span))
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		res = checkExplanation(text, err, gt, known, present, false)
+		res.TraceID, res.SpanID, res.Scenario = gt.TraceID, gt.SpanID, gt.Scenario
+		r.Results = append(r.Results, res)
+		r.Span.add(res)
+	}
+	return r, nil
+}
+
+// serviceLikeRe matches hyphenated names with a suffix services usually
+// carry, so "billing-svc" counts as a service even if no trace has it.
+var serviceLikeRe = regexp.MustCompile(`(?i)\b[a-z][a-z0-9]*(?:-[a-z0-9]+)*-(?:svc|service|api|db|worker|gateway|server|proxy|cache)\b`)
+
+func checkExplanation(text string, err error, gt synthetic.GroundTruth, known, present map[string]bool, whole bool) ExplainResult {
+	res := ExplainResult{Checks: map[string]bool{}}
+	if err != nil {
+		res.Error = err.Error()
+		text = ""
+	}
+	res.Text = text
+
+	if whole {
+		res.Checks[CheckService] = mentions(text, gt.Service)
+		res.Checks[CheckOperation] = mentions(text, gt.Operation)
+	}
+	if gt.Error != "" {
+		res.Checks[CheckError] = mentionsMessage(text, gt.Error)
+	}
+
+	named := map[string]bool{}
+	for svc := range known {
+		if mentions(text, svc) {
+			named[svc] = true
+		}
+	}
+	for _, m := range serviceLikeRe.FindAllString(text, -1) {
+		named[strings.ToLower(m)] = true
+	}
+	for svc := range named {
+		if !present[svc] {
+			res.Hallucinated = append(res.Hallucinated, svc)
+		}
+	}
+	sort.Strings(res.Hallucinated)
+	res.Checks[CheckNoHallucinate] = res.Error == "" && len(res.Hallucinated) == 0
+
+	passed := 0
+	for _, ok := range res.Checks {
+		if ok {
+			passed++
+		}
+	}
+	res.Score = float64(passed) / float64(len(res.Checks))
+	return res
+}
+
+// mentions reports whether text names name as a whole word, ignoring case.
+func mentions(text, name string) bool {
+	if name == "" {
+		return false
+	}
+	re := regexp.MustCompile(`(?i)(?:^|[^\w-])` + regexp.QuoteMeta(name) + `(?:$|[^\w-])`)
+	return re.MatchString(text)
+}
+
+// mentionsMessage reports whether text names an error message, allowing
+// rewording: "insufficient_funds" matches "insufficient funds", and long
+// messages match when more than half of their words of four or more letters
+// appear.
+func mentionsMessage(text, msg string) bool {
+	norm := func(s string) string {
+		return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+		}), " ")
+	}
+	t, m := " "+norm(text)+" ", norm(msg)
+	if m == "" {
+		return false
+	}
+	if strings.Contains(t, " "+m+" ") {
+		return true
+	}
+	var words, found int
+	for _, w := range strings.Fields(m) {
+		if len(w) < 4 {
+			continue
+		}
+		words++
+		if strings.Contains(t, " "+w+" ") {
+			found++
+		}
+	}
+	return words > 1 && 2*found > words
+}
+
+// LoadExplainReport reads a report written by WriteReport.
+func LoadExplainReport(path string) (*ExplainReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	var r ExplainReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// Summary renders the check rates of r, by failure mode, followed by the
+// explanations that missed a check.
+func (r *ExplainReport) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", r.Run)
+	fmt.Fprintf(&b, "%-20s %6s %6s %8s %9s %6s %14s\n", "", "cases", "score", "service", "operation", "error", "no halluc.")
+	row := func(name string, s ExplainScore) {
+		fmt.Fprintf(&b, "%-20s %6d %6.3f %8.3f %9.3f %6.3f %14.3f\n", name, s.Cases, s.Score,
+			s.Rate(CheckService), s.Rate(CheckOperation), s.Rate(CheckError), s.Rate(CheckNoHallucinate))
+	}
+	row("trace", r.Trace)
+	row("span", r.Span)
+	names := make([]string, 0, len(r.Scenarios))
+	for name := range r.Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		row("  "+name, r.Scenarios[name])
+	}
+
+	for _, res := range r.Results {
+		var failed []string
+		for _, check := range explainChecks {
+			if ok, applies := res.Checks[check]; applies && !ok {
+				failed = append(failed, check)
+			}
+		}
+		if len(failed) == 0 {
+			continue
+		}
+		kind := "trace " + res.TraceID
+		if res.SpanID != "" {
+			kind += " span " + res.SpanID
+		}
+		fmt.Fprintf(&b, "\n%s (%s): missed %s\n", kind, res.Scenario, strings.Join(failed, ", "))
+		if res.Error != "" {
+			fmt.Fprintf(&b, "  error: %s\n", res.Error)
+		}
+		if len(res.Hallucinated) > 0 {
+			fmt.Fprintf(&b, "  not in the trace: %s\n", strings.Join(res.Hallucinated, ", "))
+		}
+	}
+	return b.String()
+}
+
+// CompareExplainReports aggregates reports by model and prompt, averaging
+// runs that share both, and renders one row per pair, best score first.
+func CompareExplainReports(reports []*ExplainReport) string {
+	type key struct{ model, prompt string }
+	type agg struct {
+		labels      []string
+		trace, span ExplainScore
+	}
+	groups := map[key]*agg{}
+	var order []key
+	for _, r := range reports {
+		k := key{r.Model, r.Prompt}
+		g, ok := groups[k]
+		if !ok {
+			g = &agg{}
+			groups[k] = g
+			order = append(order, k)
+		}
+		if r.Label != "" {
+			g.labels = append(g.labels, r.Label)
+		}
+		g.trace = mergeScores(g.trace, r.Trace)
+		g.span = mergeScores(g.span, r.Span)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return groups[order[i]].trace.Score > groups[order[j]].trace.Score
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-24s %-8s %6s %11s %10s %s\n", "model", "prompt", "cases", "trace score", "span score", "runs")
+	for _, k := range order {
+		g := groups[k]
+		model := k.model
+		if model == "" {
+			model = "-"
+		}
+		fmt.Fprintf(&b, "%-24s %-8s %6d %11.3f %10.3f %s\n", model, k.prompt, g.trace.Cases,
+			g.trace.Score, g.span.Score, strings.Join(g.labels, ", "))
+	}
+	return b.String()
+}
+
+// mergeScores combines two aggregates as if their cases had been scored
+// together.
+func mergeScores(a, b ExplainScore) ExplainScore {
+	out := ExplainScore{
+		Cases:   a.Cases + b.Cases,
+		Errors:  a.Errors + b.Errors,
+		Passed:  map[string]int{},
+		Applied: map[string]int{},
+	}
+	if out.Cases > 0 {
+		out.Score = (a.Score*float64(a.Cases) + b.Score*float64(b.Cases)) / float64(out.Cases)
+	}
+	for _, s := range []ExplainScore{a, b} {
+		for k, v := range s.Passed {
+			out.Passed[k] += v
+		}
+		for k, v := range s.Applied {
+			out.Applied[k] += v
+		}
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/synthetic"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+// scriptedExplainer answers by trace ID; span explanations get the span's
+// script when there is one, else the trace's.
+type scriptedExplainer struct {
+	traces map[string]string
+	spans  map[string]string
+	err    error
+}
+
+func (s scriptedExplainer) ExplainTrace(ctx context.Context, trace ptrace.Traces) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	return s.traces[traceutil.TraceID(trace).String()], nil
+}
+
+func (s scriptedExplainer) ExplainSpan(ctx context.Context, span ptrace.Span, res pcommon.Resource, serviceName string) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	if text, ok := s.spans[span.SpanID().String()]; ok {
+		return text, nil
+	}
+	return s.traces[span.TraceID().String()], nil
+}
+
+func benchCases(t *testing.T) []ExplainCase {
+	t.Helper()
+	cases, err := LoadExplainCases("../../traces_bench.json", "../../traces_bench.labels.json")
+	if err != nil {
+		t.Fatalf("load cases: %v", err)
+	}
+	return cases
+}
+
+func TestLoadExplainCases(t *testing.T) {
+	cases := benchCases(t)
+	if len(cases) != 34 {
+		t.Fatalf("got %d cases, want the 34 labeled checkouts", len(cases))
+	}
+	for _, c := range cases {
+		if traceutil.TraceID(c.Trace).String() != c.Truth.TraceID {
+			t.Fatalf("case for %s holds trace %s", c.Truth.TraceID, traceutil.TraceID(c.Trace))
+		}
+	}
+
+	labels := filepath.Join(t.TempDir(), "labels.json")
+	missing := []synthetic.GroundTruth{{TraceID: "000000000000000000000000000000ff"}}
+	if err := synthetic.WriteGroundTruthToFile(labels, missing); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadExplainCases("../../traces_bench.json", labels); err == nil {
+		t.Fatalf("expected an error for a label without its trace")
+	}
+}
+
+func TestRunExplanations(t *testing.T) {
+	checkout := benchCases(t)[:3]
+	_, shardTruth, err := synthetic.GenerateLabeled(synthetic.Config{Seed: 1, Scenarios: []synthetic.Scenario{{Use: "slow_shard"}}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shardTraces, _ := synthetic.Generate(synthetic.Config{Seed: 1, Scenarios: []synthetic.Scenario{{Use: "slow_shard"}}}, 1)
+	// Generated trace IDs count up from 1, so move the shard trace clear of
+	// the bench's.
+	shardID := pcommon.TraceID{15: 0xff}
+	traceutil.ForEachSpan(shardTraces[0], func(_ pcommon.Resource, span ptrace.Span) bool {
+		span.SetTraceID(shardID)
+		return true
+	})
+	shardTruth[0].TraceID = shardID.String()
+	cases := append(checkout, ExplainCase{Trace: shardTraces[0], Truth: shardTruth[0]})
+
+	id := func(i int) string { return cases[i].Truth.TraceID }
+	model := scriptedExplainer{
+		traces: map[string]string{
+			id(0): "POST /checkout failed because payment-svc rejected Authorize with insufficient funds.",
+			// Names the frontend only, and a service from another trace.
+			id(1): "The frontend returned 402; search-db may be involved.",
+			// Invents a service.
+			id(2): "Authorize in payment-svc failed (insufficient_funds) after billing-svc timed out.",
+			id(3): "The fan-out in search-svc waited on search-shard-2, whose Search took 900ms.",
+		},
+		spans: map[string]string{
+			cases[1].Truth.SpanID: "The card was declined: insufficient funds.",
+		},
+	}
+
+	r, err := RunExplanations(context.Background(), model, cases, Run{Label: "scripted", Model: "fake/1", Prompt: "abc"})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(r.Results) != 8 || r.Trace.Cases != 4 || r.Span.Cases != 4 {
+		t.Fatalf("got %d results, %d trace and %d span cases", len(r.Results), r.Trace.Cases, r.Span.Cases)
+	}
+
+	trace := func(i int) ExplainResult { return r.Results[2*i] }
+	if res := trace(0); res.Score != 1 {
+		t.Fatalf("correct explanation scored %v: %+v", res.Score, res)
+	}
+	if res := trace(1); res.Checks[CheckService] || res.Checks[CheckError] ||
+		!reflect.DeepEqual(res.Hallucinated, []string{"search-db"}) || res.Score != 0 {
+		t.Fatalf("vague explanation: %+v", res)
+	}
+	if res := trace(2); !reflect.DeepEqual(res.Hallucinated, []string{"billing-svc"}) || res.Score != 0.75 {
+		t.Fatalf("hallucinating explanation: %+v", res)
+	}
+	res := trace(3)
+	if _, ok := res.Checks[CheckError]; ok || res.Score != 1 {
+		t.Fatalf("latency fault checks %+v, want no error check and a full score", res.Checks)
+	}
+
+	// Span explanations are not checked for the service and operation they
+	// were given.
+	span := r.Results[3]
+	if span.SpanID != cases[1].Truth.SpanID || len(span.Checks) != 2 || span.Score != 1 {
+		t.Fatalf("span explanation: %+v", span)
+	}
+
+	if got := r.Trace.Rate(CheckNoHallucinate); got != 0.5 {
+		t.Fatalf("no-hallucination rate %v, want 0.5", got)
+	}
+	if got := r.Scenarios["checkout"].Cases; got != 3 {
+		t.Fatalf("checkout scenario has %d cases", got)
+	}
+	summary := r.Summary()
+	for _, want := range []string{"scripted, model fake/1, prompt abc", "  slow_shard", "not in the trace: billing-svc"} {
+		if !strings.Contains(summary, want) {
+			t.Fatalf("summary misses %q:\n%s", want, summary)
+		}
+	}
+}
+
+func TestRunExplanations_ModelError(t *testing.T) {
+	cases := benchCases(t)[:2]
+	r, err := RunExplanations(context.Background(), scriptedExplainer{err: errors.New("model offline")}, cases, Run{})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if r.Trace.Errors != 2 || r.Span.Errors != 2 || r.Trace.Score != 0 {
+		t.Fatalf("trace %+v, span %+v", r.Trace, r.Span)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := RunExplanations(ctx, scriptedExplainer{err: ctx.Err()}, cases, Run{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want the context error", err)
+	}
+}
+
+func TestMentionsMessage(t *testing.T) {
+	tests := []struct {
+		text, msg string
+		want      bool
+	}{
+		{"declined for insufficient funds", "insufficient_funds", true},
+		{"INSUFFICIENT_FUNDS", "insufficient_funds", true},
+		{"the funds were fine", "insufficient_funds", false},
+		{"the service had too many concurrent requests", "resource exhausted: too many concurrent requests", true},
+		{"resources were fine", "resource exhausted: too many concurrent requests", false},
+		{"it timed out", "context deadline exceeded", false},
+		{"the context deadline was exceeded", "context deadline exceeded", true},
+	}
+	for _, tt := range tests {
+		if got := mentionsMessage(tt.text, tt.msg); got != tt.want {
+			t.Errorf("mentionsMessage(%q, %q) = %v, want %v", tt.text, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestCompareExplainReports(t *testing.T) {
+	cases := benchCases(t)[:2]
+	good := scriptedExplainer{traces: map[string]string{}}
+	bad := scriptedExplainer{traces: map[string]string{}}
+	for _, c := range cases {
+		good.traces[c.Truth.TraceID] = "payment-svc failed Authorize: insufficient funds"
+		bad.traces[c.Truth.TraceID] = "No clear error observed."
+	}
+
+	var reports []*ExplainReport
+	for _, run := range []struct {
+		model Explainer
+		run   Run
+	}{
+		{bad, Run{Label: "v1", Model: "small", Prompt: "p1"}},
+		{good, Run{Label: "v2", Model: "small", Prompt: "p2"}},
+		{bad, Run{Label: "v2 again", Model: "small", Prompt: "p2"}},
+	} {
+		r, err := RunExplanations(context.Background(), run.model, cases, run.run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "report.json")
+		if err := WriteReport(path, r); err != nil {
+			t.Fatal(err)
+		}
+		if r, err = LoadExplainReport(path); err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, r)
+	}
+
+	out := CompareExplainReports(reports)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want a header and one row per prompt:\n%s", len(lines), out)
+	}
+	// p2 averages a perfect run and one that only avoids hallucinating:
+	// 4 cases scoring 1 and 0.25.
+	if !strings.Contains(lines[1], "p2") || !strings.Contains(lines[1], "     4       0.625") ||
+		!strings.HasSuffix(lines[1], "v2, v2 again") {
+		t.Fatalf("first row %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "p1") {
+		t.Fatalf("second row %q", lines[2])
+	}
+}
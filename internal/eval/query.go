@@ -0,0 +1,463 @@
+// Package eval measures the model-facing parts of the assistant against
+// labeled data, so prompt and model changes can be compared run to run.
+package eval
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jaeger-ai-assist-prototype/internal/ai"
+)
+
+// QueryFields are the fields scored for each extraction, in report order.
+var QueryFields = []string{"service", "operation", "min_duration", "max_duration", "start_time", "end_time", "tags"}
+
+// QueryDataset is a set of questions with the filters they should extract.
+type QueryDataset struct {
+	// Now anchors relative time expressions, in expected and extracted
+	// filters alike, so "2h ago" and "since 2 hours ago" compare equal.
+	Now   time.Time   `yaml:"now"`
+	Cases []QueryCase `yaml:"cases"`
+}
+
+// QueryCase is one question of a dataset.
+type QueryCase struct {
+	ID       string
+	Question string
+	// Expect is written like the extraction output: "service" may be a
+	// string or a list, durations and times as the user wrote them.
+	Expect ai.SearchIR
+}
+
+// UnmarshalYAML decodes "expect" with the JSON rules of ai.SearchIR.
+func (c *QueryCase) UnmarshalYAML(n *yaml.Node) error {
+	var aux struct {
+		ID       string         `yaml:"id"`
+		Question string         `yaml:"question"`
+		Expect   map[string]any `yaml:"expect"`
+	}
+	if err := n.Decode(&aux); err != nil {
+		return err
+	}
+	c.ID, c.Question = aux.ID, aux.Question
+	if aux.Expect == nil {
+		c.Expect = ai.SearchIR{}
+		return nil
+	}
+	data, err := json.Marshal(aux.Expect)
+	if err != nil {
+		return fmt.Errorf("case %q: expect: %w", aux.ID, err)
+	}
+	if err := json.Unmarshal(data, &c.Expect); err != nil {
+		return fmt.Errorf("case %q: expect: %w", aux.ID, err)
+	}
+	return nil
+}
+
+// LoadQueryDataset reads a dataset in YAML or JSON.
+func LoadQueryDataset(path string) (QueryDataset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return QueryDataset{}, fmt.Errorf("failed to read dataset: %w", err)
+	}
+	var ds QueryDataset
+	if err := yaml.Unmarshal(data, &ds); err != nil {
+		return QueryDataset{}, fmt.Errorf("failed to parse dataset: %w", err)
+	}
+	if len(ds.Cases) == 0 {
+		return QueryDataset{}, fmt.Errorf("dataset %s has no cases", path)
+	}
+	seen := map[string]bool{}
+	for i, c := range ds.Cases {
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("case-%d", i+1)
+			ds.Cases[i].ID = c.ID
+		}
+		if seen[c.ID] {
+			return QueryDataset{}, fmt.Errorf("dataset %s: duplicate case id %q", path, c.ID)
+		}
+		seen[c.ID] = true
+		if c.Question == "" {
+			return QueryDataset{}, fmt.Errorf("dataset %s: case %q has no question", path, c.ID)
+		}
+	}
+	if ds.Now.IsZero() {
+		ds.Now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	}
+	return ds, nil
+}
+
+// Run identifies what was evaluated.
+type Run struct {
+	// Label names the run, e.g. "shorter examples".
+	Label string `json:"label,omitempty"`
+	// Model is the provider and model, e.g. "ollama/llama3.1".
+	Model string `json:"model,omitempty"`
+	// Prompt is the Fingerprint of the prompt under test.
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// Fingerprint returns a short stable hash of a prompt, so reports show
+// whether two runs used the same one.
+func Fingerprint(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// Score counts matched (TP), extra (FP) and missing (FN) filter values.
+type Score struct {
+	TP int `json:"tp"`
+	FP int `json:"fp"`
+	FN int `json:"fn"`
+}
+
+// MarshalJSON adds precision, recall and f1 to the counts.
+func (s Score) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TP        int     `json:"tp"`
+		FP        int     `json:"fp"`
+		FN        int     `json:"fn"`
+		Precision float64 `json:"precision"`
+		Recall    float64 `json:"recall"`
+		F1        float64 `json:"f1"`
+	}{s.TP, s.FP, s.FN, s.Precision(), s.Recall(), s.F1()})
+}
+
+func (s *Score) add(o Score) {
+	s.TP += o.TP
+	s.FP += o.FP
+	s.FN += o.FN
+}
+
+// Precision is the share of extracted values that were expected; 1 when
+// nothing was extracted.
+func (s Score) Precision() float64 {
+	return ratio(s.TP, s.TP+s.FP)
+}
+
+// Recall is the share of expected values that were extracted; 1 when
+// nothing was expected.
+func (s Score) Recall() float64 {
+	return ratio(s.TP, s.TP+s.FN)
+}
+
+// F1 is the harmonic mean of Precision and Recall.
+func (s Score) F1() float64 {
+	p, r := s.Precision(), s.Recall()
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 1
+	}
+	return float64(n) / float64(d)
+}
+
+// QueryResult is the outcome of one case.
+type QueryResult struct {
+	ID       string       `json:"id"`
+	Question string       `json:"question"`
+	Got      *ai.SearchIR `json:"got,omitempty"`
+	// Error is the extraction or validation error, if any; the case then
+	// counts as extracting nothing.
+	Error string `json:"error,omitempty"`
+	// Missing and Extra list the filter values the extraction got wrong,
+	// as field=value.
+	Missing []string `json:"missing,omitempty"`
+	Extra   []string `json:"extra,omitempty"`
+	Exact   bool     `json:"exact"`
+}
+
+// QueryReport is the outcome of running a dataset through one LLM.
+type QueryReport struct {
+	Run
+	Cases  int `json:"cases"`
+	Exact  int `json:"exact"`
+	Errors int `json:"errors"`
+	// Overall sums Fields.
+	Overall Score            `json:"overall"`
+	Fields  map[string]Score `json:"fields"`
+	Results []QueryResult    `json:"results"`
+}
+
+// ExactMatch is the share of cases extracted without a single wrong value.
+func (r *QueryReport) ExactMatch() float64 {
+	return ratio(r.Exact, r.Cases)
+}
+
+// RunQueries extracts every question of ds with model and scores the result
+// field by field. Extraction is a single LLM call per question, without the
+// repair loop of ai.AIQueryService, so the report reflects the prompt alone.
+// It stops early only when ctx is done.
+func RunQueries(ctx context.Context, model ai.LLM, ds QueryDataset, run Run) (*QueryReport, error) {
+	r := &QueryReport{Run: run, Fields: map[string]Score{}}
+	for _, f := range QueryFields {
+		r.Fields[f] = Score{}
+	}
+
+	for _, c := range ds.Cases {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res := QueryResult{ID: c.ID, Question: c.Question}
+
+		var got map[string][]string
+		ir, err := model.ExtractSearchIR(ctx, c.Question)
+		if err == nil {
+			res.Got = &ir
+			err = ai.ValidateSearchIRAt(ir, ds.Now)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			res.Error = err.Error()
+			r.Errors++
+		} else {
+			got = queryFacts(ir, ds.Now)
+		}
+
+		want := queryFacts(c.Expect, ds.Now)
+		for _, f := range QueryFields {
+			s := Score{}
+			for _, v := range want[f] {
+				if contains(got[f], v) {
+					s.TP++
+				} else {
+					s.FN++
+					res.Missing = append(res.Missing, f+"="+v)
+				}
+			}
+			for _, v := range got[f] {
+				if !contains(want[f], v) {
+					s.FP++
+					res.Extra = append(res.Extra, f+"="+v)
+				}
+			}
+			fs := r.Fields[f]
+			fs.add(s)
+			r.Fields[f] = fs
+			r.Overall.add(s)
+		}
+
+		res.Exact = res.Error == "" && len(res.Missing) == 0 && len(res.Extra) == 0
+		if res.Exact {
+			r.Exact++
+		}
+		r.Cases++
+		r.Results = append(r.Results, res)
+	}
+	return r, nil
+}
+
+// queryFacts normalizes ir into sorted values per field: durations parsed,
+// times resolved against now, tags as key=value. Values that do not parse
+// are kept as written, so they count as wrong rather than vanish.
+func queryFacts(ir ai.SearchIR, now time.Time) map[string][]string {
+	facts := map[string][]string{}
+	add := func(field, v string) {
+		if !contains(facts[field], v) {
+			facts[field] = append(facts[field], v)
+		}
+	}
+
+	if ir.Service != nil {
+		add("service", *ir.Service)
+	}
+	for _, s := range ir.Services {
+		add("service", s)
+	}
+	if ir.Operation != nil {
+		add("operation", *ir.Operation)
+	}
+	for _, o := range ir.Operations {
+		add("operation", o)
+	}
+
+	for field, d := range map[string]*string{"min_duration": ir.MinDurationMs, "max_duration": ir.MaxDurationMs} {
+		if d == nil {
+			continue
+		}
+		if parsed, err := time.ParseDuration(strings.TrimSpace(*d)); err == nil {
+			add(field, parsed.String())
+		} else {
+			add(field, *d)
+		}
+	}
+
+	if lo, hi, err := ai.ResolveTimeRange(ir.StartTime, ir.EndTime, now); err == nil {
+		if !lo.IsZero() {
+			add("start_time", lo.UTC().Format(time.RFC3339))
+		}
+		if !hi.IsZero() {
+			add("end_time", hi.UTC().Format(time.RFC3339))
+		}
+	} else {
+		if ir.StartTime != nil {
+			add("start_time", *ir.StartTime)
+		}
+		if ir.EndTime != nil {
+			add("end_time", *ir.EndTime)
+		}
+	}
+
+	for k, v := range ir.Tags {
+		add("tags", k+"="+v)
+	}
+
+	for _, vs := range facts {
+		sort.Strings(vs)
+	}
+	return facts
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteReport writes r as indented JSON.
+func WriteReport(path string, r any) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// LoadQueryReport reads a report written by WriteReport.
+func LoadQueryReport(path string) (*QueryReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	var r QueryReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// Summary renders r as a table of per-field scores followed by the cases
+// that were not extracted exactly.
+func (r *QueryReport) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", r.Run)
+	fmt.Fprintf(&b, "cases %d, exact match %.1f%%, errors %d\n\n", r.Cases, 100*r.ExactMatch(), r.Errors)
+	fmt.Fprintf(&b, "%-14s %9s %9s %9s\n", "field", "precision", "recall", "f1")
+	for _, f := range QueryFields {
+		s := r.Fields[f]
+		fmt.Fprintf(&b, "%-14s %9.3f %9.3f %9.3f\n", f, s.Precision(), s.Recall(), s.F1())
+	}
+	fmt.Fprintf(&b, "%-14s %9.3f %9.3f %9.3f\n", "overall", r.Overall.Precision(), r.Overall.Recall(), r.Overall.F1())
+
+	for _, res := range r.Results {
+		if res.Exact {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s: %q\n", res.ID, res.Question)
+		if res.Error != "" {
+			fmt.Fprintf(&b, "  error: %s\n", res.Error)
+		}
+		if len(res.Missing) > 0 {
+			fmt.Fprintf(&b, "  missing: %s\n", strings.Join(res.Missing, ", "))
+		}
+		if len(res.Extra) > 0 {
+			fmt.Fprintf(&b, "  extra: %s\n", strings.Join(res.Extra, ", "))
+		}
+	}
+	return b.String()
+}
+
+func (r Run) String() string {
+	var parts []string
+	if r.Label != "" {
+		parts = append(parts, r.Label)
+	}
+	if r.Model != "" {
+		parts = append(parts, "model "+r.Model)
+	}
+	if r.Prompt != "" {
+		parts = append(parts, "prompt "+r.Prompt)
+	}
+	if len(parts) == 0 {
+		return "run"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// CompareQueryReports renders how cur differs from base: the change of each
+// score, and the cases that regressed from or were fixed to an exact match.
+// Cases are matched by ID; those in only one report are listed as such.
+func CompareQueryReports(base, cur *QueryReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base: %s\nthis: %s\n\n", base.Run, cur.Run)
+	fmt.Fprintf(&b, "%-14s %9s %9s %9s\n", "", "base", "this", "change")
+	row := func(name string, x, y float64) {
+		fmt.Fprintf(&b, "%-14s %9.3f %9.3f %+9.3f\n", name, x, y, y-x)
+	}
+	row("exact match", base.ExactMatch(), cur.ExactMatch())
+	row("precision", base.Overall.Precision(), cur.Overall.Precision())
+	row("recall", base.Overall.Recall(), cur.Overall.Recall())
+	for _, f := range QueryFields {
+		row(f+" f1", base.Fields[f].F1(), cur.Fields[f].F1())
+	}
+	fmt.Fprintf(&b, "%-14s %9d %9d %+9d\n", "errors", base.Errors, cur.Errors, cur.Errors-base.Errors)
+
+	before := map[string]QueryResult{}
+	for _, res := range base.Results {
+		before[res.ID] = res
+	}
+	var regressed, fixed, added []string
+	seen := map[string]bool{}
+	for _, res := range cur.Results {
+		seen[res.ID] = true
+		old, ok := before[res.ID]
+		switch {
+		case !ok:
+			added = append(added, res.ID)
+		case old.Exact && !res.Exact:
+			regressed = append(regressed, res.ID)
+		case !old.Exact && res.Exact:
+			fixed = append(fixed, res.ID)
+		}
+	}
+	var removed []string
+	for _, res := range base.Results {
+		if !seen[res.ID] {
+			removed = append(removed, res.ID)
+		}
+	}
+
+	list := func(name string, ids []string) {
+		if len(ids) > 0 {
+			fmt.Fprintf(&b, "\n%s (%d): %s", name, len(ids), strings.Join(ids, ", "))
+		}
+	}
+	list("regressed", regressed)
+	list("fixed", fixed)
+	list("only in this run", added)
+	list("only in base", removed)
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,207 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaeger-ai-assist-prototype/internal/ai"
+)
+
+// scriptedLLM answers each question with a fixed extraction output, written
+// as the model would emit it, or fails for questions it has no script for.
+type scriptedLLM map[string]string
+
+func (s scriptedLLM) ExtractSearchIR(ctx context.Context, input string) (ai.SearchIR, error) {
+	out, ok := s[input]
+	if !ok {
+		return ai.SearchIR{}, &ai.ExtractionError{Output: "I don't know", Err: errors.New("no JSON object in output")}
+	}
+	var ir ai.SearchIR
+	if err := json.Unmarshal([]byte(out), &ir); err != nil {
+		return ai.SearchIR{}, &ai.ExtractionError{Output: out, Err: err}
+	}
+	return ir, nil
+}
+
+func (s scriptedLLM) ExplainTrace(ctx context.Context, context string) (string, error) {
+	return "", errors.New("not scripted")
+}
+
+func (s scriptedLLM) ExplainSpan(ctx context.Context, context string) (string, error) {
+	return "", errors.New("not scripted")
+}
+
+func loadGolden(t *testing.T) QueryDataset {
+	t.Helper()
+	ds, err := LoadQueryDataset("../../config/eval_queries.yaml")
+	if err != nil {
+		t.Fatalf("load dataset: %v", err)
+	}
+	return ds
+}
+
+// perfect scripts the expected output of every case.
+func perfect(t *testing.T, ds QueryDataset) scriptedLLM {
+	t.Helper()
+	s := scriptedLLM{}
+	for _, c := range ds.Cases {
+		out, err := json.Marshal(c.Expect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s[c.Question] = string(out)
+	}
+	return s
+}
+
+func TestLoadQueryDataset(t *testing.T) {
+	ds := loadGolden(t)
+	var two QueryCase
+	for _, c := range ds.Cases {
+		if c.ID == "two-services" {
+			two = c
+		}
+	}
+	ir := two.Expect
+	if ir.Service == nil || *ir.Service != "payment-svc" || !reflect.DeepEqual(ir.Services, []string{"catalog-svc"}) {
+		t.Fatalf("service list decoded as %v %v", ir.Service, ir.Services)
+	}
+	if ir.Tags["error"] != "true" {
+		t.Fatalf("tags decoded as %v", ir.Tags)
+	}
+	if ds.Now.IsZero() {
+		t.Fatalf("now not set")
+	}
+}
+
+func TestRunQueries_Perfect(t *testing.T) {
+	ds := loadGolden(t)
+	r, err := RunQueries(context.Background(), perfect(t, ds), ds, Run{Label: "perfect"})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if r.Cases != len(ds.Cases) || r.Exact != r.Cases || r.Errors != 0 {
+		t.Fatalf("got %d exact of %d with %d errors:\n%s", r.Exact, r.Cases, r.Errors, r.Summary())
+	}
+	if r.Overall.Precision() != 1 || r.Overall.Recall() != 1 {
+		t.Fatalf("overall %+v", r.Overall)
+	}
+}
+
+func TestRunQueries_Scoring(t *testing.T) {
+	ds := QueryDataset{Now: loadGolden(t).Now, Cases: []QueryCase{
+		{ID: "equivalent", Question: "q1", Expect: mustIR(t, `{"service": ["a", "b"], "min_duration_ms": "1.5s", "start_time": "2h ago"}`)},
+		{ID: "wrong-service", Question: "q2", Expect: mustIR(t, `{"service": "a", "tags": {"error": "true"}}`)},
+		{ID: "invalid", Question: "q3", Expect: mustIR(t, `{"max_duration_ms": "1s"}`)},
+		{ID: "unscripted", Question: "q4", Expect: mustIR(t, `{"operation": "Login"}`)},
+	}}
+	model := scriptedLLM{
+		// Same filters written differently.
+		"q1": `{"service": ["b", "a"], "min_duration_ms": "1500ms", "start_time": "2 hours ago", "end_time": "now"}`,
+		"q2": `{"service": "c", "tags": {"error": "true", "http.status_code": "500"}}`,
+		"q3": `{"max_duration_ms": "-1s"}`,
+	}
+
+	r, err := RunQueries(context.Background(), model, ds, Run{})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if r.Exact != 1 || r.Errors != 2 {
+		t.Fatalf("got %d exact and %d errors, want 1 and 2:\n%s", r.Exact, r.Errors, r.Summary())
+	}
+
+	res := r.Results[1]
+	if !reflect.DeepEqual(res.Missing, []string{"service=a"}) ||
+		!reflect.DeepEqual(res.Extra, []string{"service=c", "tags=http.status_code=500"}) {
+		t.Fatalf("wrong-service: missing %v, extra %v", res.Missing, res.Extra)
+	}
+	if r.Results[2].Error == "" || r.Results[3].Error == "" {
+		t.Fatalf("invalid and unscripted cases did not record their errors")
+	}
+
+	want := map[string]Score{
+		"service":      {TP: 2, FP: 1, FN: 1},
+		"operation":    {FN: 1},
+		"min_duration": {TP: 1},
+		"max_duration": {FN: 1},
+		"start_time":   {TP: 1},
+		"end_time":     {TP: 1},
+		"tags":         {TP: 1, FP: 1},
+	}
+	if !reflect.DeepEqual(r.Fields, want) {
+		t.Fatalf("fields %+v, want %+v", r.Fields, want)
+	}
+	if r.Overall != (Score{TP: 6, FP: 2, FN: 3}) {
+		t.Fatalf("overall %+v", r.Overall)
+	}
+	if got := r.Fields["service"].Precision(); got != 2.0/3 {
+		t.Fatalf("service precision %v", got)
+	}
+}
+
+func TestCompareQueryReports(t *testing.T) {
+	ds := loadGolden(t)
+	good := perfect(t, ds)
+	base, err := RunQueries(context.Background(), good, ds, Run{Label: "base", Prompt: Fingerprint("v1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worse := scriptedLLM{}
+	for q, out := range good {
+		worse[q] = out
+	}
+	worse["errors since yesterday"] = `{"start_time": "yesterday", "tags": {}}`
+	delete(worse, "latency longer than 2s")
+	cur, err := RunQueries(context.Background(), worse, ds, Run{Label: "candidate", Prompt: Fingerprint("v2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reports survive a round trip through their file format.
+	path := filepath.Join(t.TempDir(), "base.json")
+	if err := WriteReport(path, base); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadQueryReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Exact != base.Exact || loaded.Overall != base.Overall || loaded.Prompt != base.Prompt {
+		t.Fatalf("report changed in a round trip")
+	}
+
+	out := CompareQueryReports(loaded, cur)
+	for _, want := range []string{
+		"base: base, prompt " + Fingerprint("v1"),
+		"this: candidate, prompt " + Fingerprint("v2"),
+		"regressed (2): min-latency, since",
+		"errors                 0         1        +1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("comparison misses %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "fixed") {
+		t.Fatalf("comparison lists fixed cases:\n%s", out)
+	}
+
+	summary := cur.Summary()
+	if !strings.Contains(summary, "since: \"errors since yesterday\"\n  missing: tags=error=true") {
+		t.Fatalf("summary misses the failed case:\n%s", summary)
+	}
+}
+
+func mustIR(t *testing.T, s string) ai.SearchIR {
+	t.Helper()
+	var ir ai.SearchIR
+	if err := json.Unmarshal([]byte(s), &ir); err != nil {
+		t.Fatal(err)
+	}
+	return ir
+}
@@ -0,0 +1,419 @@
+package jaeger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// The messages below mirror jaeger.api_v3.QueryService (query_service.proto
+// in jaeger-idl). jaeger-idl ships api_v3 only as .proto files; its Go
+// packages cover api_v2. The generated api_v3 stubs are built inside the
+// Jaeger server repository, and depending on it would pull the whole backend
+// into this client. The few messages used here are encoded by hand instead,
+// so field numbers must match the proto definition; apiv3_test.go checks them
+// against the protobuf runtime.
+
+const (
+	methodFindTraces    = "/jaeger.api_v3.QueryService/FindTraces"
+	methodGetTrace      = "/jaeger.api_v3.QueryService/GetTrace"
+	methodGetServices   = "/jaeger.api_v3.QueryService/GetServices"
+	methodGetOperations = "/jaeger.api_v3.QueryService/GetOperations"
+)
+
+// message is implemented by the requests and responses exchanged with the
+// query service.
+type message interface {
+	marshal() []byte
+	unmarshal(b []byte) error
+}
+
+// decoder is the receiving half of message, which is all tracesData needs.
+type decoder interface {
+	unmarshal(b []byte) error
+}
+
+// codec lets grpc send message values. It is forced per call rather than
+// registered, and reports the "proto" name so the content-type matches what
+// a Jaeger server expects.
+type codec struct{}
+
+func (codec) Name() string { return "proto" }
+
+func (codec) Marshal(v any) ([]byte, error) {
+	if d, ok := v.(*tracesData); ok {
+		return (&ptrace.ProtoMarshaler{}).MarshalTraces(d.Traces)
+	}
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("jaeger: cannot marshal %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (codec) Unmarshal(b []byte, v any) error {
+	m, ok := v.(decoder)
+	if !ok {
+		return fmt.Errorf("jaeger: cannot unmarshal into %T", v)
+	}
+	return m.unmarshal(b)
+}
+
+// traceQueryParameters is jaeger.api_v3.TraceQueryParameters.
+type traceQueryParameters struct {
+	ServiceName   string            // 1
+	OperationName string            // 2
+	Attributes    map[string]string // 3
+	StartTimeMin  time.Time         // 4
+	StartTimeMax  time.Time         // 5
+	DurationMin   time.Duration     // 6
+	DurationMax   time.Duration     // 7
+	SearchDepth   int32             // 8
+	RawTraces     bool              // 9
+}
+
+func (p *traceQueryParameters) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, p.ServiceName)
+	b = appendString(b, 2, p.OperationName)
+	for k, v := range p.Attributes {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, v)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	b = appendTimestamp(b, 4, p.StartTimeMin)
+	b = appendTimestamp(b, 5, p.StartTimeMax)
+	b = appendDuration(b, 6, p.DurationMin)
+	b = appendDuration(b, 7, p.DurationMax)
+	if p.SearchDepth != 0 {
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p.SearchDepth))
+	}
+	if p.RawTraces {
+		b = protowire.AppendTag(b, 9, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func (p *traceQueryParameters) unmarshal(b []byte) error {
+	*p = traceQueryParameters{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		var err error
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			p.ServiceName = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			p.OperationName = string(v)
+		case num == 3 && typ == protowire.BytesType:
+			var key, val string
+			err = walk(v, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					key = string(v)
+				case num == 2 && typ == protowire.BytesType:
+					val = string(v)
+				}
+				return nil
+			})
+			if p.Attributes == nil {
+				p.Attributes = make(map[string]string)
+			}
+			p.Attributes[key] = val
+		case num == 4 && typ == protowire.BytesType:
+			p.StartTimeMin, err = parseTimestamp(v)
+		case num == 5 && typ == protowire.BytesType:
+			p.StartTimeMax, err = parseTimestamp(v)
+		case num == 6 && typ == protowire.BytesType:
+			p.DurationMin, err = parseDuration(v)
+		case num == 7 && typ == protowire.BytesType:
+			p.DurationMax, err = parseDuration(v)
+		case num == 8 && typ == protowire.VarintType:
+			p.SearchDepth = int32(n)
+		case num == 9 && typ == protowire.VarintType:
+			p.RawTraces = n != 0
+		}
+		return err
+	})
+}
+
+// findTracesRequest is jaeger.api_v3.FindTracesRequest.
+type findTracesRequest struct {
+	Query traceQueryParameters // 1
+}
+
+func (r *findTracesRequest) marshal() []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, r.Query.marshal())
+}
+
+func (r *findTracesRequest) unmarshal(b []byte) error {
+	*r = findTracesRequest{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if num == 1 && typ == protowire.BytesType {
+			return r.Query.unmarshal(v)
+		}
+		return nil
+	})
+}
+
+// getTraceRequest is jaeger.api_v3.GetTraceRequest.
+type getTraceRequest struct {
+	TraceID   string    // 1, hex encoded
+	StartTime time.Time // 2
+	EndTime   time.Time // 3
+	RawTraces bool      // 4
+}
+
+func (r *getTraceRequest) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, r.TraceID)
+	b = appendTimestamp(b, 2, r.StartTime)
+	b = appendTimestamp(b, 3, r.EndTime)
+	if r.RawTraces {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func (r *getTraceRequest) unmarshal(b []byte) error {
+	*r = getTraceRequest{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		var err error
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			r.TraceID = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			r.StartTime, err = parseTimestamp(v)
+		case num == 3 && typ == protowire.BytesType:
+			r.EndTime, err = parseTimestamp(v)
+		case num == 4 && typ == protowire.VarintType:
+			r.RawTraces = n != 0
+		}
+		return err
+	})
+}
+
+// tracesData is opentelemetry.proto.trace.v1.TracesData, the payload of each
+// chunk streamed back by FindTraces and GetTrace. pdata does the encoding;
+// codec.Marshal handles it directly because MarshalTraces can fail.
+type tracesData struct {
+	Traces ptrace.Traces
+}
+
+func (d *tracesData) unmarshal(b []byte) error {
+	t, err := (&ptrace.ProtoUnmarshaler{}).UnmarshalTraces(b)
+	if err != nil {
+		return err
+	}
+	d.Traces = t
+	return nil
+}
+
+// getServicesRequest is jaeger.api_v3.GetServicesRequest, which has no
+// fields.
+type getServicesRequest struct{}
+
+func (*getServicesRequest) marshal() []byte        { return nil }
+func (*getServicesRequest) unmarshal([]byte) error { return nil }
+
+// getServicesResponse is jaeger.api_v3.GetServicesResponse.
+type getServicesResponse struct {
+	Services []string // 1
+}
+
+func (r *getServicesResponse) marshal() []byte {
+	var b []byte
+	for _, s := range r.Services {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+func (r *getServicesResponse) unmarshal(b []byte) error {
+	*r = getServicesResponse{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if num == 1 && typ == protowire.BytesType {
+			r.Services = append(r.Services, string(v))
+		}
+		return nil
+	})
+}
+
+// getOperationsRequest is jaeger.api_v3.GetOperationsRequest.
+type getOperationsRequest struct {
+	Service  string // 1
+	SpanKind string // 2
+}
+
+func (r *getOperationsRequest) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, r.Service)
+	b = appendString(b, 2, r.SpanKind)
+	return b
+}
+
+func (r *getOperationsRequest) unmarshal(b []byte) error {
+	*r = getOperationsRequest{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			r.Service = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			r.SpanKind = string(v)
+		}
+		return nil
+	})
+}
+
+// getOperationsResponse is jaeger.api_v3.GetOperationsResponse.
+type getOperationsResponse struct {
+	Operations []Operation // 1
+}
+
+func (r *getOperationsResponse) marshal() []byte {
+	var b []byte
+	for _, op := range r.Operations {
+		var o []byte
+		o = appendString(o, 1, op.Name)
+		o = appendString(o, 2, op.SpanKind)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, o)
+	}
+	return b
+}
+
+func (r *getOperationsResponse) unmarshal(b []byte) error {
+	*r = getOperationsResponse{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		var op Operation
+		err := walk(v, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				op.Name = string(v)
+			case num == 2 && typ == protowire.BytesType:
+				op.SpanKind = string(v)
+			}
+			return nil
+		})
+		r.Operations = append(r.Operations, op)
+		return err
+	})
+}
+
+// walk calls f for each field in b. Length-delimited fields are passed as v,
+// varints as n; other wire types are skipped. Unknown fields are ignored so
+// newer servers stay compatible.
+func walk(b []byte, f func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var (
+			v   []byte
+			val uint64
+		)
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			val, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := f(num, typ, v, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendTimestamp encodes t as a google.protobuf.Timestamp. The zero time is
+// left out, as an unset field.
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendSecondsNanos(b, num, t.Unix(), int64(t.Nanosecond()))
+}
+
+// appendDuration encodes d as a google.protobuf.Duration. Zero is left out.
+func appendDuration(b []byte, num protowire.Number, d time.Duration) []byte {
+	if d == 0 {
+		return b
+	}
+	return appendSecondsNanos(b, num, int64(d/time.Second), int64(d%time.Second))
+}
+
+func appendSecondsNanos(b []byte, num protowire.Number, sec, nanos int64) []byte {
+	var m []byte
+	if sec != 0 {
+		m = protowire.AppendTag(m, 1, protowire.VarintType)
+		m = protowire.AppendVarint(m, uint64(sec))
+	}
+	if nanos != 0 {
+		m = protowire.AppendTag(m, 2, protowire.VarintType)
+		m = protowire.AppendVarint(m, uint64(int32(nanos)))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func parseSecondsNanos(b []byte) (sec, nanos int64, err error) {
+	err = walk(b, func(num protowire.Number, typ protowire.Type, _ []byte, n uint64) error {
+		if typ != protowire.VarintType {
+			return errors.New("jaeger: malformed timestamp")
+		}
+		switch num {
+		case 1:
+			sec = int64(n)
+		case 2:
+			nanos = int64(int32(n))
+		}
+		return nil
+	})
+	return sec, nanos, err
+}
+
+func parseTimestamp(b []byte) (time.Time, error) {
+	sec, nanos, err := parseSecondsNanos(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, nanos).UTC(), nil
+}
+
+func parseDuration(b []byte) (time.Duration, error) {
+	sec, nanos, err := parseSecondsNanos(b)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(sec)*time.Second + time.Duration(nanos), nil
+}
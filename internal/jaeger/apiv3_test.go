@@ -0,0 +1,225 @@
+package jaeger
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	// Register google.protobuf.Timestamp and Duration for the descriptor.
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// apiV3File describes the api_v3 messages the codec handles, with the names,
+// types and field numbers of query_service.proto, so the protobuf runtime can
+// encode and decode them the way generated stubs would.
+func apiV3File(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+
+	const (
+		str       = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		msg       = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		boolean   = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		int32Type = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		timestamp = ".google.protobuf.Timestamp"
+		duration  = ".google.protobuf.Duration"
+	)
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		if repeated {
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+		return f
+	}
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+
+	attributesEntry := message("AttributesEntry",
+		field("key", 1, str, "", false),
+		field("value", 2, str, "", false),
+	)
+	attributesEntry.Options = &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}
+
+	queryParams := message("TraceQueryParameters",
+		field("service_name", 1, str, "", false),
+		field("operation_name", 2, str, "", false),
+		field("attributes", 3, msg, ".jaeger.api_v3.TraceQueryParameters.AttributesEntry", true),
+		field("start_time_min", 4, msg, timestamp, false),
+		field("start_time_max", 5, msg, timestamp, false),
+		field("duration_min", 6, msg, duration, false),
+		field("duration_max", 7, msg, duration, false),
+		field("search_depth", 8, int32Type, "", false),
+		field("raw_traces", 9, boolean, "", false),
+	)
+	queryParams.NestedType = []*descriptorpb.DescriptorProto{attributesEntry}
+
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("api_v3/query_service.proto"),
+		Package:    proto.String("jaeger.api_v3"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/duration.proto", "google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("GetTraceRequest",
+				field("trace_id", 1, str, "", false),
+				field("start_time", 2, msg, timestamp, false),
+				field("end_time", 3, msg, timestamp, false),
+				field("raw_traces", 4, boolean, "", false),
+			),
+			queryParams,
+			message("FindTracesRequest",
+				field("query", 1, msg, ".jaeger.api_v3.TraceQueryParameters", false),
+			),
+			message("GetServicesResponse",
+				field("services", 1, str, "", true),
+			),
+			message("GetOperationsRequest",
+				field("service", 1, str, "", false),
+				field("span_kind", 2, str, "", false),
+			),
+			message("Operation",
+				field("name", 1, str, "", false),
+				field("span_kind", 2, str, "", false),
+			),
+			message("GetOperationsResponse",
+				field("operations", 1, msg, ".jaeger.api_v3.Operation", true),
+			),
+		},
+	}
+
+	file, err := protodesc.NewFile(fd, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("build api_v3 descriptor: %v", err)
+	}
+	return file
+}
+
+// TestAPIv3_WireCompatible round-trips every message through the protobuf
+// runtime: what the runtime encodes from an api_v3 JSON document must decode
+// to the expected value here, and what is encoded here must decode to the
+// same document.
+func TestAPIv3_WireCompatible(t *testing.T) {
+	file := apiV3File(t)
+
+	tests := []struct {
+		message string
+		json    string
+		want    message
+		decoded func() message
+	}{
+		{
+			message: "FindTracesRequest",
+			json: `{"query": {
+				"serviceName": "payment-svc",
+				"operationName": "Authorize",
+				"attributes": {"error": "true", "http.status_code": "402"},
+				"startTimeMin": "2024-01-01T12:00:00Z",
+				"startTimeMax": "2024-01-01T13:30:00.250Z",
+				"durationMin": "1.500s",
+				"durationMax": "3s",
+				"searchDepth": 50,
+				"rawTraces": true
+			}}`,
+			want: &findTracesRequest{Query: traceQueryParameters{
+				ServiceName:   "payment-svc",
+				OperationName: "Authorize",
+				Attributes:    map[string]string{"error": "true", "http.status_code": "402"},
+				StartTimeMin:  benchStart,
+				StartTimeMax:  benchStart.Add(90*time.Minute + 250*time.Millisecond),
+				DurationMin:   1500 * time.Millisecond,
+				DurationMax:   3 * time.Second,
+				SearchDepth:   50,
+				RawTraces:     true,
+			}},
+			decoded: func() message { return &findTracesRequest{} },
+		},
+		{
+			message: "GetTraceRequest",
+			json: `{
+				"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"startTime": "2024-01-01T12:00:00Z",
+				"endTime": "2024-01-01T14:00:00Z"
+			}`,
+			want: &getTraceRequest{
+				TraceID:   "4bf92f3577b34da6a3ce929d0e0e4736",
+				StartTime: benchStart,
+				EndTime:   benchStart.Add(2 * time.Hour),
+			},
+			decoded: func() message { return &getTraceRequest{} },
+		},
+		{
+			message: "GetServicesResponse",
+			json:    `{"services": ["frontend", "payment-svc"]}`,
+			want:    &getServicesResponse{Services: []string{"frontend", "payment-svc"}},
+			decoded: func() message { return &getServicesResponse{} },
+		},
+		{
+			message: "GetOperationsRequest",
+			json:    `{"service": "payment-svc", "spanKind": "server"}`,
+			want:    &getOperationsRequest{Service: "payment-svc", SpanKind: "server"},
+			decoded: func() message { return &getOperationsRequest{} },
+		},
+		{
+			message: "GetOperationsResponse",
+			json: `{"operations": [
+				{"name": "Authorize", "spanKind": "server"},
+				{"name": "Capture", "spanKind": "client"}
+			]}`,
+			want: &getOperationsResponse{Operations: []Operation{
+				{Name: "Authorize", SpanKind: "server"},
+				{Name: "Capture", SpanKind: "client"},
+			}},
+			decoded: func() message { return &getOperationsResponse{} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			desc := file.Messages().ByName(protoreflect.Name(tt.message))
+			doc := dynamicpb.NewMessage(desc)
+			if err := protojson.Unmarshal([]byte(tt.json), doc); err != nil {
+				t.Fatalf("parse %s: %v", tt.message, err)
+			}
+
+			wire, err := proto.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := tt.decoded()
+			if err := (codec{}).Unmarshal(wire, got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decoded\n %+v\nwant\n %+v", got, tt.want)
+			}
+
+			ours, err := (codec{}).Marshal(tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			back := dynamicpb.NewMessage(desc)
+			if err := proto.Unmarshal(ours, back); err != nil {
+				t.Fatalf("protobuf runtime rejects our encoding: %v", err)
+			}
+			if !proto.Equal(back, doc) {
+				t.Fatalf("our encoding decodes to\n %v\nwant\n %v", back, doc)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+// Package jaeger reads traces from a Jaeger query service through its api_v3
+// gRPC QueryService.
+package jaeger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+const (
+	// DefaultLookback is the search window used when a query gives no start
+	// time. Jaeger rejects FindTraces without one.
+	DefaultLookback = time.Hour
+
+	// DefaultSearchDepth matches the Jaeger UI's default result limit.
+	DefaultSearchDepth = 20
+)
+
+// Operation is an operation name reported by GetOperations.
+type Operation struct {
+	Name     string
+	SpanKind string
+}
+
+// GRPCTraceReader implements internal.TraceReader against a Jaeger query
+// service.
+type GRPCTraceReader struct {
+	conn grpc.ClientConnInterface
+
+	// Lookback is the search window for queries without a start time. 0
+	// uses DefaultLookback.
+	Lookback time.Duration
+
+	// Now is the clock used to fill in missing time bounds. Nil means
+	// time.Now.
+	Now func() time.Time
+
+	// Logger records each call with its duration at debug level and
+	// failures as warnings; nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+func NewGRPCTraceReader(conn grpc.ClientConnInterface) *GRPCTraceReader {
+	return &GRPCTraceReader{conn: conn}
+}
+
+// Dial connects to the query service's gRPC endpoint, e.g. localhost:16685,
+// without TLS. The connection is established lazily on the first call.
+func Dial(endpoint string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	return grpc.NewClient(endpoint, opts...)
+}
+
+// FindTraces streams matching traces from Jaeger. Each chunk received from
+// the server becomes one batch; a trace split across consecutive chunks is
+// held back and yielded once complete. Older Jaeger versions answer an empty
+// search with NotFound, which is treated as no results.
+func (r *GRPCTraceReader) FindTraces(
+	ctx context.Context,
+	query internal.TraceQueryParams,
+) iter.Seq2[[]ptrace.Traces, error] {
+	return func(yield func([]ptrace.Traces, error) bool) {
+		began := time.Now()
+		batches, found := 0, 0
+		var failed error
+		for batch, err := range r.findTraces(ctx, query) {
+			if err != nil {
+				failed = err
+			} else {
+				batches++
+				found += len(batch)
+			}
+			if !yield(batch, err) {
+				break
+			}
+		}
+
+		attrs := []any{
+			"service", query.ServiceName,
+			"operation", query.OperationName,
+			"batches", batches,
+			"traces", found,
+			"duration", time.Since(began),
+		}
+		if failed != nil {
+			logging.OrDefault(r.Logger).WarnContext(ctx, "jaeger find traces failed", append(attrs, "error", failed)...)
+			return
+		}
+		logging.OrDefault(r.Logger).DebugContext(ctx, "jaeger find traces", attrs...)
+	}
+}
+
+func (r *GRPCTraceReader) findTraces(
+	ctx context.Context,
+	query internal.TraceQueryParams,
+) iter.Seq2[[]ptrace.Traces, error] {
+	return func(yield func([]ptrace.Traces, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		req := &findTracesRequest{Query: r.toProto(query)}
+		stream, err := r.openStream(ctx, methodFindTraces, req)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		var pending ptrace.Traces
+		hasPending := false
+		for {
+			var chunk tracesData
+			err := stream.RecvMsg(&chunk)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if status.Code(err) == codes.NotFound {
+				break
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			traces := splitByTraceID(chunk.Traces)
+			if len(traces) == 0 {
+				continue
+			}
+			if hasPending {
+				if traceutil.TraceID(traces[0]) == traceutil.TraceID(pending) {
+					traces[0].ResourceSpans().MoveAndAppendTo(pending.ResourceSpans())
+					traces = traces[1:]
+				}
+				if len(traces) == 0 {
+					continue
+				}
+				traces = append([]ptrace.Traces{pending}, traces...)
+			}
+
+			pending = traces[len(traces)-1]
+			hasPending = true
+			if len(traces) == 1 {
+				continue
+			}
+			if !yield(traces[:len(traces)-1], nil) {
+				return
+			}
+		}
+
+		if hasPending {
+			yield([]ptrace.Traces{pending}, nil)
+		}
+	}
+}
+
+// GetTrace fetches a single trace by ID. It returns internal.ErrTraceNotFound
+// when Jaeger has no trace with that ID.
+func (r *GRPCTraceReader) GetTrace(ctx context.Context, id pcommon.TraceID) (ptrace.Traces, error) {
+	began := time.Now()
+	t, err := r.getTrace(ctx, id)
+
+	logger := logging.OrDefault(r.Logger)
+	switch {
+	case err == nil:
+		logger.DebugContext(ctx, "jaeger get trace", "trace_id", id.String(), "spans", t.SpanCount(), "duration", time.Since(began))
+	case !errors.Is(err, internal.ErrTraceNotFound):
+		logger.WarnContext(ctx, "jaeger get trace failed", "trace_id", id.String(), "duration", time.Since(began), "error", err)
+	}
+	return t, err
+}
+
+func (r *GRPCTraceReader) getTrace(ctx context.Context, id pcommon.TraceID) (ptrace.Traces, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := r.openStream(ctx, methodGetTrace, &getTraceRequest{TraceID: id.String()})
+	if err != nil {
+		return ptrace.Traces{}, err
+	}
+
+	trace := ptrace.NewTraces()
+	for {
+		var chunk tracesData
+		err := stream.RecvMsg(&chunk)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if status.Code(err) == codes.NotFound {
+			return ptrace.Traces{}, internal.ErrTraceNotFound
+		}
+		if err != nil {
+			return ptrace.Traces{}, err
+		}
+		chunk.Traces.ResourceSpans().MoveAndAppendTo(trace.ResourceSpans())
+	}
+
+	if trace.SpanCount() == 0 {
+		return ptrace.Traces{}, internal.ErrTraceNotFound
+	}
+	return trace, nil
+}
+
+// GetServices lists the services known to Jaeger.
+func (r *GRPCTraceReader) GetServices(ctx context.Context) ([]string, error) {
+	var resp getServicesResponse
+	if err := r.conn.Invoke(ctx, methodGetServices, &getServicesRequest{}, &resp, grpc.ForceCodec(codec{})); err != nil {
+		return nil, err
+	}
+	return resp.Services, nil
+}
+
+// GetOperations lists the operations of service. An empty spanKind returns
+// operations of every kind.
+func (r *GRPCTraceReader) GetOperations(ctx context.Context, service, spanKind string) ([]Operation, error) {
+	req := &getOperationsRequest{Service: service, SpanKind: spanKind}
+	var resp getOperationsResponse
+	if err := r.conn.Invoke(ctx, methodGetOperations, req, &resp, grpc.ForceCodec(codec{})); err != nil {
+		return nil, err
+	}
+	return resp.Operations, nil
+}
+
+func (r *GRPCTraceReader) openStream(ctx context.Context, method string, req message) (grpc.ClientStream, error) {
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	stream, err := r.conn.NewStream(ctx, desc, method, grpc.ForceCodec(codec{}))
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// toProto maps query onto the api_v3 request. Jaeger requires a time window
+// and a positive search depth, so missing values are filled in from Lookback
+// and DefaultSearchDepth.
+func (r *GRPCTraceReader) toProto(query internal.TraceQueryParams) traceQueryParameters {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+	lookback := r.Lookback
+	if lookback <= 0 {
+		lookback = DefaultLookback
+	}
+
+	p := traceQueryParameters{
+		ServiceName:   query.ServiceName,
+		OperationName: query.OperationName,
+		StartTimeMin:  query.StartTimeMin,
+		StartTimeMax:  query.StartTimeMax,
+		DurationMin:   query.DurationMin,
+		DurationMax:   query.DurationMax,
+		SearchDepth:   int32(query.SearchDepth),
+	}
+	if p.StartTimeMax.IsZero() {
+		p.StartTimeMax = now()
+	}
+	if p.StartTimeMin.IsZero() {
+		p.StartTimeMin = p.StartTimeMax.Add(-lookback)
+	}
+	if p.SearchDepth <= 0 {
+		p.SearchDepth = DefaultSearchDepth
+	}
+
+	if query.Attributes != (pcommon.Map{}) && query.Attributes.Len() > 0 {
+		p.Attributes = make(map[string]string, query.Attributes.Len())
+		for k, v := range query.Attributes.All() {
+			p.Attributes[k] = v.AsString()
+		}
+	}
+	return p
+}
+
+// splitByTraceID regroups the spans of td into one ptrace.Traces per trace ID,
+// in the order the IDs first appear. Resource and scope are copied alongside
+// the spans.
+func splitByTraceID(td ptrace.Traces) []ptrace.Traces {
+	var order []pcommon.TraceID
+	out := make(map[pcommon.TraceID]ptrace.Traces)
+
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		dstRS := make(map[pcommon.TraceID]ptrace.ResourceSpans)
+
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			ss := rs.ScopeSpans().At(j)
+			dstSS := make(map[pcommon.TraceID]ptrace.ScopeSpans)
+
+			for k := 0; k < ss.Spans().Len(); k++ {
+				span := ss.Spans().At(k)
+				id := span.TraceID()
+
+				t, ok := out[id]
+				if !ok {
+					t = ptrace.NewTraces()
+					out[id] = t
+					order = append(order, id)
+				}
+				r, ok := dstRS[id]
+				if !ok {
+					r = t.ResourceSpans().AppendEmpty()
+					rs.Resource().CopyTo(r.Resource())
+					r.SetSchemaUrl(rs.SchemaUrl())
+					dstRS[id] = r
+				}
+				s, ok := dstSS[id]
+				if !ok {
+					s = r.ScopeSpans().AppendEmpty()
+					ss.Scope().CopyTo(s.Scope())
+					s.SetSchemaUrl(ss.SchemaUrl())
+					dstSS[id] = s
+				}
+				span.CopyTo(s.Spans().AppendEmpty())
+			}
+		}
+	}
+
+	traces := make([]ptrace.Traces, 0, len(order))
+	for _, id := range order {
+		traces = append(traces, out[id])
+	}
+	return traces
+}
@@ -0,0 +1,348 @@
+package jaeger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal"
+	"github.com/jaeger-ai-assist-prototype/internal/synthetic"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+var benchStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeQueryService answers api_v3 calls from traces_bench.json. FindTraces
+// results are streamed chunkSize resource spans at a time, so traces are
+// split across chunks and several traces share a chunk.
+type fakeQueryService struct {
+	traces    []ptrace.Traces
+	chunkSize int
+	err       error
+
+	lastQuery traceQueryParameters
+}
+
+func (f *fakeQueryService) findTraces(stream grpc.ServerStream) error {
+	var req findTracesRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	f.lastQuery = req.Query
+	if f.err != nil {
+		return f.err
+	}
+
+	q := req.Query
+	attrs := pcommon.NewMap()
+	for k, v := range q.Attributes {
+		attrs.PutStr(k, v)
+	}
+	params := internal.TraceQueryParams{
+		ServiceName:   q.ServiceName,
+		OperationName: q.OperationName,
+		Attributes:    attrs,
+		StartTimeMin:  q.StartTimeMin,
+		StartTimeMax:  q.StartTimeMax,
+		DurationMin:   q.DurationMin,
+		DurationMax:   q.DurationMax,
+		SearchDepth:   int(q.SearchDepth),
+	}
+
+	all := ptrace.NewTraces()
+	reader := synthetic.NewSyntheticTraceReader(f.traces)
+	for batch, err := range reader.FindTraces(stream.Context(), params) {
+		if err != nil {
+			return err
+		}
+		for _, t := range batch {
+			for i := 0; i < t.ResourceSpans().Len(); i++ {
+				t.ResourceSpans().At(i).CopyTo(all.ResourceSpans().AppendEmpty())
+			}
+		}
+	}
+
+	rs := all.ResourceSpans()
+	for i := 0; i < rs.Len(); i += f.chunkSize {
+		chunk := ptrace.NewTraces()
+		for j := i; j < i+f.chunkSize && j < rs.Len(); j++ {
+			rs.At(j).CopyTo(chunk.ResourceSpans().AppendEmpty())
+		}
+		if err := stream.SendMsg(&tracesData{Traces: chunk}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeQueryService) getTrace(stream grpc.ServerStream) error {
+	var req getTraceRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	for _, t := range f.traces {
+		if traceutil.TraceID(t).String() == req.TraceID {
+			return stream.SendMsg(&tracesData{Traces: t})
+		}
+	}
+	return status.Error(codes.NotFound, "trace not found")
+}
+
+func (f *fakeQueryService) register(s *grpc.Server) {
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "jaeger.api_v3.QueryService",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "GetServices",
+				Handler: func(_ any, _ context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+					if err := dec(&getServicesRequest{}); err != nil {
+						return nil, err
+					}
+					return &getServicesResponse{Services: []string{"frontend", "payment-svc"}}, nil
+				},
+			},
+			{
+				MethodName: "GetOperations",
+				Handler: func(_ any, _ context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+					var req getOperationsRequest
+					if err := dec(&req); err != nil {
+						return nil, err
+					}
+					if req.Service != "payment-svc" {
+						return &getOperationsResponse{}, nil
+					}
+					return &getOperationsResponse{Operations: []Operation{
+						{Name: "Authorize", SpanKind: req.SpanKind},
+					}}, nil
+				},
+			},
+		},
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "FindTraces",
+				ServerStreams: true,
+				Handler:       func(_ any, s grpc.ServerStream) error { return f.findTraces(s) },
+			},
+			{
+				StreamName:    "GetTrace",
+				ServerStreams: true,
+				Handler:       func(_ any, s grpc.ServerStream) error { return f.getTrace(s) },
+			},
+		},
+	}, f)
+}
+
+func newTestReader(t *testing.T, fake *fakeQueryService) *GRPCTraceReader {
+	t.Helper()
+
+	traces, err := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatalf("load bench traces: %v", err)
+	}
+	fake.traces = traces
+	if fake.chunkSize == 0 {
+		fake.chunkSize = 4
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(codec{}))
+	fake.register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := Dial("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	r := NewGRPCTraceReader(conn)
+	r.Now = func() time.Time { return benchStart.Add(30 * time.Minute) }
+	return r
+}
+
+func collect(t *testing.T, r *GRPCTraceReader, q internal.TraceQueryParams) []ptrace.Traces {
+	t.Helper()
+	var out []ptrace.Traces
+	for batch, err := range r.FindTraces(context.Background(), q) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		out = append(out, batch...)
+	}
+	return out
+}
+
+func TestGRPCTraceReader_FindTraces(t *testing.T) {
+	fake := &fakeQueryService{}
+	r := newTestReader(t, fake)
+
+	attrs := pcommon.NewMap()
+	attrs.PutStr("http.status_code", "402")
+
+	tests := []struct {
+		name  string
+		query internal.TraceQueryParams
+		want  int
+	}{
+		{"default depth", internal.TraceQueryParams{}, DefaultSearchDepth},
+		{"service", internal.TraceQueryParams{ServiceName: "payment-svc", SearchDepth: 100}, 34},
+		{"service and operation", internal.TraceQueryParams{ServiceName: "payment-svc", OperationName: "Authorize", SearchDepth: 100}, 34},
+		{"attribute", internal.TraceQueryParams{Attributes: attrs, SearchDepth: 100}, 34},
+		{"duration range", internal.TraceQueryParams{DurationMin: 60 * time.Millisecond, DurationMax: 150 * time.Millisecond, SearchDepth: 100}, 33},
+		{"start time range", internal.TraceQueryParams{StartTimeMin: benchStart, StartTimeMax: benchStart.Add(9 * time.Second)}, 10},
+		{"outside lookback", internal.TraceQueryParams{StartTimeMax: benchStart.Add(-time.Second)}, 0},
+		{"search depth", internal.TraceQueryParams{SearchDepth: 5}, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collect(t, r, tt.query)
+			if len(got) != tt.want {
+				t.Fatalf("got %d traces, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCTraceReader_ReassemblesChunks(t *testing.T) {
+	for _, size := range []int{1, 3, 4, 1000} {
+		fake := &fakeQueryService{chunkSize: size}
+		r := newTestReader(t, fake)
+
+		got := collect(t, r, internal.TraceQueryParams{SearchDepth: 100})
+		if len(got) != len(fake.traces) {
+			t.Fatalf("chunk size %d: got %d traces, want %d", size, len(got), len(fake.traces))
+		}
+		for i, tr := range got {
+			want := fake.traces[i]
+			if traceutil.TraceID(tr) != traceutil.TraceID(want) {
+				t.Fatalf("chunk size %d: trace %d out of order", size, i)
+			}
+			if tr.SpanCount() != want.SpanCount() {
+				t.Fatalf("chunk size %d: trace %d has %d spans, want %d", size, i, tr.SpanCount(), want.SpanCount())
+			}
+			ids := map[pcommon.TraceID]bool{}
+			traceutil.ForEachSpan(tr, func(_ pcommon.Resource, span ptrace.Span) bool {
+				ids[span.TraceID()] = true
+				return true
+			})
+			if len(ids) != 1 {
+				t.Fatalf("chunk size %d: trace %d mixes %d trace IDs", size, i, len(ids))
+			}
+		}
+	}
+}
+
+func TestGRPCTraceReader_FillsTimeWindow(t *testing.T) {
+	fake := &fakeQueryService{}
+	r := newTestReader(t, fake)
+	r.Lookback = 2 * time.Hour
+
+	collect(t, r, internal.TraceQueryParams{ServiceName: "frontend"})
+
+	now := benchStart.Add(30 * time.Minute)
+	q := fake.lastQuery
+	if !q.StartTimeMax.Equal(now) || !q.StartTimeMin.Equal(now.Add(-2*time.Hour)) {
+		t.Fatalf("unexpected window [%v, %v]", q.StartTimeMin, q.StartTimeMax)
+	}
+	if q.SearchDepth != DefaultSearchDepth {
+		t.Fatalf("got search depth %d, want %d", q.SearchDepth, DefaultSearchDepth)
+	}
+	if q.ServiceName != "frontend" {
+		t.Fatalf("got service %q", q.ServiceName)
+	}
+}
+
+func TestGRPCTraceReader_ServerError(t *testing.T) {
+	fake := &fakeQueryService{err: status.Error(codes.Unavailable, "storage down")}
+	r := newTestReader(t, fake)
+
+	for _, err := range r.FindTraces(context.Background(), internal.TraceQueryParams{}) {
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("got %v, want Unavailable", err)
+		}
+		return
+	}
+	t.Fatalf("expected the iterator to yield an error")
+}
+
+func TestGRPCTraceReader_GetTrace(t *testing.T) {
+	fake := &fakeQueryService{}
+	r := newTestReader(t, fake)
+
+	want := fake.traces[7]
+	got, err := r.GetTrace(context.Background(), traceutil.TraceID(want))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.SpanCount() != want.SpanCount() {
+		t.Fatalf("got %d spans, want %d", got.SpanCount(), want.SpanCount())
+	}
+
+	_, err = r.GetTrace(context.Background(), pcommon.TraceID{0xde, 0xad})
+	if !errors.Is(err, internal.ErrTraceNotFound) {
+		t.Fatalf("got %v, want ErrTraceNotFound", err)
+	}
+}
+
+func TestGRPCTraceReader_ServicesAndOperations(t *testing.T) {
+	r := newTestReader(t, &fakeQueryService{})
+
+	services, err := r.GetServices(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services) != 2 || services[1] != "payment-svc" {
+		t.Fatalf("unexpected services %v", services)
+	}
+
+	ops, err := r.GetOperations(context.Background(), "payment-svc", "server")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ops) != 1 || ops[0] != (Operation{Name: "Authorize", SpanKind: "server"}) {
+		t.Fatalf("unexpected operations %v", ops)
+	}
+}
+
+func TestTraceQueryParameters_RoundTrip(t *testing.T) {
+	in := traceQueryParameters{
+		ServiceName:   "payment-svc",
+		OperationName: "Authorize",
+		Attributes:    map[string]string{"error": "true", "http.status_code": "402"},
+		StartTimeMin:  benchStart,
+		StartTimeMax:  benchStart.Add(90*time.Minute + 250*time.Millisecond),
+		DurationMin:   1500 * time.Millisecond,
+		DurationMax:   3 * time.Second,
+		SearchDepth:   50,
+	}
+
+	var out findTracesRequest
+	if err := out.unmarshal((&findTracesRequest{Query: in}).marshal()); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	got := out.Query
+	if got.ServiceName != in.ServiceName || got.OperationName != in.OperationName ||
+		!got.StartTimeMin.Equal(in.StartTimeMin) || !got.StartTimeMax.Equal(in.StartTimeMax) ||
+		got.DurationMin != in.DurationMin || got.DurationMax != in.DurationMax ||
+		got.SearchDepth != in.SearchDepth || len(got.Attributes) != 2 ||
+		got.Attributes["http.status_code"] != "402" {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", got, in)
+	}
+}
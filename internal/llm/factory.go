@@ -2,37 +2,326 @@ package llm
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
 
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
 	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
 
 	"github.com/jaeger-ai-assist-prototype/internal/llm/langchain"
+	"github.com/jaeger-ai-assist-prototype/internal/llm/replay"
 )
 
+const (
+	defaultLlamaCppEndpoint = "http://localhost:8080/v1"
+
+	// placeholderAPIKey is sent to self-hosted OpenAI-compatible servers
+	// (vLLM, llama.cpp) that do not check keys; the client refuses to start
+	// without one.
+	placeholderAPIKey = "not-needed"
+)
+
+// NewLLM builds the client for cfg.Provider, wrapped for recording when
+// cfg.Replay asks for it. In replay mode no provider is contacted at all.
 func NewLLM(cfg langchain.LLMConfig) (llms.Model, error) {
-	switch cfg.Provider {
-	case "ollama":
-		opts := []ollama.Option{
-			ollama.WithModel(cfg.Model),
+	return newLLM(cfg, false)
+}
+
+// NewExtractionLLM is NewLLM for extraction calls. When the extraction task
+// sets json_schema the client carries the provider's own output option:
+// response_format with the SearchIR schema for OpenAI-compatible servers,
+// format "json" for Ollama, whose client takes no schema.
+func NewExtractionLLM(cfg langchain.LLMConfig) (llms.Model, error) {
+	return newLLM(cfg, cfg.Generation(langchain.TaskExtraction).UsesSchema())
+}
+
+func newLLM(cfg langchain.LLMConfig, structured bool) (llms.Model, error) {
+	mode := replay.Mode(cfg.Replay.Mode)
+	if mode == "" {
+		return newProvider(cfg, structured)
+	}
+	if cfg.Replay.Dir == "" {
+		return nil, fmt.Errorf("replay mode %q requires dir", mode)
+	}
+
+	var next llms.Model
+	if mode == replay.Record {
+		var err error
+		if next, err = newProvider(cfg, structured); err != nil {
+			return nil, err
 		}
+	}
+	return replay.New(next, replayModel(cfg, structured), cfg.Replay.Dir, mode)
+}
 
-		if cfg.Endpoint != "" {
-			opts = append(opts, ollama.WithServerURL(cfg.Endpoint))
+// replayModel names the client newProvider builds, for the fixture keys.
+func replayModel(cfg langchain.LLMConfig, structured bool) string {
+	name := cfg.Provider + "/" + cfg.Model
+	if structured {
+		name += " structured"
+		if cfg.Generation(langchain.TaskExtraction).StrictSchema() {
+			name += " strict"
 		}
+	}
+	return name
+}
 
-		// if cfg.Temperature != 0 {
-		// 	opts = append(opts, ollama.With(float32(cfg.Temperature)))
-		// }
+func newProvider(cfg langchain.LLMConfig, structured bool) (llms.Model, error) {
+	switch cfg.Provider {
+	case "ollama":
+		return newOllama(cfg, structured)
 
-		// if cfg.MaxTokens > 0 {
-		// 	opts = append(opts, ollama.WithPredictNumPredict(cfg.MaxTokens))
-		// }
+	case "openai":
+		return newOpenAI(cfg, "OPENAI_API_KEY", false, structured)
 
-		opts = append(opts, ollama.WithPullModel())
+	case "openai-compatible", "vllm":
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("provider %q requires endpoint", cfg.Provider)
+		}
+		return newOpenAI(cfg, "OPENAI_API_KEY", true, structured)
+
+	case "llamacpp", "llama.cpp":
+		if cfg.Endpoint == "" {
+			cfg.Endpoint = defaultLlamaCppEndpoint
+		}
+		return newOpenAI(cfg, "", true, structured)
 
-		return ollama.New(opts...)
+	case "anthropic":
+		return newAnthropic(cfg)
 
 	default:
 		return nil, errors.New("unsupported LLM provider")
 	}
 }
+
+func newOllama(cfg langchain.LLMConfig, structured bool) (llms.Model, error) {
+	opts := []ollama.Option{
+		ollama.WithModel(cfg.Model),
+	}
+
+	if cfg.Endpoint != "" {
+		opts = append(opts, ollama.WithServerURL(cfg.Endpoint))
+	}
+
+	if structured {
+		opts = append(opts, ollama.WithFormat("json"))
+	}
+
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, ollama.WithHTTPClient(headerClient(cfg.Headers)))
+	}
+
+	opts = append(opts, ollama.WithPullModel())
+
+	return ollama.New(opts...)
+}
+
+// newOpenAI builds a client for the OpenAI chat completions API. selfHosted
+// servers get a placeholder key when none is configured.
+func newOpenAI(cfg langchain.LLMConfig, defaultKeyEnv string, selfHosted, structured bool) (llms.Model, error) {
+	token, err := apiKey(cfg, defaultKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" && selfHosted {
+		token = placeholderAPIKey
+	}
+
+	opts := []openai.Option{
+		openai.WithModel(cfg.Model),
+		openai.WithToken(token),
+	}
+
+	if cfg.Endpoint != "" {
+		opts = append(opts, openai.WithBaseURL(cfg.Endpoint))
+	}
+
+	if cfg.Organization != "" {
+		opts = append(opts, openai.WithOrganization(cfg.Organization))
+	}
+
+	if structured {
+		strict := cfg.Generation(langchain.TaskExtraction).StrictSchema()
+		opts = append(opts, openai.WithResponseFormat(searchIRResponseFormat(strict)))
+	}
+
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, openai.WithHTTPClient(headerClient(cfg.Headers)))
+	}
+
+	return openai.New(opts...)
+}
+
+func newAnthropic(cfg langchain.LLMConfig) (llms.Model, error) {
+	token, err := apiKey(cfg, "ANTHROPIC_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []anthropic.Option{
+		anthropic.WithModel(cfg.Model),
+		anthropic.WithToken(token),
+	}
+
+	if cfg.Endpoint != "" {
+		opts = append(opts, anthropic.WithBaseURL(cfg.Endpoint))
+	}
+
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, anthropic.WithHTTPClient(headerClient(cfg.Headers)))
+	}
+
+	return anthropic.New(opts...)
+}
+
+// apiKey reads the key from the environment variable named by api_key_env,
+// falling back to the provider's conventional variable. Naming a variable
+// explicitly that is not set is a configuration error.
+func apiKey(cfg langchain.LLMConfig, defaultEnv string) (string, error) {
+	if cfg.APIKeyEnv != "" {
+		v, ok := os.LookupEnv(cfg.APIKeyEnv)
+		if !ok {
+			return "", fmt.Errorf("api_key_env %s is not set", cfg.APIKeyEnv)
+		}
+		return v, nil
+	}
+	if defaultEnv == "" {
+		return "", nil
+	}
+	return os.Getenv(defaultEnv), nil
+}
+
+// searchIRResponseFormat is the OpenAI response_format for extraction, which
+// vLLM and the llama.cpp server also accept. strict asks the provider to
+// enforce the schema exactly.
+func searchIRResponseFormat(strict bool) *openai.ResponseFormat {
+	return &openai.ResponseFormat{
+		Type: "json_schema",
+		JSONSchema: &openai.ResponseFormatJSONSchema{
+			Name:   "search_ir",
+			Strict: strict,
+			Schema: openAIProperty(langchain.SearchIRSchema(), strict),
+		},
+	}
+}
+
+// openAIProperty converts a JSON schema to the client's schema type, which
+// holds a single type per property. A string-or-list union becomes a list,
+// which the IR decoder accepts too. Null alternatives cannot be expressed,
+// so nullable strings are described as empty when not given; the IR decoder
+// reads "" as null.
+//
+// Strict mode needs every property required and no additionalProperties on
+// any object, so a free-form map becomes a list of key/value pairs, which
+// the IR decoder also accepts.
+func openAIProperty(schema map[string]any, strict bool) *openai.ResponseFormatJSONSchemaProperty {
+	nullable := false
+	if alts, ok := schema["anyOf"].([]any); ok {
+		var picked map[string]any
+		for _, a := range alts {
+			alt, _ := a.(map[string]any)
+			switch alt["type"] {
+			case "null":
+				nullable = true
+			case "array":
+				picked = alt
+			default:
+				if picked == nil {
+					picked = alt
+				}
+			}
+		}
+		schema = picked
+	}
+
+	p := &openai.ResponseFormatJSONSchemaProperty{}
+	switch typ := schema["type"].(type) {
+	case string:
+		p.Type = typ
+	case []any:
+		for _, t := range typ {
+			if t == "null" {
+				nullable = true
+			} else if p.Type == "" {
+				p.Type, _ = t.(string)
+			}
+		}
+	}
+	if nullable && p.Type == "string" {
+		p.Description = "Empty string when not given."
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		p.Items = openAIProperty(items, strict)
+	}
+	if props, ok := schema["properties"].(map[string]any); ok {
+		p.Properties = make(map[string]*openai.ResponseFormatJSONSchemaProperty, len(props))
+		for name, v := range props {
+			sub, _ := v.(map[string]any)
+			p.Properties[name] = openAIProperty(sub, strict)
+		}
+	}
+	switch req := schema["required"].(type) {
+	case []string:
+		p.Required = append([]string(nil), req...)
+	case []any:
+		for _, r := range req {
+			if name, ok := r.(string); ok {
+				p.Required = append(p.Required, name)
+			}
+		}
+	}
+
+	switch ap := schema["additionalProperties"].(type) {
+	case bool:
+		p.AdditionalProperties = ap
+	case map[string]any:
+		if !strict {
+			p.AdditionalProperties = true
+			break
+		}
+		value := openAIProperty(ap, strict)
+		return &openai.ResponseFormatJSONSchemaProperty{
+			Type:        "array",
+			Description: "Key/value pairs.",
+			Items: &openai.ResponseFormatJSONSchemaProperty{
+				Type:       "object",
+				Properties: map[string]*openai.ResponseFormatJSONSchemaProperty{"key": {Type: "string"}, "value": value},
+				Required:   []string{"key", "value"},
+			},
+		}
+	}
+	if strict && p.Type == "object" {
+		p.AdditionalProperties = false
+		p.Required = p.Required[:0]
+		for name := range p.Properties {
+			p.Required = append(p.Required, name)
+		}
+		sort.Strings(p.Required)
+	}
+	return p
+}
+
+// headerTransport adds fixed headers (gateway auth, org routing) to every
+// request.
+type headerTransport struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	return t.next.RoundTrip(req)
+}
+
+func headerClient(headers map[string]string) *http.Client {
+	return &http.Client{
+		Transport: &headerTransport{headers: headers, next: http.DefaultTransport},
+	}
+}
@@ -0,0 +1,339 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+
+	"github.com/jaeger-ai-assist-prototype/internal/llm/langchain"
+	"github.com/jaeger-ai-assist-prototype/internal/llm/replay"
+)
+
+// stubServer records the last request and answers chat calls in the wire
+// format of the provider under test.
+type stubServer struct {
+	*httptest.Server
+	path   string
+	header http.Header
+	body   map[string]any
+}
+
+func newStubServer(t *testing.T, reply string) *stubServer {
+	t.Helper()
+	s := &stubServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.path = r.URL.Path
+		s.header = r.Header.Clone()
+		s.body = nil
+		_ = json.NewDecoder(r.Body).Decode(&s.body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(reply))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+const openAIReply = `{
+  "id": "chatcmpl-1",
+  "object": "chat.completion",
+  "model": "test-model",
+  "choices": [{"index": 0, "message": {"role": "assistant", "content": "pong"}, "finish_reason": "stop"}],
+  "usage": {"prompt_tokens": 1, "completion_tokens": 1, "total_tokens": 2}
+}`
+
+const anthropicReply = `{
+  "id": "msg_1",
+  "type": "message",
+  "role": "assistant",
+  "model": "test-model",
+  "content": [{"type": "text", "text": "pong"}],
+  "stop_reason": "end_turn",
+  "usage": {"input_tokens": 1, "output_tokens": 1}
+}`
+
+func ping(t *testing.T, model llms.Model) string {
+	t.Helper()
+	resp, err := model.GenerateContent(context.Background(), []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, "ping"),
+	})
+	if err != nil {
+		t.Fatalf("GenerateContent: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		t.Fatalf("no choices")
+	}
+	return resp.Choices[0].Content
+}
+
+func TestNewLLM_OpenAICompatible(t *testing.T) {
+	srv := newStubServer(t, openAIReply)
+	t.Setenv("TEST_VLLM_KEY", "secret")
+
+	model, err := NewLLM(langchain.LLMConfig{
+		Provider:     "vllm",
+		Model:        "test-model",
+		Endpoint:     srv.URL + "/v1",
+		APIKeyEnv:    "TEST_VLLM_KEY",
+		Organization: "acme",
+		Headers:      map[string]string{"X-Gateway-Tenant": "tracing"},
+	})
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+
+	if got := ping(t, model); got != "pong" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	if srv.path != "/v1/chat/completions" {
+		t.Fatalf("unexpected path %s", srv.path)
+	}
+	if got := srv.header.Get("Authorization"); got != "Bearer secret" {
+		t.Fatalf("unexpected Authorization %q", got)
+	}
+	if got := srv.header.Get("OpenAI-Organization"); got != "acme" {
+		t.Fatalf("unexpected organization header %q", got)
+	}
+	if got := srv.header.Get("X-Gateway-Tenant"); got != "tracing" {
+		t.Fatalf("custom header not forwarded, got %q", got)
+	}
+	if srv.body["model"] != "test-model" {
+		t.Fatalf("unexpected model %v", srv.body["model"])
+	}
+}
+
+func TestNewLLM_LlamaCppWithoutKey(t *testing.T) {
+	srv := newStubServer(t, openAIReply)
+
+	model, err := NewLLM(langchain.LLMConfig{
+		Provider: "llamacpp",
+		Model:    "phi3",
+		Endpoint: srv.URL + "/v1",
+	})
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+
+	if got := ping(t, model); got != "pong" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	if got := srv.header.Get("Authorization"); !strings.HasPrefix(got, "Bearer ") {
+		t.Fatalf("expected placeholder bearer token, got %q", got)
+	}
+}
+
+func TestNewLLM_Anthropic(t *testing.T) {
+	srv := newStubServer(t, anthropicReply)
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-test")
+
+	model, err := NewLLM(langchain.LLMConfig{
+		Provider:  "anthropic",
+		Model:     "test-model",
+		Endpoint:  srv.URL + "/v1",
+		APIKeyEnv: "TEST_ANTHROPIC_KEY",
+	})
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+
+	if got := ping(t, model); got != "pong" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	if srv.path != "/v1/messages" {
+		t.Fatalf("unexpected path %s", srv.path)
+	}
+	if got := srv.header.Get("X-Api-Key"); got != "sk-test" {
+		t.Fatalf("unexpected x-api-key %q", got)
+	}
+}
+
+func TestNewLLM_RecordThenReplay(t *testing.T) {
+	srv := newStubServer(t, openAIReply)
+	dir := t.TempDir()
+
+	model, err := NewLLM(langchain.LLMConfig{
+		Provider: "llamacpp",
+		Endpoint: srv.URL + "/v1",
+		Replay:   langchain.ReplayConfig{Mode: "record", Dir: dir},
+	})
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+	if got := ping(t, model); got != "pong" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	srv.Close()
+
+	// Replay needs no server.
+	model, err = NewLLM(langchain.LLMConfig{
+		Provider: "llamacpp",
+		Replay:   langchain.ReplayConfig{Mode: "replay", Dir: dir},
+	})
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+	if got := ping(t, model); got != "pong" {
+		t.Fatalf("unexpected replayed reply %q", got)
+	}
+
+	// Fixtures are per provider and model.
+	model, err = NewLLM(langchain.LLMConfig{
+		Provider: "ollama",
+		Replay:   langchain.ReplayConfig{Mode: "replay", Dir: dir},
+	})
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+	if _, err := llms.GenerateFromSinglePrompt(context.Background(), model, "ping"); !errors.Is(err, replay.ErrNoFixture) {
+		t.Fatalf("another provider replayed the fixture: %v", err)
+	}
+}
+
+func TestNewLLM_ConfigErrors(t *testing.T) {
+	tests := []langchain.LLMConfig{
+		{Provider: "unknown"},
+		{Provider: "vllm", Model: "m"},
+		{Provider: "openai", Model: "m", APIKeyEnv: "TEST_UNSET_KEY_VAR"},
+		{Provider: "ollama", Replay: langchain.ReplayConfig{Mode: "replay"}},
+		{Provider: "ollama", Replay: langchain.ReplayConfig{Mode: "rewind", Dir: "testdata"}},
+	}
+
+	for _, cfg := range tests {
+		if _, err := NewLLM(cfg); err == nil {
+			t.Fatalf("%+v: expected error", cfg)
+		}
+	}
+}
+
+func TestNewExtractionLLM_ResponseFormat(t *testing.T) {
+	on := true
+	tasks := map[langchain.Task]langchain.GenerationConfig{langchain.TaskExtraction: {JSONSchema: &on}}
+	msgs := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "ping")}
+
+	t.Run("openai-compatible", func(t *testing.T) {
+		srv := newStubServer(t, openAIReply)
+		cfg := langchain.LLMConfig{Provider: "llamacpp", Model: "m", Endpoint: srv.URL + "/v1", Tasks: tasks}
+		model, err := NewExtractionLLM(cfg)
+		if err != nil {
+			t.Fatalf("NewExtractionLLM: %v", err)
+		}
+
+		if _, err := model.GenerateContent(context.Background(), msgs, llms.WithJSONMode()); err != nil {
+			t.Fatalf("GenerateContent: %v", err)
+		}
+		rf, _ := srv.body["response_format"].(map[string]any)
+		js, _ := rf["json_schema"].(map[string]any)
+		schema, _ := js["schema"].(map[string]any)
+		props, _ := schema["properties"].(map[string]any)
+		if rf["type"] != "json_schema" || props == nil {
+			t.Fatalf("expected the SearchIR schema in response_format, got %v", srv.body["response_format"])
+		}
+		if service, _ := props["service"].(map[string]any); service["type"] != "array" {
+			t.Fatalf("service must accept a list, got %v", props["service"])
+		}
+		if js["strict"] != false || schema["additionalProperties"] != false || len(schema["required"].([]any)) != len(props) {
+			t.Fatalf("every field must be required and no other allowed, got %v", schema)
+		}
+		if tags, _ := props["tags"].(map[string]any); tags["type"] != "object" || tags["additionalProperties"] != true {
+			t.Fatalf("tags must stay a free-form object, got %v", props["tags"])
+		}
+
+		// The main client, used for explanations, is left alone.
+		main, err := NewLLM(cfg)
+		if err != nil {
+			t.Fatalf("NewLLM: %v", err)
+		}
+		if _, err := main.GenerateContent(context.Background(), msgs); err != nil {
+			t.Fatalf("GenerateContent: %v", err)
+		}
+		if _, ok := srv.body["response_format"]; ok {
+			t.Fatalf("unexpected response_format %v", srv.body["response_format"])
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		srv := newStubServer(t, openAIReply)
+		strict := map[langchain.Task]langchain.GenerationConfig{langchain.TaskExtraction: {JSONSchema: &on, JSONSchemaStrict: &on}}
+		model, err := NewExtractionLLM(langchain.LLMConfig{Provider: "llamacpp", Model: "m", Endpoint: srv.URL + "/v1", Tasks: strict})
+		if err != nil {
+			t.Fatalf("NewExtractionLLM: %v", err)
+		}
+		if _, err := model.GenerateContent(context.Background(), msgs); err != nil {
+			t.Fatalf("GenerateContent: %v", err)
+		}
+
+		rf, _ := srv.body["response_format"].(map[string]any)
+		js, _ := rf["json_schema"].(map[string]any)
+		if js["strict"] != true {
+			t.Fatalf("expected strict response_format, got %v", rf)
+		}
+		schema, _ := js["schema"].(map[string]any)
+		checkStrict(t, "schema", schema)
+
+		tags := schema["properties"].(map[string]any)["tags"].(map[string]any)
+		if tags["type"] != "array" {
+			t.Fatalf("strict tags must be key/value pairs, got %v", tags)
+		}
+	})
+
+	t.Run("ollama", func(t *testing.T) {
+		srv := newStubServer(t, `{"model": "m", "message": {"role": "assistant", "content": "pong"}, "done": true}`)
+		model, err := NewExtractionLLM(langchain.LLMConfig{Provider: "ollama", Model: "m", Endpoint: srv.URL, Tasks: tasks})
+		if err != nil {
+			t.Fatalf("NewExtractionLLM: %v", err)
+		}
+
+		if _, err := model.GenerateContent(context.Background(), msgs); err != nil {
+			t.Fatalf("GenerateContent: %v", err)
+		}
+		if srv.path != "/api/chat" {
+			t.Fatalf("unexpected path %s", srv.path)
+		}
+		if srv.body["format"] != "json" {
+			t.Fatalf("expected format json, got %v", srv.body["format"])
+		}
+	})
+
+	t.Run("without json_schema", func(t *testing.T) {
+		srv := newStubServer(t, openAIReply)
+		model, err := NewExtractionLLM(langchain.LLMConfig{Provider: "llamacpp", Model: "m", Endpoint: srv.URL + "/v1"})
+		if err != nil {
+			t.Fatalf("NewExtractionLLM: %v", err)
+		}
+		if _, err := model.GenerateContent(context.Background(), msgs); err != nil {
+			t.Fatalf("GenerateContent: %v", err)
+		}
+		if _, ok := srv.body["response_format"]; ok {
+			t.Fatalf("unexpected response_format %v", srv.body["response_format"])
+		}
+	})
+}
+
+// checkStrict fails unless every object in schema requires all of its
+// properties and forbids others, as OpenAI strict mode demands.
+func checkStrict(t *testing.T, path string, schema map[string]any) {
+	t.Helper()
+	if items, ok := schema["items"].(map[string]any); ok {
+		checkStrict(t, path+".items", items)
+	}
+	if schema["type"] != "object" {
+		return
+	}
+	props, _ := schema["properties"].(map[string]any)
+	required, _ := schema["required"].([]any)
+	if schema["additionalProperties"] != false || len(props) == 0 || len(required) != len(props) {
+		t.Fatalf("%s is not a strict object: %v", path, schema)
+	}
+	for _, name := range required {
+		sub, ok := props[name.(string)].(map[string]any)
+		if !ok {
+			t.Fatalf("%s requires unknown property %v", path, name)
+		}
+		checkStrict(t, path+"."+name.(string), sub)
+	}
+}
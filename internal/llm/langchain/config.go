@@ -2,20 +2,139 @@ package langchain
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	LLM LLMConfig `yaml:"llm"`
+	LLM       LLMConfig       `yaml:"llm"`
+	Backend   BackendConfig   `yaml:"backend"`
+	Server    ServerConfig    `yaml:"server"`
+	Redaction RedactionConfig `yaml:"redaction"`
+	Logging   LoggingConfig   `yaml:"logging"`
+}
+
+// LoggingConfig configures the structured log written to stderr.
+type LoggingConfig struct {
+	// Level is debug, info (the default), warn or error.
+	Level string `yaml:"level"`
+
+	// Format is text (the default) or json.
+	Format string `yaml:"format"`
+
+	// Prompts logs every LLM prompt and reply, redacted, at debug level.
+	Prompts bool `yaml:"prompts"`
+}
+
+// RedactionConfig controls the pseudonymization of sensitive values in
+// prompts and logs.
+type RedactionConfig struct {
+	// Enabled defaults to true.
+	Enabled *bool `yaml:"enabled"`
+
+	// Detectors lists the built-in detectors to run (email, jwt, bearer,
+	// credit_card, ip, sql); empty runs all of them.
+	Detectors []string `yaml:"detectors"`
+
+	// Rules are extra detectors. A pattern with a capture group redacts
+	// only the group.
+	Rules []RedactionRule `yaml:"rules"`
+
+	// KeyEnv names the environment variable holding the pseudonym key, so
+	// pseudonyms stay the same across restarts. The variable must be set
+	// and non-empty; leaving KeyEnv out uses a random key.
+	KeyEnv string `yaml:"key_env"`
+}
+
+type RedactionRule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+// ServerConfig configures the HTTP API started with --serve.
+type ServerConfig struct {
+	// Addr is the listen address, e.g. :8080.
+	Addr string `yaml:"addr"`
+
+	// RequestTimeout bounds each request, LLM calls included.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	// ShutdownTimeout is how long in-flight requests may finish on exit.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// BackendConfig selects where traces are read from.
+type BackendConfig struct {
+	// Type is synthetic (the default) or jaeger.
+	Type string `yaml:"type"`
+
+	// TracesFile is the OTLP JSON file served by the synthetic backend.
+	TracesFile string `yaml:"traces_file"`
+
+	// Endpoint is the Jaeger query gRPC address, e.g. localhost:16685.
+	Endpoint string `yaml:"endpoint"`
+
+	// Lookback is the search window used when a query names no start time.
+	// 0 uses the reader's default of one hour.
+	Lookback time.Duration `yaml:"lookback"`
 }
 
 type LLMConfig struct {
-	Provider    string  `yaml:"provider"`
-	Model       string  `yaml:"model"`
-	Temperature float64 `yaml:"temperature"`
-	MaxTokens   int     `yaml:"max_tokens"`
-	Endpoint    string  `yaml:"endpoint"`
+	// Provider is one of ollama, openai, openai-compatible (alias vllm),
+	// llamacpp or anthropic.
+	Provider    string   `yaml:"provider"`
+	Model       string   `yaml:"model"`
+	Temperature float64  `yaml:"temperature"`
+	MaxTokens   int      `yaml:"max_tokens"`
+	TopP        *float64 `yaml:"top_p"`
+	Seed        *int     `yaml:"seed"`
+	// Endpoint is the server base URL, e.g. http://localhost:8000/v1 for an
+	// OpenAI-compatible server.
+	Endpoint string `yaml:"endpoint"`
+
+	// APIKeyEnv names the environment variable holding the API key. Keys are
+	// never read from the config file itself.
+	APIKeyEnv    string            `yaml:"api_key_env"`
+	Organization string            `yaml:"organization"`
+	Headers      map[string]string `yaml:"headers"`
+
+	// MaxExtractionAttempts bounds how often an invalid extraction is sent
+	// back to the model for repair, counting the first try. 0 uses the
+	// default.
+	MaxExtractionAttempts int `yaml:"max_extraction_attempts"`
+
+	// TraceContextTokens is the estimated token budget for the trace part of
+	// an explanation prompt; larger traces are pruned. 0 uses the default.
+	TraceContextTokens int `yaml:"trace_context_tokens"`
+
+	// ContextAttributes selects the attributes shown in explanation prompts.
+	ContextAttributes AttributeRules `yaml:"context_attributes"`
+
+	// Tasks overrides generation settings per task (extraction,
+	// trace_explanation, span_explanation).
+	Tasks map[Task]GenerationConfig `yaml:"tasks"`
+
+	// Replay records model replies to fixtures or answers from them.
+	Replay ReplayConfig `yaml:"replay"`
+}
+
+// ReplayConfig records every reply of the configured model to a fixture
+// file, or serves recorded replies without calling any model.
+type ReplayConfig struct {
+	// Mode is record or replay; empty calls the model directly.
+	Mode string `yaml:"mode"`
+
+	// Dir holds one JSON fixture per prompt.
+	Dir string `yaml:"dir"`
+}
+
+// AttributeRules are allow and deny lists of attribute rules: a key prefix,
+// "group:<semantic-convention group>" or "re:<regexp>". Deny wins; an empty
+// allow list keeps the built-in default.
+type AttributeRules struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
 }
 
 func Load(path string) (Config, error) {
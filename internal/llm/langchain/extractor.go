@@ -4,30 +4,56 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
-	"regexp"
+	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/prompts"
 
 	"github.com/jaeger-ai-assist-prototype/internal/ai"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/redact"
 )
 
 type SearchExtractor struct {
 	llm llms.Model
+	cfg LLMConfig
+
+	// ExtractionLLM serves extraction and repair calls, so a client built
+	// with a provider response format applies to them alone. Nil uses the
+	// model passed to NewSearchExtractor.
+	ExtractionLLM llms.Model
+
+	// Redactor pseudonymizes sensitive values in everything sent to the
+	// model or logged. Pseudonyms in extracted filters are restored. Nil
+	// sends text as is.
+	Redactor *redact.Redactor
+
+	// Logger records each LLM call with its duration; nil uses
+	// slog.Default().
+	Logger *slog.Logger
+	// LogPrompts also logs every prompt and reply, redacted, at debug level.
+	LogPrompts bool
 }
 
-func NewSearchExtractor(model llms.Model) *SearchExtractor {
-	return &SearchExtractor{llm: model}
+// NewSearchExtractor wraps model. cfg supplies the per-task generation
+// settings applied to every call.
+func NewSearchExtractor(model llms.Model, cfg LLMConfig) *SearchExtractor {
+	return &SearchExtractor{llm: model, cfg: cfg}
 }
 
 // ---------- COMMON PROMPT EXECUTOR (ONE PLACE) ----------
 
+// generateWithPrompt renders template with context and runs it. When onChunk
+// is set the reply is streamed through it as the model produces it; providers
+// that cannot stream deliver the whole reply as a single chunk.
 func (e *SearchExtractor) generateWithPrompt(
 	ctx context.Context,
+	task Task,
 	template string,
-	context string,
+	contextData string,
+	onChunk func(chunk string) error,
 ) (string, error) {
 
 	prompt := prompts.NewPromptTemplate(
@@ -36,45 +62,80 @@ func (e *SearchExtractor) generateWithPrompt(
 	)
 
 	rendered, err := prompt.Format(map[string]any{
-		"Context": context,
+		"Context": contextData,
 	})
 	if err != nil {
 		return "", err
 	}
 
-	msg := llms.MessageContent{
-		Role: llms.ChatMessageTypeHuman,
-		Parts: []llms.ContentPart{
-			llms.TextContent{Text: rendered},
-		},
+	opts := e.cfg.Generation(task).CallOptions()
+	streamed := false
+	if onChunk != nil {
+		opts = append(opts, llms.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+			streamed = true
+			return onChunk(string(chunk))
+		}))
 	}
 
-	resp, err := e.llm.GenerateContent(ctx, []llms.MessageContent{msg})
+	raw, err := e.generate(ctx, task, rendered, opts)
 	if err != nil {
 		return "", err
 	}
-
-	if len(resp.Choices) == 0 {
-		return "", errors.New("LLM returned no choices")
-	}
-
-	raw := strings.TrimSpace(resp.Choices[0].Content)
 	if raw == "" {
 		return "", errors.New("LLM returned empty response")
 	}
 
+	if onChunk != nil && !streamed {
+		if err := onChunk(raw); err != nil {
+			return "", err
+		}
+	}
+
 	return raw, nil
 }
 
-// ---------- JSON EXTRACTION HELPERS ----------
+// generate sends one rendered prompt and returns the trimmed reply. Every call
+// is logged with its duration; with LogPrompts the prompt and reply are too.
+func (e *SearchExtractor) generate(
+	ctx context.Context,
+	task Task,
+	rendered string,
+	opts []llms.CallOption,
+) (string, error) {
+	model := e.llm
+	if task == TaskExtraction && e.ExtractionLLM != nil {
+		model = e.ExtractionLLM
+	}
 
-func parseJSONBlock(input string) string {
-	re := regexp.MustCompile(`(?s)[\x60]{3}(?:json)?\s+(.*?)\s+[\x60]{3}`)
-	match := re.FindStringSubmatch(input)
-	if len(match) > 1 {
-		return strings.TrimSpace(match[1])
+	logger := logging.OrDefault(e.Logger).With("task", string(task))
+	if e.LogPrompts {
+		logger.DebugContext(ctx, "llm prompt", "prompt", rendered)
 	}
-	return strings.TrimSpace(input)
+
+	msg := llms.MessageContent{
+		Role: llms.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{
+			llms.TextContent{Text: rendered},
+		},
+	}
+
+	began := time.Now()
+	resp, err := model.GenerateContent(ctx, []llms.MessageContent{msg}, opts...)
+	if err == nil && len(resp.Choices) == 0 {
+		err = errors.New("LLM returned no choices")
+	}
+	elapsed := time.Since(began)
+	if err != nil {
+		logger.WarnContext(ctx, "llm call failed", "duration", elapsed, "error", err)
+		return "", err
+	}
+
+	raw := strings.TrimSpace(resp.Choices[0].Content)
+	logger.InfoContext(ctx, "llm call", "duration", elapsed, "prompt_chars", len(rendered), "reply_chars", len(raw))
+	if e.LogPrompts {
+		logger.DebugContext(ctx, "llm reply", "reply", e.Redactor.Redact(raw))
+	}
+	return raw, nil
 }
 
 // ---------- FEATURE 1: NATURAL LANGUAGE → IR ----------
@@ -84,66 +145,136 @@ func (e *SearchExtractor) ExtractSearchIR(
 	input string,
 ) (ai.SearchIR, error) {
 	prompt := prompts.NewPromptTemplate(
-		SearchExtractionPrompt,
+		e.cfg.ExtractionPrompt(),
 		[]string{"Input"},
 	)
 
 	rendered, err := prompt.Format(map[string]any{
-		"Input": input,
+		"Input": e.Redactor.RedactInput(input),
 	})
 	if err != nil {
 		return ai.SearchIR{}, err
 	}
 
-	msg := llms.MessageContent{
-		Role: llms.ChatMessageTypeHuman,
-		Parts: []llms.ContentPart{
-			llms.TextContent{Text: rendered},
-		},
-	}
+	return e.extract(ctx, rendered)
+}
+
+// RepairSearchIR re-asks the model after a rejected extraction, showing it
+// its previous output and the problem found.
+func (e *SearchExtractor) RepairSearchIR(
+	ctx context.Context,
+	input string,
+	previous string,
+	problem string,
+) (ai.SearchIR, error) {
+	prompt := prompts.NewPromptTemplate(
+		SearchRepairPrompt,
+		[]string{"Input", "Previous", "Problem"},
+	)
 
-	resp, err := e.llm.GenerateContent(ctx, []llms.MessageContent{msg})
+	rendered, err := prompt.Format(map[string]any{
+		"Input":    e.Redactor.RedactInput(input),
+		"Previous": e.Redactor.Redact(previous),
+		"Problem":  e.Redactor.Redact(problem),
+	})
 	if err != nil {
 		return ai.SearchIR{}, err
 	}
 
-	if len(resp.Choices) == 0 {
-		return ai.SearchIR{}, errors.New("LLM returned no choices")
-	}
+	return e.extract(ctx, rendered)
+}
 
-	raw := strings.TrimSpace(resp.Choices[0].Content)
-	if raw == "" {
-		return ai.SearchIR{}, errors.New("LLM returned empty response")
+// extract sends a rendered extraction prompt and decodes the reply. Output
+// that is not a usable IR is reported as *ai.ExtractionError so callers can
+// ask for a repair.
+func (e *SearchExtractor) extract(ctx context.Context, rendered string) (ai.SearchIR, error) {
+	gen := e.cfg.Generation(TaskExtraction)
+	if gen.UsesSchema() {
+		// Providers without a native schema option still see it.
+		if b, err := json.Marshal(SearchIRSchema()); err == nil {
+			rendered += "\nRespond with a single JSON object matching this schema:\n" + string(b) + "\n"
+		}
 	}
 
-	cleanedJSON := parseJSONBlock(raw)
-	if cleanedJSON == "" {
-		return ai.SearchIR{}, errors.New("LLM returned empty or malformed response")
+	raw, err := e.generate(ctx, TaskExtraction, rendered, gen.CallOptions())
+	if err != nil {
+		return ai.SearchIR{}, err
+	}
+	if raw == "" {
+		return ai.SearchIR{}, &ai.ExtractionError{Err: errors.New("LLM returned empty response")}
 	}
-	log.Println(cleanedJSON)
 
 	var ir ai.SearchIR
-	if err := json.Unmarshal([]byte(cleanedJSON), &ir); err != nil {
-		return ai.SearchIR{}, errors.New("LLM output is not valid JSON")
+	if err := decodeObject(raw, &ir); err != nil {
+		return ai.SearchIR{}, &ai.ExtractionError{Output: raw, Err: err}
 	}
 
+	e.restore(&ir)
 	return ir, nil
 }
 
+// restore puts the values the question was redacted of back into every
+// string of the filters, so a search for a pseudonymized email matches the
+// real one.
+func (e *SearchExtractor) restore(ir *ai.SearchIR) {
+	if e.Redactor == nil {
+		return
+	}
+	for _, p := range []*string{ir.Service, ir.Operation, ir.MinDurationMs, ir.MaxDurationMs, ir.StartTime, ir.EndTime} {
+		if p != nil {
+			*p = e.Redactor.Restore(*p)
+		}
+	}
+	for _, list := range [][]string{ir.Services, ir.Operations} {
+		for i := range list {
+			list[i] = e.Redactor.Restore(list[i])
+		}
+	}
+	if ir.Tags != nil {
+		tags := make(map[string]string, len(ir.Tags))
+		for k, v := range ir.Tags {
+			tags[e.Redactor.Restore(k)] = e.Redactor.Restore(v)
+		}
+		ir.Tags = tags
+	}
+}
+
 // ---------- FEATURE 2 & 3: EXPLAIN ----------
 
 func (e *SearchExtractor) ExplainTrace(
 	ctx context.Context,
 	context string,
 ) (string, error) {
-	log.Println(context)
-	return e.generateWithPrompt(ctx, TraceExplainPrompt, context)
+	context = e.Redactor.Redact(context)
+	return e.generateWithPrompt(ctx, TaskTraceExplanation, TraceExplainPrompt, context, nil)
 }
 
 func (e *SearchExtractor) ExplainSpan(
 	ctx context.Context,
 	context string,
 ) (string, error) {
-	log.Println(context)
-	return e.generateWithPrompt(ctx, SpanExplainPrompt, context)
+	context = e.Redactor.Redact(context)
+	return e.generateWithPrompt(ctx, TaskSpanExplanation, SpanExplainPrompt, context, nil)
+}
+
+// ExplainTraceStream is ExplainTrace with the explanation passed to onChunk
+// as it is generated. An error from onChunk stops generation.
+func (e *SearchExtractor) ExplainTraceStream(
+	ctx context.Context,
+	context string,
+	onChunk func(chunk string) error,
+) (string, error) {
+	context = e.Redactor.Redact(context)
+	return e.generateWithPrompt(ctx, TaskTraceExplanation, TraceExplainPrompt, context, onChunk)
+}
+
+// ExplainSpanStream is ExplainSpan with the explanation passed to onChunk as
+// it is generated. An error from onChunk stops generation.
+func (e *SearchExtractor) ExplainSpanStream(
+	ctx context.Context,
+	context string,
+	onChunk func(chunk string) error,
+) (string, error) {
+	context = e.Redactor.Redact(context)
+	return e.generateWithPrompt(ctx, TaskSpanExplanation, SpanExplainPrompt, context, onChunk)
 }
@@ -0,0 +1,208 @@
+package langchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+
+	"github.com/jaeger-ai-assist-prototype/internal/ai"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/redact"
+)
+
+func TestSearchExtractor_UnparsableOutputIsExtractionError(t *testing.T) {
+	model := &recordingModel{reply: "I am not sure what you mean."}
+	e := NewSearchExtractor(model, LLMConfig{})
+
+	_, err := e.ExtractSearchIR(context.Background(), "hmm")
+
+	var xe *ai.ExtractionError
+	if !errors.As(err, &xe) {
+		t.Fatalf("expected *ai.ExtractionError, got %v", err)
+	}
+	if xe.Output != model.reply {
+		t.Fatalf("ExtractionError must keep the raw output, got %q", xe.Output)
+	}
+}
+
+func TestSearchExtractor_RepairSearchIR(t *testing.T) {
+	model := &recordingModel{reply: `{"min_duration_ms": "2s"}`}
+	e := NewSearchExtractor(model, LLMConfig{})
+
+	ir, err := e.RepairSearchIR(context.Background(),
+		"slower than 2 seconds",
+		`{"min_duration_ms": "2 seconds"}`,
+		"min_duration_ms must be a valid duration string (e.g. '300ms', '1.5s')")
+	if err != nil {
+		t.Fatalf("RepairSearchIR: %v", err)
+	}
+	if ir.MinDurationMs == nil || *ir.MinDurationMs != "2s" {
+		t.Fatalf("unexpected IR %+v", ir)
+	}
+
+	for _, want := range []string{"slower than 2 seconds", `"2 seconds"`, "must be a valid duration string"} {
+		if !strings.Contains(model.prompts[0], want) {
+			t.Fatalf("repair prompt missing %q:\n%s", want, model.prompts[0])
+		}
+	}
+}
+
+// streamingModel delivers reply through the streaming callback one word at a
+// time, as providers do while tokens are generated.
+type streamingModel struct {
+	reply string
+}
+
+func (m *streamingModel) GenerateContent(ctx context.Context, msgs []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	var o llms.CallOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.StreamingFunc != nil {
+		for _, word := range strings.SplitAfter(m.reply, " ") {
+			if err := o.StreamingFunc(ctx, []byte(word)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: m.reply}}}, nil
+}
+
+func (m *streamingModel) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, opts...)
+}
+
+func TestSearchExtractor_ExplainTraceStream(t *testing.T) {
+	e := NewSearchExtractor(&streamingModel{reply: "the payment call failed"}, LLMConfig{})
+
+	var chunks []string
+	out, err := e.ExplainTraceStream(context.Background(), "ctx", func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chunks) != 4 || strings.Join(chunks, "") != out || out != "the payment call failed" {
+		t.Fatalf("got chunks %q and output %q", chunks, out)
+	}
+}
+
+func TestSearchExtractor_ExplainSpanStream_NonStreamingModel(t *testing.T) {
+	e := NewSearchExtractor(&recordingModel{reply: "slow query"}, LLMConfig{})
+
+	var chunks []string
+	out, err := e.ExplainSpanStream(context.Background(), "ctx", func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil || out != "slow query" || len(chunks) != 1 || chunks[0] != out {
+		t.Fatalf("got chunks %q, output %q, err %v", chunks, out, err)
+	}
+}
+
+func TestSearchExtractor_ExplainTraceStream_AbortedByCallback(t *testing.T) {
+	e := NewSearchExtractor(&streamingModel{reply: "a b c d"}, LLMConfig{})
+
+	stop := errors.New("client went away")
+	calls := 0
+	_, err := e.ExplainTraceStream(context.Background(), "ctx", func(string) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("expected generation to stop after the first chunk, got %d calls, err %v", calls, err)
+	}
+}
+
+func TestSearchExtractor_RedactionOfQuestionAndFilters(t *testing.T) {
+	r, err := redact.New(redact.Options{Key: []byte("k")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pseudo := r.Redact("bob@example.com")
+
+	model := &recordingModel{reply: `{"start_time": "` + pseudo + `", "min_duration_ms": "` + pseudo + `", "tags": {"` + pseudo + `": "x"}}`}
+	e := NewSearchExtractor(model, LLMConfig{})
+	e.Redactor = r
+
+	ir, err := e.ExtractSearchIR(context.Background(), "select traces from checkout slower than 2s")
+	if err != nil {
+		t.Fatalf("ExtractSearchIR: %v", err)
+	}
+	if !strings.Contains(model.prompts[0], "select traces from checkout slower than 2s") {
+		t.Fatalf("the question must not go through the SQL detector:\n%s", model.prompts[0])
+	}
+	if *ir.StartTime != "bob@example.com" || *ir.MinDurationMs != "bob@example.com" || ir.Tags["bob@example.com"] != "x" {
+		t.Fatalf("every filter string must be restored, got %+v", ir)
+	}
+}
+
+func TestSearchExtractor_Redaction(t *testing.T) {
+	r, err := redact.New(redact.Options{Key: []byte("k")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pseudo := r.Redact("bob@example.com")
+
+	model := &recordingModel{reply: `{"service": "checkout", "tags": {"user.email": "` + pseudo + `"}}`}
+	e := NewSearchExtractor(model, LLMConfig{})
+	e.Redactor = r
+
+	ir, err := e.ExtractSearchIR(context.Background(), "checkout errors for bob@example.com")
+	if err != nil {
+		t.Fatalf("ExtractSearchIR: %v", err)
+	}
+	if strings.Contains(model.prompts[0], "bob@example.com") || !strings.Contains(model.prompts[0], pseudo) {
+		t.Fatalf("extraction prompt was not redacted:\n%s", model.prompts[0])
+	}
+	if ir.Tags["user.email"] != "bob@example.com" {
+		t.Fatalf("pseudonym in the filters must be restored, got %+v", ir.Tags)
+	}
+
+	model.reply = "The request failed."
+	if _, err := e.ExplainTrace(context.Background(), "Tag: client.address = 10.1.2.3"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(model.prompts[1], "10.1.2.3") {
+		t.Fatalf("explanation prompt was not redacted:\n%s", model.prompts[1])
+	}
+}
+
+func TestSearchExtractor_Logging(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, slog.LevelDebug, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := &recordingModel{reply: "All good."}
+	e := NewSearchExtractor(model, LLMConfig{})
+	e.Logger = logger
+
+	ctx := logging.WithRequestID(context.Background(), "r1")
+	if _, err := e.ExplainSpan(ctx, "span context here"); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, `msg="llm call" task=span_explanation duration=`) || !strings.Contains(out, "request_id=r1") {
+		t.Fatalf("expected a timed llm call line, got:\n%s", out)
+	}
+	if strings.Contains(out, "span context here") {
+		t.Fatalf("prompts must only be logged with LogPrompts:\n%s", out)
+	}
+
+	buf.Reset()
+	e.LogPrompts = true
+	if _, err := e.ExplainSpan(ctx, "span context here"); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, `msg="llm prompt"`) || !strings.Contains(out, "span context here") ||
+		!strings.Contains(out, `msg="llm reply"`) {
+		t.Fatalf("expected prompt and reply lines, got:\n%s", out)
+	}
+}
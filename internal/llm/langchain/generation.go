@@ -0,0 +1,116 @@
+package langchain
+
+import "github.com/tmc/langchaingo/llms"
+
+// Task identifies one kind of LLM call so each can have its own generation
+// settings.
+type Task string
+
+const (
+	TaskExtraction       Task = "extraction"
+	TaskTraceExplanation Task = "trace_explanation"
+	TaskSpanExplanation  Task = "span_explanation"
+)
+
+// GenerationConfig holds per-call generation settings. Unset (nil) fields
+// inherit from the top-level llm section.
+type GenerationConfig struct {
+	Temperature *float64 `yaml:"temperature"`
+	MaxTokens   *int     `yaml:"max_tokens"`
+	TopP        *float64 `yaml:"top_p"`
+	Seed        *int     `yaml:"seed"`
+	StopWords   []string `yaml:"stop"`
+	JSONMode    *bool    `yaml:"json_mode"`
+	// JSONSchema constrains output to the task's schema where the provider
+	// supports it (OpenAI response_format) and asks for JSON only otherwise.
+	// Implies JSONMode.
+	JSONSchema *bool `yaml:"json_schema"`
+	// JSONSchemaStrict sets strict on the OpenAI response_format, so the
+	// provider enforces the schema exactly. Only used with JSONSchema.
+	JSONSchemaStrict *bool `yaml:"json_schema_strict"`
+}
+
+func (g GenerationConfig) jsonMode() bool {
+	return g.JSONMode != nil && *g.JSONMode || g.UsesSchema()
+}
+
+// UsesSchema reports whether output is constrained to the task's JSON schema.
+func (g GenerationConfig) UsesSchema() bool {
+	return g.JSONSchema != nil && *g.JSONSchema
+}
+
+// StrictSchema reports whether the schema is to be enforced strictly.
+func (g GenerationConfig) StrictSchema() bool {
+	return g.UsesSchema() && g.JSONSchemaStrict != nil && *g.JSONSchemaStrict
+}
+
+// Generation returns the settings for task: the task's own section layered
+// over the top-level temperature, max_tokens, top_p and seed.
+func (c LLMConfig) Generation(task Task) GenerationConfig {
+	g := GenerationConfig{
+		Temperature: &c.Temperature,
+	}
+	if c.MaxTokens > 0 {
+		g.MaxTokens = &c.MaxTokens
+	}
+	if c.TopP != nil {
+		g.TopP = c.TopP
+	}
+	if c.Seed != nil {
+		g.Seed = c.Seed
+	}
+
+	t, ok := c.Tasks[task]
+	if !ok {
+		return g
+	}
+	if t.Temperature != nil {
+		g.Temperature = t.Temperature
+	}
+	if t.MaxTokens != nil {
+		g.MaxTokens = t.MaxTokens
+	}
+	if t.TopP != nil {
+		g.TopP = t.TopP
+	}
+	if t.Seed != nil {
+		g.Seed = t.Seed
+	}
+	if t.StopWords != nil {
+		g.StopWords = t.StopWords
+	}
+	if t.JSONMode != nil {
+		g.JSONMode = t.JSONMode
+	}
+	if t.JSONSchema != nil {
+		g.JSONSchema = t.JSONSchema
+	}
+	if t.JSONSchemaStrict != nil {
+		g.JSONSchemaStrict = t.JSONSchemaStrict
+	}
+	return g
+}
+
+// CallOptions converts g into langchaingo call options.
+func (g GenerationConfig) CallOptions() []llms.CallOption {
+	var opts []llms.CallOption
+	if g.Temperature != nil {
+		opts = append(opts, llms.WithTemperature(*g.Temperature))
+	}
+	if g.MaxTokens != nil && *g.MaxTokens > 0 {
+		opts = append(opts, llms.WithMaxTokens(*g.MaxTokens))
+	}
+	if g.TopP != nil {
+		opts = append(opts, llms.WithTopP(*g.TopP))
+	}
+	if g.Seed != nil {
+		opts = append(opts, llms.WithSeed(*g.Seed))
+	}
+	if len(g.StopWords) > 0 {
+		opts = append(opts, llms.WithStopWords(g.StopWords))
+	}
+	if g.jsonMode() {
+		opts = append(opts, llms.WithJSONMode())
+	}
+	return opts
+}
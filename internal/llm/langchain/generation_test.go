@@ -0,0 +1,122 @@
+package langchain
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// recordingModel answers every call with reply and keeps, per call, the
+// prompt and the resolved call options.
+type recordingModel struct {
+	reply   string
+	prompts []string
+	calls   []llms.CallOptions
+}
+
+func (m *recordingModel) GenerateContent(ctx context.Context, msgs []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.prompts = append(m.prompts, msgs[0].Parts[0].(llms.TextContent).Text)
+	var o llms.CallOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m.calls = append(m.calls, o)
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: m.reply}}}, nil
+}
+
+func (m *recordingModel) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, opts...)
+}
+
+const tasksYAML = `
+llm:
+  provider: ollama
+  model: phi3:mini
+  temperature: 0.1
+  max_tokens: 256
+  seed: 7
+  tasks:
+    extraction:
+      temperature: 0
+      json_mode: true
+      stop: ["</json>"]
+    trace_explanation:
+      max_tokens: 512
+`
+
+func loadTestConfig(t *testing.T) Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(tasksYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	return cfg
+}
+
+func resolve(g GenerationConfig) llms.CallOptions {
+	var o llms.CallOptions
+	for _, opt := range g.CallOptions() {
+		opt(&o)
+	}
+	return o
+}
+
+func TestLLMConfig_Generation(t *testing.T) {
+	cfg := loadTestConfig(t).LLM
+
+	ext := resolve(cfg.Generation(TaskExtraction))
+	if ext.Temperature != 0 || ext.MaxTokens != 256 || ext.Seed != 7 || !ext.JSONMode {
+		t.Fatalf("unexpected extraction options %+v", ext)
+	}
+	if len(ext.StopWords) != 1 || ext.StopWords[0] != "</json>" {
+		t.Fatalf("unexpected stop words %v", ext.StopWords)
+	}
+
+	trace := resolve(cfg.Generation(TaskTraceExplanation))
+	if trace.Temperature != 0.1 || trace.MaxTokens != 512 || trace.JSONMode {
+		t.Fatalf("unexpected trace explanation options %+v", trace)
+	}
+
+	// No span_explanation section: top-level values apply.
+	span := resolve(cfg.Generation(TaskSpanExplanation))
+	if span.Temperature != 0.1 || span.MaxTokens != 256 || span.Seed != 7 {
+		t.Fatalf("unexpected span explanation options %+v", span)
+	}
+}
+
+func TestSearchExtractor_AppliesTaskOptions(t *testing.T) {
+	cfg := loadTestConfig(t).LLM
+	model := &recordingModel{reply: `{"service": "payments"}`}
+	e := NewSearchExtractor(model, cfg)
+	ctx := context.Background()
+
+	if _, err := e.ExtractSearchIR(ctx, "errors in payments"); err != nil {
+		t.Fatalf("ExtractSearchIR: %v", err)
+	}
+	if _, err := e.ExplainTrace(ctx, "trace"); err != nil {
+		t.Fatalf("ExplainTrace: %v", err)
+	}
+	if _, err := e.ExplainSpan(ctx, "span"); err != nil {
+		t.Fatalf("ExplainSpan: %v", err)
+	}
+
+	if len(model.calls) != 3 {
+		t.Fatalf("expected 3 calls, got %d", len(model.calls))
+	}
+	if !model.calls[0].JSONMode || model.calls[0].Temperature != 0 {
+		t.Fatalf("extraction call missing task options: %+v", model.calls[0])
+	}
+	if model.calls[1].MaxTokens != 512 {
+		t.Fatalf("trace explanation call missing max_tokens: %+v", model.calls[1])
+	}
+	if model.calls[2].MaxTokens != 256 || model.calls[2].Temperature != 0.1 {
+		t.Fatalf("span explanation call missing defaults: %+v", model.calls[2])
+	}
+}
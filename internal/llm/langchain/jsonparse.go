@@ -0,0 +1,256 @@
+package langchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// decodeObject finds JSON objects in free-form LLM output and decodes the
+// answer into v. It tolerates code fences, surrounding prose, // and /* */
+// comments, single-quoted strings, trailing commas and Python-style
+// None/True/False.
+//
+// Only objects using at least one of v's JSON field names, or empty ones,
+// are answers; a stray {"error": true} in the prose would otherwise decode
+// as an empty filter. The first answer after the last "Output:" wins, else
+// the last answer in the text, since examples and reasoning come first.
+func decodeObject(raw string, v any) error {
+	candidates := objectCandidates(raw)
+	if len(candidates) == 0 {
+		return errors.New("LLM output contains no JSON object")
+	}
+
+	fields := jsonFields(v)
+	output := strings.LastIndex(raw, "Output:")
+	var answer *candidate
+	var firstErr error
+	for i := range candidates {
+		c := &candidates[i]
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(c.normalized), &keys); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !usesFields(keys, fields) {
+			continue
+		}
+		if err := json.Unmarshal([]byte(c.normalized), reflect.New(reflect.TypeOf(v).Elem()).Interface()); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if answer != nil && output >= 0 && answer.start > output {
+			continue
+		}
+		answer = c
+	}
+
+	if answer == nil {
+		if firstErr != nil {
+			return fmt.Errorf("LLM output is not valid JSON: %w", firstErr)
+		}
+		return errors.New("LLM output contains no JSON object with the expected fields")
+	}
+	return json.Unmarshal([]byte(answer.normalized), v)
+}
+
+// candidate is a balanced {...} span of LLM output, at byte offset start.
+type candidate struct {
+	start      int
+	normalized string
+}
+
+// jsonFields returns the JSON names of the fields of the struct v points to.
+func jsonFields(v any) map[string]bool {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	fields := map[string]bool{}
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// usesFields reports whether obj is empty or has a key in fields.
+func usesFields(obj map[string]json.RawMessage, fields map[string]bool) bool {
+	if len(obj) == 0 {
+		return true
+	}
+	for k := range obj {
+		if fields[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// objectCandidates returns every balanced top-level {...} span in s, in
+// order and normalized to strict JSON. Braces inside strings and comments
+// are ignored; an object left open at the end (truncated output) is
+// dropped.
+func objectCandidates(s string) []candidate {
+	var out []candidate
+	depth, start := 0, -1
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case depth > 0 && (c == '"' || c == '\''):
+			i = skipString(s, i)
+		case depth > 0 && c == '/' && i+1 < len(s) && (s[i+1] == '/' || s[i+1] == '*'):
+			i = skipComment(s, i)
+		case c == '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+			if depth == 0 {
+				out = append(out, candidate{start: start, normalized: normalizeJSON(s[start : i+1])})
+			}
+		}
+	}
+	return out
+}
+
+// skipString returns the index of the quote closing the string opened at i.
+func skipString(s string, i int) int {
+	q := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case q:
+			return j
+		}
+	}
+	return len(s) - 1
+}
+
+// skipComment returns the index of the last byte of the comment at i.
+func skipComment(s string, i int) int {
+	if s[i+1] == '/' {
+		if n := strings.IndexByte(s[i:], '\n'); n >= 0 {
+			return i + n
+		}
+		return len(s) - 1
+	}
+	if n := strings.Index(s[i+2:], "*/"); n >= 0 {
+		return i + 2 + n + 1
+	}
+	return len(s) - 1
+}
+
+var pythonLiterals = map[string]string{
+	"None":  "null",
+	"True":  "true",
+	"False": "false",
+}
+
+// normalizeJSON rewrites the relaxed syntax accepted by decodeObject
+// into strict JSON.
+func normalizeJSON(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'':
+			i = writeString(&b, s, i)
+		case c == '/' && i+1 < len(s) && (s[i+1] == '/' || s[i+1] == '*'):
+			i = skipComment(s, i)
+		case isIdentStart(c):
+			j := i
+			for j < len(s) && isIdentStart(s[j]) {
+				j++
+			}
+			word := s[i:j]
+			if lit, ok := pythonLiterals[word]; ok {
+				word = lit
+			}
+			b.WriteString(word)
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return dropTrailingCommas(b.String())
+}
+
+// writeString copies the string opened at i to b as a double-quoted JSON
+// string and returns the index of its closing quote.
+func writeString(b *strings.Builder, s string, i int) int {
+	q := s[i]
+	b.WriteByte('"')
+	j := i + 1
+	for ; j < len(s); j++ {
+		c := s[j]
+		switch {
+		case c == '\\' && j+1 < len(s):
+			if s[j+1] == '\'' {
+				// \' is not a JSON escape.
+				b.WriteByte('\'')
+			} else {
+				b.WriteByte(c)
+				b.WriteByte(s[j+1])
+			}
+			j++
+		case c == q:
+			b.WriteByte('"')
+			return j
+		case c == '"':
+			b.WriteString(`\"`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return j
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// dropTrailingCommas removes commas directly before } or ] in strict-quoted
+// JSON.
+func dropTrailingCommas(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			j := skipString(s, i)
+			b.WriteString(s[i : j+1])
+			i = j
+			continue
+		}
+		if c == ',' {
+			j := i + 1
+			for j < len(s) && strings.IndexByte(" \t\r\n", s[j]) >= 0 {
+				j++
+			}
+			if j < len(s) && (s[j] == '}' || s[j] == ']') {
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
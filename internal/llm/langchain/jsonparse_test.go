@@ -0,0 +1,145 @@
+package langchain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaeger-ai-assist-prototype/internal/ai"
+)
+
+func TestDecodeObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		service string
+		minDur  string
+	}{
+		{"plain", `{"service": "payments", "min_duration_ms": "2s"}`, "payments", "2s"},
+		{"code fence", "```json\n{\"service\": \"payments\"}\n```", "payments", ""},
+		{"leading and trailing prose",
+			`Explanation: "payments" is the service.
+Output: {"service": "payments", "min_duration_ms": "2s"} Hope this helps!`, "payments", "2s"},
+		{"single quotes", `{'service': 'payments', 'min_duration_ms': '500ms'}`, "payments", "500ms"},
+		{"apostrophe inside double quotes", `{"service": "bob's-svc"}`, "bob's-svc", ""},
+		{"double quote inside single quotes", `{'service': 'say "hi"'}`, `say "hi"`, ""},
+		{"line and block comments",
+			`{
+  // the service
+  "service": "payments", /* inline */
+  "min_duration_ms": "2s" // lower bound
+}`, "payments", "2s"},
+		{"url in string is not a comment", `{"service": "http://payments"}`, "http://payments", ""},
+		{"trailing commas", `{"service": "payments", "tags": {"error": "true",},}`, "payments", ""},
+		{"python literals", `{"service": None, "operation": None, "min_duration_ms": "2s"}`, "", "2s"},
+		{"multiple objects take the last valid",
+			`{"service": "payments"} {"service": "orders"} {"service": 42}`, "orders", ""},
+		{"decoy object in the prose",
+			`Explanation: the question asks for failures, i.e. {"error": true}, in payments.
+{"service": "payments", "min_duration_ms": "2s"}`, "payments", "2s"},
+		{"example before the output",
+			`Explanation: like the example {"service": "orders"}, slower than 2s.
+Output: {"service": "payments", "min_duration_ms": "2s"}
+Note: other filters such as {"service": null} were left out.`, "payments", "2s"},
+		{"empty object", `Output: {}`, "", ""},
+		{"braces inside strings", `{"service": "pay{ments}", "tags": {}}`, "pay{ments}", ""},
+		{"nested tags", `{"service": "a", "tags": {"http.status_code": "500"}}`, "a", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ir ai.SearchIR
+			if err := decodeObject(tt.raw, &ir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := ""
+			if ir.Service != nil {
+				got = *ir.Service
+			}
+			if got != tt.service {
+				t.Fatalf("service: got %q, want %q", got, tt.service)
+			}
+			gotDur := ""
+			if ir.MinDurationMs != nil {
+				gotDur = *ir.MinDurationMs
+			}
+			if gotDur != tt.minDur {
+				t.Fatalf("min_duration_ms: got %q, want %q", gotDur, tt.minDur)
+			}
+		})
+	}
+}
+
+func TestDecodeObject_Failures(t *testing.T) {
+	for _, raw := range []string{
+		"I could not understand the question.",
+		`{"service": "payments"`,
+		`{"service": 42}`,
+		`The trace shows {"error": true} on the payment span.`,
+	} {
+		var ir ai.SearchIR
+		if err := decodeObject(raw, &ir); err == nil {
+			t.Fatalf("%q: expected error", raw)
+		}
+	}
+}
+
+func TestSearchIRSchema(t *testing.T) {
+	schema := SearchIRSchema()
+	props := schema["properties"].(map[string]any)
+
+	for _, name := range []string{"service", "operation", "min_duration_ms", "max_duration_ms", "start_time", "end_time", "tags"} {
+		if _, ok := props[name]; !ok {
+			t.Fatalf("schema missing %q", name)
+		}
+	}
+	for _, name := range []string{"services", "operations"} {
+		if _, ok := props[name]; ok {
+			t.Fatalf("internal field %q must not be in the schema", name)
+		}
+	}
+
+	if _, ok := props["service"].(map[string]any)["anyOf"]; !ok {
+		t.Fatalf("service must accept a string or a list")
+	}
+	if typ := props["start_time"].(map[string]any)["type"]; len(typ.([]any)) != 2 {
+		t.Fatalf("start_time must be nullable, got %v", typ)
+	}
+}
+
+func TestSearchExtractor_JSONSchemaMode(t *testing.T) {
+	on := true
+	main := &recordingModel{reply: "The trace is fine."}
+	model := &recordingModel{reply: "Sure! {'service': 'payments',}"}
+	e := NewSearchExtractor(main, LLMConfig{
+		Tasks: map[Task]GenerationConfig{TaskExtraction: {JSONSchema: &on}},
+	})
+	e.ExtractionLLM = model
+
+	ir, err := e.ExtractSearchIR(t.Context(), "errors in payments")
+	if err != nil {
+		t.Fatalf("ExtractSearchIR: %v", err)
+	}
+	if ir.Service == nil || *ir.Service != "payments" {
+		t.Fatalf("unexpected IR %+v", ir)
+	}
+	if !model.calls[0].JSONMode {
+		t.Fatalf("json_schema must imply JSON mode")
+	}
+	prompt := model.prompts[0]
+	if strings.Contains(prompt, "Explanation") {
+		t.Fatalf("a JSON-only prompt must not ask for an explanation:\n%s", prompt)
+	}
+	if !strings.Contains(prompt, `"additionalProperties":false`) {
+		t.Fatalf("schema missing from the prompt:\n%s", prompt)
+	}
+
+	if _, err := e.ExplainTrace(t.Context(), "trace"); err != nil {
+		t.Fatalf("ExplainTrace: %v", err)
+	}
+	if len(main.calls) != 1 || len(model.calls) != 1 {
+		t.Fatalf("explanations must use the main model: %d main, %d extraction calls", len(main.calls), len(model.calls))
+	}
+	if main.calls[0].JSONMode {
+		t.Fatalf("explanations must not run in JSON mode")
+	}
+}
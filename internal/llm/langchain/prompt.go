@@ -1,8 +1,11 @@
 package langchain
 
+import "strings"
+
 const SearchExtractionPrompt = `
 Extract trace filters into JSON. Use the "Explanation" to reason before outputting JSON.
 Rule: Do NOT convert units (s, ms, m). Extract durations and times exactly as written.
+Rule: "service" and "operation" are a string, or a list of strings when several are named.
 
 <Examples>
 # 1. Latency Bounds
@@ -46,7 +49,16 @@ Input: "500 errors in payments"
 Explanation: "500" is a status code; "errors" triggers error:true; "payments" is the service.
 Output: {"service": "payments", "operation": null, "tags": {"http.status_code": "500", "error": "true"}}
 
-# 6. Complex Master Example
+# 6. Several Services or Operations
+Input: "500 errors in payments and orders"
+Explanation: two services are named; "service" becomes a list with both.
+Output: {"service": ["payments", "orders"], "operation": null, "tags": {"http.status_code": "500", "error": "true"}}
+
+Input: "Login or Logout calls in auth-api"
+Explanation: two operations of one service; "operation" becomes a list.
+Output: {"service": "auth-api", "operation": ["Login", "Logout"], "tags": {}}
+
+# 7. Complex Master Example
 Input: "Show me 500 errors in orders-api for GetCart > 1.5s from 2 hours ago till 1h ago"
 Explanation: "500" is status code; "orders-api" is service; "GetCart" is operation; "> 1.5s" is min_duration_ms; "2h ago" is start; "1h ago" is end.
 Output: {
@@ -76,6 +88,50 @@ User Input: {{.Input}}
 </Task>
 `
 
+// SearchExtractionJSONPrompt is SearchExtractionPrompt for output constrained
+// to JSON (json_schema): the examples keep their outputs but drop the
+// "Explanation" lines the model has no room to write.
+var SearchExtractionJSONPrompt = jsonOnly(SearchExtractionPrompt)
+
+// ExtractionPrompt returns the extraction template for c: the JSON-only one
+// when the extraction task uses a schema.
+func (c LLMConfig) ExtractionPrompt() string {
+	if c.Generation(TaskExtraction).UsesSchema() {
+		return SearchExtractionJSONPrompt
+	}
+	return SearchExtractionPrompt
+}
+
+func jsonOnly(prompt string) string {
+	prompt = strings.Replace(prompt,
+		`Use the "Explanation" to reason before outputting JSON.`,
+		`Output only the JSON object.`, 1)
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(prompt, "\n") {
+		if !strings.HasPrefix(line, "Explanation:") {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
+
+const SearchRepairPrompt = `
+Your previous answer for this trace search request was rejected.
+
+User Input: {{.Input}}
+
+Previous answer:
+{{.Previous}}
+
+Problem: {{.Problem}}
+
+Return the corrected filters as ONE JSON object with the keys "service",
+"operation", "min_duration_ms", "max_duration_ms", "start_time", "end_time"
+and "tags". Keep everything that was correct, fix only the problem, and use
+null for anything the input does not mention. Durations are strings with a
+unit such as "300ms" or "1.5s". Output only the JSON object.
+`
+
 const TraceExplainPrompt = `
 You are a distributed tracing assistant.
 
@@ -89,6 +145,8 @@ Rules:
 - Do NOT hallucinate details that are not present in the summary.
 - If the information is insufficient to draw conclusions, say: "Insufficient data".
 - If there is no error, explicitly state: "No clear error observed."
+- When root cause candidates are listed, name the top one as the origin unless the spans contradict it.
+  A span that only propagates the failure of a span it calls is not the origin.
 
 Trace Context:
 {{.Context}}
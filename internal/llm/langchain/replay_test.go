@@ -0,0 +1,64 @@
+package langchain_test
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/jaeger-ai-assist-prototype/internal/ai"
+	"github.com/jaeger-ai-assist-prototype/internal/llm"
+	"github.com/jaeger-ai-assist-prototype/internal/llm/langchain"
+)
+
+var record = flag.Bool("record", false, "record testdata/replay again with the model in config/config.yaml")
+
+// replayExtractor answers from the fixtures in testdata/replay, which are
+// keyed by the model and options in config/config.yaml. With -record it calls
+// that model instead and rewrites them; delete the directory first to drop
+// fixtures of calls that no longer exist.
+func replayExtractor(t *testing.T) *langchain.SearchExtractor {
+	t.Helper()
+	loaded, err := langchain.Load("../../../config/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, mode := loaded.LLM, "replay"
+	if *record {
+		mode = "record"
+	}
+	cfg.Replay = langchain.ReplayConfig{Mode: mode, Dir: "testdata/replay"}
+
+	model, err := llm.NewLLM(cfg)
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+	return langchain.NewSearchExtractor(model, cfg)
+}
+
+func TestSearchExtractor_Replayed(t *testing.T) {
+	e := replayExtractor(t)
+	ctx := context.Background()
+
+	ir, err := e.ExtractSearchIR(ctx, "500 errors in orders-api for GetCart slower than 1.5s")
+	if err != nil {
+		t.Fatalf("ExtractSearchIR: %v", err)
+	}
+	if err := ai.ValidateSearchIR(ir); err != nil {
+		t.Fatalf("invalid IR %+v: %v", ir, err)
+	}
+	if ir.Service == nil || *ir.Service != "orders-api" || ir.Operation == nil || *ir.Operation != "GetCart" ||
+		ir.MinDurationMs == nil || ir.Tags["http.status_code"] != "500" {
+		t.Fatalf("unexpected IR %+v", ir)
+	}
+
+	out, err := e.ExplainSpan(ctx, `service: payment-svc
+span: Authorize (SERVER) 212ms, status ERROR "insufficient_funds"
+attributes: http.status_code=402`)
+	if err != nil {
+		t.Fatalf("ExplainSpan: %v", err)
+	}
+	if !strings.Contains(strings.ToLower(out), "insufficient") {
+		t.Fatalf("explanation misses the error:\n%s", out)
+	}
+}
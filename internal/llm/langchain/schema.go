@@ -0,0 +1,75 @@
+package langchain
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/jaeger-ai-assist-prototype/internal/ai"
+)
+
+// SearchIRSchema returns a JSON schema for the extraction output, derived
+// from the json tags of ai.SearchIR. Fields tagged schema:"string_or_list"
+// accept a string, a list of strings or null; fields tagged schema:"-" are
+// internal and left out.
+func SearchIRSchema() map[string]any {
+	props := map[string]any{}
+	var required []string
+
+	t := reflect.TypeOf(ai.SearchIR{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		hint := f.Tag.Get("schema")
+		if hint == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if hint == "string_or_list" {
+			props[name] = map[string]any{
+				"anyOf": []any{
+					map[string]any{"type": "string"},
+					map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					map[string]any{"type": "null"},
+				},
+			}
+		} else {
+			props[name] = schemaForType(f.Type)
+		}
+		required = append(required, name)
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func schemaForType(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		inner := schemaForType(t.Elem())
+		if typ, ok := inner["type"].(string); ok {
+			inner["type"] = []any{typ, "null"}
+		}
+		return inner
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaForType(t.Elem())}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64, reflect.Float32:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,213 @@
+// Package replay records LLM replies to fixture files and serves them back
+// without a model, so code that talks to an llms.Model can be tested offline
+// and deterministically.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Mode selects what a Model does with each call.
+type Mode string
+
+const (
+	// Record calls the wrapped model and writes every reply to a fixture,
+	// replacing an earlier one for the same call.
+	Record Mode = "record"
+
+	// Replay answers from fixtures only and fails on prompts without one.
+	Replay Mode = "replay"
+)
+
+// ErrNoFixture is returned in Replay mode for a call that was never
+// recorded, usually because the prompt, model or options changed since the
+// recording.
+var ErrNoFixture = errors.New("no recorded reply")
+
+// Fixture is the file written for one call. Model, options and prompt are
+// kept for review; only the file name, derived from them, is used for
+// lookups.
+type Fixture struct {
+	Model    string  `json:"model"`
+	Options  Options `json:"options"`
+	Prompt   string  `json:"prompt"`
+	Response string  `json:"response"`
+}
+
+// Options are the call options that change a reply. Streaming callbacks only
+// change how it is delivered and are left out.
+type Options struct {
+	Model       string   `json:"model,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Temperature float64  `json:"temperature,omitempty"`
+	TopP        float64  `json:"top_p,omitempty"`
+	TopK        int      `json:"top_k,omitempty"`
+	Seed        int      `json:"seed,omitempty"`
+	StopWords   []string `json:"stop_words,omitempty"`
+	JSONMode    bool     `json:"json_mode,omitempty"`
+}
+
+// OptionsOf picks the Options out of o.
+func OptionsOf(o llms.CallOptions) Options {
+	return Options{
+		Model:       o.Model,
+		MaxTokens:   o.MaxTokens,
+		Temperature: o.Temperature,
+		TopP:        o.TopP,
+		TopK:        o.TopK,
+		Seed:        o.Seed,
+		StopWords:   o.StopWords,
+		JSONMode:    o.JSONMode,
+	}
+}
+
+// Model wraps an llms.Model with recording or replay of its replies. Fixtures
+// are files in its directory named by Key.
+type Model struct {
+	next  llms.Model
+	model string
+	dir   string
+	mode  Mode
+}
+
+// New returns a Model in mode that keeps its fixtures in dir. model names
+// the provider and model behind next, e.g. "ollama/phi3:mini", and is part of
+// every key, so fixtures of one model are not replayed for another. next may
+// be nil in Replay mode.
+func New(next llms.Model, model, dir string, mode Mode) (*Model, error) {
+	switch mode {
+	case Record:
+		if next == nil {
+			return nil, errors.New("recording needs a model")
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create fixture dir: %w", err)
+		}
+	case Replay:
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("fixture dir: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown replay mode %q", mode)
+	}
+	return &Model{next: next, model: model, dir: dir, mode: mode}, nil
+}
+
+// Render is the prompt text that identifies a call: each message's role and
+// text parts, one message per line.
+func Render(msgs []llms.MessageContent) string {
+	var b strings.Builder
+	for i, m := range msgs {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(string(m.Role))
+		b.WriteString(": ")
+		for _, p := range m.Parts {
+			if t, ok := p.(llms.TextContent); ok {
+				b.WriteString(t.Text)
+			}
+		}
+	}
+	return b.String()
+}
+
+// Key names the fixture for a call: the first 16 hex characters of the
+// SHA-256 of the model, the options and the rendered prompt.
+func Key(model string, opts Options, rendered string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	// Options has only plain fields, so encoding cannot fail and is stable.
+	enc, _ := json.Marshal(opts)
+	h.Write(enc)
+	h.Write([]byte{0})
+	h.Write([]byte(rendered))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func (m *Model) path(key string) string {
+	return filepath.Join(m.dir, key+".json")
+}
+
+func (m *Model) GenerateContent(ctx context.Context, msgs []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	var o llms.CallOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	fixture := Fixture{Model: m.model, Options: OptionsOf(o), Prompt: Render(msgs)}
+	key := Key(fixture.Model, fixture.Options, fixture.Prompt)
+
+	if m.mode == Record {
+		resp, err := m.next.GenerateContent(ctx, msgs, opts...)
+		if err != nil || len(resp.Choices) == 0 {
+			return resp, err
+		}
+		fixture.Response = resp.Choices[0].Content
+		if err := m.write(key, fixture); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := m.read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// A recorded reply streams as a single chunk.
+	if o.StreamingFunc != nil {
+		if err := o.StreamingFunc(ctx, []byte(f.Response)); err != nil {
+			return nil, err
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: f.Response}}}, nil
+}
+
+func (m *Model) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, opts...)
+}
+
+func (m *Model) read(key string) (Fixture, error) {
+	data, err := os.ReadFile(m.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return Fixture{}, fmt.Errorf("%w for call %s in %s; record it again", ErrNoFixture, key, m.dir)
+	}
+	if err != nil {
+		return Fixture{}, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Fixture{}, fmt.Errorf("failed to parse fixture %s: %w", key, err)
+	}
+	return f, nil
+}
+
+func (m *Model) write(key string, f Fixture) error {
+	// Prompts are full of <Tags>; keep them readable in review.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.path(key), buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
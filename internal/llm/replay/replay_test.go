@@ -0,0 +1,133 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// echoModel answers "reply to <prompt>" and counts its calls.
+type echoModel struct {
+	calls int
+}
+
+func (m *echoModel) GenerateContent(ctx context.Context, msgs []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.calls++
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "reply to " + Render(msgs)}}}, nil
+}
+
+func (m *echoModel) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, opts...)
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	live := &echoModel{}
+	rec, err := New(live, "echo/v1", dir, Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, prompt := range []string{"ping", "pong"} {
+		if _, err := llms.GenerateFromSinglePrompt(ctx, rec, prompt); err != nil {
+			t.Fatalf("record %q: %v", prompt, err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if live.calls != 2 || len(files) != 2 {
+		t.Fatalf("%d calls wrote %d fixtures", live.calls, len(files))
+	}
+
+	play, err := New(nil, "echo/v1", dir, Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed string
+	out, err := llms.GenerateFromSinglePrompt(ctx, play, "ping", llms.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+		streamed += string(chunk)
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if out != "reply to human: ping" || streamed != out {
+		t.Fatalf("replayed %q, streamed %q", out, streamed)
+	}
+
+	if _, err := llms.GenerateFromSinglePrompt(ctx, play, "ping!"); !errors.Is(err, ErrNoFixture) {
+		t.Fatalf("got %v for an unrecorded prompt, want ErrNoFixture", err)
+	}
+}
+
+func TestReplay_KeyedByModelAndOptions(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := New(&echoModel{}, "echo/v1", dir, Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := llms.GenerateFromSinglePrompt(ctx, rec, "ping", llms.WithTemperature(0.2), llms.WithMaxTokens(64)); err != nil {
+		t.Fatal(err)
+	}
+
+	play, err := New(nil, "echo/v1", dir, Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := llms.GenerateFromSinglePrompt(ctx, play, "ping", llms.WithMaxTokens(64), llms.WithTemperature(0.2)); err != nil {
+		t.Fatalf("same options in another order: %v", err)
+	}
+	for name, opts := range map[string][]llms.CallOption{
+		"no options":        nil,
+		"other temperature": {llms.WithTemperature(0.7), llms.WithMaxTokens(64)},
+		"json mode":         {llms.WithTemperature(0.2), llms.WithMaxTokens(64), llms.WithJSONMode()},
+		"model override":    {llms.WithTemperature(0.2), llms.WithMaxTokens(64), llms.WithModel("echo/v2")},
+	} {
+		if _, err := llms.GenerateFromSinglePrompt(ctx, play, "ping", opts...); !errors.Is(err, ErrNoFixture) {
+			t.Fatalf("%s: got %v, want ErrNoFixture", name, err)
+		}
+	}
+
+	other, err := New(nil, "echo/v2", dir, Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := llms.GenerateFromSinglePrompt(ctx, other, "ping", llms.WithTemperature(0.2), llms.WithMaxTokens(64)); !errors.Is(err, ErrNoFixture) {
+		t.Fatalf("another model replayed the fixture: %v", err)
+	}
+}
+
+func TestRecord_KeepsPromptForReview(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := New(&echoModel{}, "echo/v1", dir, Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := llms.GenerateFromSinglePrompt(context.Background(), rec, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, Key("echo/v1", Options{}, "human: ping")+".json"))
+	if err != nil {
+		t.Fatalf("fixture not named by the call key: %v", err)
+	}
+	want := "{\n  \"model\": \"echo/v1\",\n  \"options\": {},\n  \"prompt\": \"human: ping\",\n  \"response\": \"reply to human: ping\"\n}\n"
+	if string(data) != want {
+		t.Fatalf("fixture:\n%s", data)
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	if _, err := New(nil, "echo/v1", t.TempDir(), Record); err == nil {
+		t.Fatalf("recording without a model must fail")
+	}
+	if _, err := New(nil, "echo/v1", filepath.Join(t.TempDir(), "missing"), Replay); err == nil {
+		t.Fatalf("replaying from a missing dir must fail")
+	}
+	if _, err := New(nil, "echo/v1", t.TempDir(), "rewind"); err == nil {
+		t.Fatalf("unknown mode must fail")
+	}
+}
@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNew_RequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, slog.LevelInfo, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRequestID(context.Background(), "abc123")
+	logger.With("component", "test").InfoContext(ctx, "hello", "n", 1)
+	logger.DebugContext(ctx, "hidden")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "hello" || rec["request_id"] != "abc123" || rec["component"] != "test" {
+		t.Fatalf("unexpected record %v", rec)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("debug"); err != nil || l != slog.LevelDebug {
+		t.Fatalf("got %v, %v", l, err)
+	}
+	if l, err := ParseLevel(""); err != nil || l != slog.LevelInfo {
+		t.Fatalf("got %v, %v", l, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := New(&bytes.Buffer{}, slog.LevelInfo, "xml"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
@@ -2,13 +2,19 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"iter"
 	"time"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
 )
 
+// ErrTraceNotFound is returned when a trace looked up by ID does not exist.
+var ErrTraceNotFound = errors.New("trace not found")
+
 type TraceQueryParams struct {
 	ServiceName   string
 	OperationName string
@@ -25,6 +31,9 @@ type TraceReader interface {
 		ctx context.Context,
 		query TraceQueryParams,
 	) iter.Seq2[[]ptrace.Traces, error]
+
+	// GetTrace returns the trace with the given ID, or ErrTraceNotFound.
+	GetTrace(ctx context.Context, id pcommon.TraceID) (ptrace.Traces, error)
 }
 
 type QueryService struct {
@@ -42,41 +51,93 @@ func (qs *QueryService) FindTraces(
 	return qs.reader.FindTraces(ctx, params)
 }
 
+func (qs *QueryService) GetTrace(
+	ctx context.Context,
+	id pcommon.TraceID,
+) (ptrace.Traces, error) {
+	return qs.reader.GetTrace(ctx, id)
+}
+
 func TraceMatchesService(t ptrace.Traces, service string) bool {
-	rs := t.ResourceSpans()
-	for i := 0; i < rs.Len(); i++ {
-		ss := rs.At(i).ScopeSpans()
-		for j := 0; j < ss.Len(); j++ {
-			spans := ss.At(j).Spans()
-			for k := 0; k < spans.Len(); k++ {
-				if v, ok := spans.At(k).Attributes().Get("service.name"); ok {
-					if v.Str() == service {
-						return true
-					}
-				}
-			}
-		}
+	return traceutil.HasService(t, service)
+}
+
+// TraceMatchesSpanFilters reports whether a single span of t satisfies the
+// service, operation and attribute filters together, as Jaeger does.
+// Attributes match against the span's own attributes or its resource's;
+// error=true also matches spans whose status is ERROR.
+func TraceMatchesSpanFilters(t ptrace.Traces, service, operation string, attrs pcommon.Map) bool {
+	if service == "" && operation == "" && isEmptyMap(attrs) {
+		return true
 	}
-	return false
+	found := false
+	traceutil.ForEachSpan(t, func(res pcommon.Resource, span ptrace.Span) bool {
+		if service != "" && traceutil.ServiceName(res, span) != service {
+			return true
+		}
+		if operation != "" && span.Name() != operation {
+			return true
+		}
+		found = spanMatchesAttributes(attrs, res, span)
+		return !found
+	})
+	return found
 }
 
-func TraceMatchesMinDuration(t ptrace.Traces, min time.Duration) bool {
-	if min == 0 {
+// isEmptyMap also covers the zero pcommon.Map, which is what an unset
+// TraceQueryParams.Attributes holds and which panics on Len.
+func isEmptyMap(m pcommon.Map) bool {
+	return m == (pcommon.Map{}) || m.Len() == 0
+}
+
+func spanMatchesAttributes(want pcommon.Map, res pcommon.Resource, span ptrace.Span) bool {
+	if isEmptyMap(want) {
 		return true
 	}
-	rs := t.ResourceSpans()
-	for i := 0; i < rs.Len(); i++ {
-		ss := rs.At(i).ScopeSpans()
-		for j := 0; j < ss.Len(); j++ {
-			spans := ss.At(j).Spans()
-			for k := 0; k < spans.Len(); k++ {
-				span := spans.At(k)
-				dur := span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime())
-				if dur >= min {
-					return true
-				}
+	matched := true
+	want.Range(func(k string, v pcommon.Value) bool {
+		if k == "error" && v.AsString() == "true" && traceutil.IsError(span) {
+			return true
+		}
+		for _, src := range []pcommon.Map{span.Attributes(), res.Attributes()} {
+			if got, ok := src.Get(k); ok && got.AsString() == v.AsString() {
+				return true
 			}
 		}
+		matched = false
+		return false
+	})
+	return matched
+}
+
+// TraceMatchesDuration checks the trace duration, taken from the root span,
+// against the [min, max] bounds. Zero bounds are ignored.
+func TraceMatchesDuration(t ptrace.Traces, min, max time.Duration) bool {
+	if min == 0 && max == 0 {
+		return true
+	}
+	dur := traceutil.Duration(t)
+	if min != 0 && dur < min {
+		return false
+	}
+	if max != 0 && dur > max {
+		return false
+	}
+	return true
+}
+
+// TraceMatchesStartTime checks the trace start time against the [min, max]
+// bounds. Zero bounds are ignored.
+func TraceMatchesStartTime(t ptrace.Traces, min, max time.Time) bool {
+	if min.IsZero() && max.IsZero() {
+		return true
+	}
+	start := traceutil.StartTime(t)
+	if !min.IsZero() && start.Before(min) {
+		return false
+	}
+	if !max.IsZero() && start.After(max) {
+		return false
 	}
-	return false
+	return true
 }
@@ -0,0 +1,353 @@
+// Package redact replaces sensitive values in text bound for an LLM or a log
+// with stable pseudonyms, so the model can still tell that two spans carry
+// the same user or address without ever seeing it.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Built-in detectors, in the order they run.
+const (
+	JWT        = "jwt"
+	Bearer     = "bearer"
+	Email      = "email"
+	CreditCard = "credit_card"
+	IP         = "ip"
+	SQL        = "sql"
+)
+
+// DefaultDetectors enables every built-in detector.
+var DefaultDetectors = []string{JWT, Bearer, Email, CreditCard, IP, SQL}
+
+// maxRemembered bounds the pseudonyms kept for Restore; past it the oldest
+// half is forgotten.
+const maxRemembered = 10000
+
+// Rule is a user-defined detector. When Pattern has a capture group only the
+// first group is replaced, so "user_id=(\w+)" keeps the key visible.
+type Rule struct {
+	// Name labels the pseudonyms, e.g. USER_ID gives <USER_ID_1a2b3c>.
+	Name    string
+	Pattern string
+}
+
+// Options configures a Redactor.
+type Options struct {
+	// Detectors lists the built-in detectors to run; nil means
+	// DefaultDetectors.
+	Detectors []string
+	Rules     []Rule
+	// Key seeds the pseudonyms. With the same key a value maps to the same
+	// pseudonym across restarts; empty uses a random per-process key.
+	Key []byte
+}
+
+// Redactor pseudonymizes sensitive values. A nil *Redactor leaves text
+// unchanged. It is safe for concurrent use.
+type Redactor struct {
+	key       []byte
+	detectors []detector
+
+	mu       sync.Mutex
+	original map[string]string
+	order    []string
+}
+
+type detector struct {
+	label string
+	re    *regexp.Regexp
+	// replace rewrites one match, calling pseudo for each sensitive part.
+	replace func(match []string, pseudo func(value string) string) string
+	// run replaces re and replace for detectors that need more context.
+	run func(r *Redactor, s string) string
+	// statements marks detectors for span attribute values, which
+	// RedactInput skips.
+	statements bool
+}
+
+var (
+	jwtRe    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+	bearerRe = regexp.MustCompile(`(?i)\b(bearer\s+)([A-Za-z0-9._~+/-]{8,}=*)`)
+	emailRe  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	cardRe   = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	ipv4Re   = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`)
+	// ipv6Re matches whole runs of word characters and colons, so
+	// "std::vector" is one candidate and never yields "d::".
+	ipv6Re = regexp.MustCompile(`\w*:[\w:]*`)
+	// sqlRe finds the start of a statement; literals are replaced from there
+	// to the end of the line.
+	sqlRe = regexp.MustCompile(`(?i)\b(?:select\s.+\sfrom|insert\s+into|update\s+\S+\s+set|delete\s+from)\s`)
+	// sqlLiteralRe matches quoted strings and standalone numbers.
+	sqlLiteralRe = regexp.MustCompile(`'(?:[^']|'')*'|\b\d+(?:\.\d+)?\b`)
+	pseudonymRe  = regexp.MustCompile(`<[A-Z0-9_]+_[0-9a-f]{6}>`)
+	nonLabelRe   = regexp.MustCompile(`[^A-Za-z0-9]+`)
+)
+
+// New compiles opts into a Redactor.
+func New(opts Options) (*Redactor, error) {
+	key := opts.Key
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	r := &Redactor{key: key, original: map[string]string{}}
+
+	detectors := opts.Detectors
+	if detectors == nil {
+		detectors = DefaultDetectors
+	}
+	enabled := map[string]bool{}
+	for _, d := range detectors {
+		if !isBuiltin(d) {
+			return nil, fmt.Errorf("unknown redaction detector %q", d)
+		}
+		enabled[d] = true
+	}
+
+	// User rules run first: they are the most specific.
+	for _, rule := range opts.Rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %q: %w", rule.Name, err)
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("redaction rule %q needs a name", rule.Pattern)
+		}
+		r.detectors = append(r.detectors, detector{
+			label:   label(rule.Name),
+			re:      re,
+			replace: replaceGroup(re),
+		})
+	}
+
+	for _, d := range DefaultDetectors {
+		if enabled[d] {
+			r.detectors = append(r.detectors, builtin(d)...)
+		}
+	}
+	return r, nil
+}
+
+func isBuiltin(name string) bool {
+	for _, d := range DefaultDetectors {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+func label(name string) string {
+	return strings.ToUpper(nonLabelRe.ReplaceAllString(name, "_"))
+}
+
+func whole(match []string, pseudo func(string) string) string {
+	return pseudo(match[0])
+}
+
+func replaceGroup(re *regexp.Regexp) func([]string, func(string) string) string {
+	if re.NumSubexp() == 0 {
+		return whole
+	}
+	return func(match []string, pseudo func(string) string) string {
+		if match[1] == "" {
+			return match[0]
+		}
+		i := strings.Index(match[0], match[1])
+		return match[0][:i] + pseudo(match[1]) + match[0][i+len(match[1]):]
+	}
+}
+
+func builtin(name string) []detector {
+	switch name {
+	case JWT:
+		return []detector{{label: "JWT", re: jwtRe, replace: whole}}
+	case Bearer:
+		return []detector{{label: "TOKEN", re: bearerRe, replace: func(m []string, pseudo func(string) string) string {
+			return m[1] + pseudo(m[2])
+		}}}
+	case Email:
+		return []detector{{label: "EMAIL", re: emailRe, replace: whole}}
+	case CreditCard:
+		return []detector{{label: "CARD", re: cardRe, replace: func(m []string, pseudo func(string) string) string {
+			if !luhn(m[0]) {
+				return m[0]
+			}
+			return pseudo(m[0])
+		}}}
+	case IP:
+		return []detector{
+			{label: "IP", re: ipv4Re, replace: whole},
+			{label: "IP", re: ipv6Re, replace: func(m []string, pseudo func(string) string) string {
+				if !isIPv6(m[0]) {
+					return m[0]
+				}
+				return pseudo(m[0])
+			}},
+		}
+	case SQL:
+		return []detector{{label: "SQL", run: (*Redactor).redactSQL, statements: true}}
+	}
+	panic("redact: unknown detector " + name)
+}
+
+// isIPv6 reports whether a colon-joined token is an IPv6 address. Tokens
+// without a digit are left alone: "cafe::add" parses, but in a stack trace
+// it is a C++ name.
+func isIPv6(token string) bool {
+	return strings.Count(token, ":") >= 2 &&
+		strings.ContainsAny(token, "0123456789") &&
+		net.ParseIP(token) != nil
+}
+
+// Redact returns s with every detected value replaced by its pseudonym.
+func (r *Redactor) Redact(s string) string {
+	return r.redact(s, true)
+}
+
+// RedactInput is Redact for free-form text a user typed, such as a search
+// question. The SQL detector is skipped: "select traces from checkout" is a
+// question, not a statement whose literals need hiding.
+func (r *Redactor) RedactInput(s string) string {
+	return r.redact(s, false)
+}
+
+func (r *Redactor) redact(s string, statements bool) string {
+	if r == nil || s == "" {
+		return s
+	}
+	for _, d := range r.detectors {
+		if d.statements && !statements {
+			continue
+		}
+		if d.run != nil {
+			s = d.run(r, s)
+		} else {
+			s = r.replaceAll(s, d)
+		}
+	}
+	return s
+}
+
+func (r *Redactor) replaceAll(s string, d detector) string {
+	matches := d.re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	pseudo := func(v string) string { return r.pseudonym(d.label, v) }
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range matches {
+		m := make([]string, len(loc)/2)
+		for i := range m {
+			if loc[2*i] >= 0 {
+				m[i] = s[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		b.WriteString(s[last:loc[0]])
+		if pseudonymRe.MatchString(m[0]) {
+			b.WriteString(m[0])
+		} else {
+			b.WriteString(d.replace(m, pseudo))
+		}
+		last = loc[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// redactSQL replaces the literals of SQL statements, from the statement's
+// first keyword to the end of its line, keeping the quotes around strings.
+func (r *Redactor) redactSQL(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		loc := sqlRe.FindStringIndex(line)
+		if loc == nil {
+			continue
+		}
+		lines[i] = line[:loc[0]] + sqlLiteralRe.ReplaceAllStringFunc(line[loc[0]:], func(lit string) string {
+			if strings.HasPrefix(lit, "'") {
+				inner := lit[1 : len(lit)-1]
+				if inner == "" || pseudonymRe.MatchString(inner) {
+					return lit
+				}
+				return "'" + r.pseudonym("SQL", inner) + "'"
+			}
+			return r.pseudonym("SQL", lit)
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pseudonym derives a stable placeholder for value, e.g. <EMAIL_3f9a1c>.
+func (r *Redactor) pseudonym(label, value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(label))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	p := "<" + label + "_" + hex.EncodeToString(mac.Sum(nil))[:6] + ">"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.original[p]; !ok {
+		if len(r.order) >= maxRemembered {
+			for _, old := range r.order[:maxRemembered/2] {
+				delete(r.original, old)
+			}
+			r.order = append(r.order[:0], r.order[maxRemembered/2:]...)
+		}
+		r.order = append(r.order, p)
+	}
+	r.original[p] = value
+	return p
+}
+
+// Restore puts back the original values of pseudonyms this Redactor handed
+// out, e.g. in filters a model extracted from a redacted question.
+// Pseudonyms it does not know are left as they are.
+func (r *Redactor) Restore(s string) string {
+	if r == nil || !strings.Contains(s, "<") {
+		return s
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return pseudonymRe.ReplaceAllStringFunc(s, func(p string) string {
+		if v, ok := r.original[p]; ok {
+			return v
+		}
+		return p
+	})
+}
+
+// luhn reports whether the digits of s pass the card number checksum.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
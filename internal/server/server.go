@@ -0,0 +1,378 @@
+// Package server exposes the AI query service over HTTP/JSON so a UI can call
+// it.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal"
+	"github.com/jaeger-ai-assist-prototype/internal/ai"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+const (
+	DefaultRequestTimeout  = 2 * time.Minute
+	DefaultShutdownTimeout = 10 * time.Second
+
+	maxRequestBody = 1 << 20
+)
+
+var errSpanNotFound = errors.New("span not found")
+
+type Server struct {
+	// AI serves searches and explanations and looks up the traces to
+	// explain.
+	AI *ai.AIQueryService
+
+	// RequestTimeout bounds each request, LLM calls included. 0 uses
+	// DefaultRequestTimeout.
+	RequestTimeout time.Duration
+
+	// ShutdownTimeout is how long in-flight requests may run after
+	// ListenAndServe's context is cancelled. 0 uses DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+
+	// Logger records every request; nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+// Handler returns the API routes:
+//
+//	POST /api/search                                      {"query": "..."}
+//	GET  /api/traces/{traceID}/explain
+//	GET  /api/traces/{traceID}/spans/{spanID}/explain
+//	GET  /healthz
+//
+// The explain routes stream Server-Sent Events when asked to; see
+// respondExplanation. Every request gets an ID, taken from X-Request-ID or
+// generated, that is echoed in the response and attached to its log lines.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/search", s.handleSearch)
+	mux.HandleFunc("GET /api/traces/{traceID}/explain", s.handleExplainTrace)
+	mux.HandleFunc("GET /api/traces/{traceID}/spans/{spanID}/explain", s.handleExplainSpan)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	return s.withRequestLog(mux)
+}
+
+// withRequestLog assigns the request ID and logs each request with its
+// status and duration.
+func (s *Server) withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			id = logging.NewRequestID()
+		}
+		ctx := logging.WithRequestID(r.Context(), id)
+		w.Header().Set("X-Request-ID", id)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		began := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		logging.OrDefault(s.Logger).Log(ctx, level, "http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(began))
+	})
+}
+
+// statusRecorder remembers the response status. It passes Flush through so
+// event streams keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// ListenAndServe serves the API on addr until ctx is cancelled, then shuts
+// down gracefully, giving in-flight requests ShutdownTimeout to finish.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, lis)
+}
+
+// Serve is ListenAndServe on an existing listener.
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(lis) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+func (s *Server) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := s.RequestTimeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+type searchRequest struct {
+	Query string `json:"query"`
+}
+
+type searchResponse struct {
+	IR         ai.SearchIR       `json:"ir"`
+	SubQueries []ai.SearchIR     `json:"sub_queries"`
+	Traces     []json.RawMessage `json:"traces"`
+	MatchedBy  [][]int           `json:"matched_by"`
+	Batches    int               `json:"batches"`
+	Truncated  bool              `json:"truncated"`
+	Partial    bool              `json:"partial"`
+	ElapsedMs  int64             `json:"elapsed_ms"`
+	// Warning carries the reader error behind a partial result.
+	Warning string `json:"warning,omitempty"`
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req searchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, badRequest("invalid request body: "+err.Error()))
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeError(w, badRequest("query is required"))
+		return
+	}
+
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+
+	result, err := s.AI.Search(ctx, req.Query)
+	// A timed-out search is a 504 even with partial results kept, so clients
+	// need not read the warning to tell it from a complete one.
+	if err != nil && (!result.Partial || errors.Is(err, context.DeadlineExceeded)) {
+		apiErr := classify(err)
+		apiErr.Attempts = result.Attempts
+		writeError(w, apiErr)
+		return
+	}
+
+	resp := searchResponse{
+		IR:         result.IR,
+		SubQueries: result.SubQueries,
+		Traces:     make([]json.RawMessage, 0, len(result.Traces)),
+		MatchedBy:  result.MatchedBy,
+		Batches:    result.Batches,
+		Truncated:  result.Truncated,
+		Partial:    result.Partial,
+		ElapsedMs:  result.Elapsed.Milliseconds(),
+	}
+	if err != nil {
+		resp.Warning = err.Error()
+	}
+
+	marshaler := &ptrace.JSONMarshaler{}
+	for _, t := range result.Traces {
+		b, err := marshaler.MarshalTraces(t)
+		if err != nil {
+			writeError(w, &apiError{status: http.StatusInternalServerError, Code: "internal", Message: err.Error()})
+			return
+		}
+		resp.Traces = append(resp.Traces, b)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type explanationResponse struct {
+	TraceID     string `json:"trace_id"`
+	SpanID      string `json:"span_id,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Explanation string `json:"explanation"`
+	// RootCauses ranks the failing spans of an explained trace.
+	RootCauses []ai.RootCause `json:"root_causes,omitempty"`
+}
+
+func (s *Server) handleExplainTrace(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+
+	trace, apiErr := s.lookupTrace(ctx, r.PathValue("traceID"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	resp := explanationResponse{
+		TraceID:    traceutil.TraceID(trace).String(),
+		RootCauses: ai.RankRootCauses(trace),
+	}
+	respondExplanation(w, r, resp, func(onChunk func(string) error) (string, error) {
+		return s.AI.ExplainTraceStream(ctx, trace, onChunk)
+	})
+}
+
+func (s *Server) handleExplainSpan(w http.ResponseWriter, r *http.Request) {
+	spanID, err := traceutil.ParseSpanID(r.PathValue("spanID"))
+	if err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+
+	trace, apiErr := s.lookupTrace(ctx, r.PathValue("traceID"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	span, res, ok := traceutil.FindSpan(trace, spanID)
+	if !ok {
+		writeError(w, classify(errSpanNotFound))
+		return
+	}
+	service := traceutil.ServiceName(res, span)
+	if service == "" {
+		service = "unknown"
+	}
+
+	resp := explanationResponse{
+		TraceID: span.TraceID().String(),
+		SpanID:  span.SpanID().String(),
+		Service: service,
+	}
+	respondExplanation(w, r, resp, func(onChunk func(string) error) (string, error) {
+		return s.AI.ExplainSpanStream(ctx, span, res, service, onChunk)
+	})
+}
+
+// respondExplanation runs explain and writes the result as one JSON body, or
+// as Server-Sent Events when the client asks for text/event-stream (or passes
+// ?stream=true): a "chunk" event per piece of output, then "done" with the
+// full response or "error".
+func respondExplanation(
+	w http.ResponseWriter,
+	r *http.Request,
+	resp explanationResponse,
+	explain func(onChunk func(string) error) (string, error),
+) {
+	sse, ok := newSSEWriter(w, r)
+	if !ok {
+		explanation, err := explain(nil)
+		if err != nil {
+			writeError(w, classify(err))
+			return
+		}
+		resp.Explanation = explanation
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	explanation, err := explain(func(chunk string) error {
+		return sse.send("chunk", map[string]string{"text": chunk})
+	})
+	if err != nil {
+		_ = sse.send("error", classify(err))
+		return
+	}
+	resp.Explanation = explanation
+	_ = sse.send("done", resp)
+}
+
+func (s *Server) lookupTrace(ctx context.Context, rawID string) (ptrace.Traces, *apiError) {
+	trace, err := s.AI.GetTrace(ctx, rawID)
+	if err != nil {
+		return ptrace.Traces{}, classify(err)
+	}
+	return trace, nil
+}
+
+// apiError is the body of every error response:
+//
+//	{"error": {"code": "not_found", "message": "trace not found"}}
+type apiError struct {
+	status   int
+	Code     string                 `json:"code"`
+	Message  string                 `json:"message"`
+	Attempts []ai.ExtractionAttempt `json:"attempts,omitempty"`
+}
+
+func badRequest(msg string) *apiError {
+	return &apiError{status: http.StatusBadRequest, Code: "invalid_request", Message: msg}
+}
+
+// classify maps service errors to HTTP statuses and stable error codes.
+func classify(err error) *apiError {
+	var xe *ai.ExtractionError
+	switch {
+	case errors.Is(err, traceutil.ErrInvalidTraceID), errors.Is(err, traceutil.ErrInvalidSpanID):
+		return badRequest(err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return &apiError{status: http.StatusGatewayTimeout, Code: "timeout", Message: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &apiError{status: http.StatusServiceUnavailable, Code: "canceled", Message: err.Error()}
+	case errors.Is(err, internal.ErrTraceNotFound), errors.Is(err, errSpanNotFound):
+		return &apiError{status: http.StatusNotFound, Code: "not_found", Message: err.Error()}
+	case errors.As(err, &xe):
+		return &apiError{status: http.StatusUnprocessableEntity, Code: "extraction_failed", Message: err.Error()}
+	default:
+		return &apiError{status: http.StatusBadGateway, Code: "upstream_error", Message: err.Error()}
+	}
+}
+
+func writeError(w http.ResponseWriter, e *apiError) {
+	writeJSON(w, e.status, map[string]*apiError{"error": e})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
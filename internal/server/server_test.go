@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal"
+	"github.com/jaeger-ai-assist-prototype/internal/ai"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/synthetic"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+type stubLLM struct {
+	ir    ai.SearchIR
+	err   error
+	block bool
+}
+
+func (l *stubLLM) ExtractSearchIR(ctx context.Context, input string) (ai.SearchIR, error) {
+	if l.block {
+		<-ctx.Done()
+		return ai.SearchIR{}, ctx.Err()
+	}
+	return l.ir, l.err
+}
+
+func (l *stubLLM) ExplainTrace(ctx context.Context, context string) (string, error) {
+	return "trace: " + strings.SplitN(context, "\n", 2)[0], nil
+}
+
+func (l *stubLLM) ExplainSpan(ctx context.Context, context string) (string, error) {
+	return "span explained", nil
+}
+
+func (l *stubLLM) ExplainTraceStream(ctx context.Context, context string, onChunk func(string) error) (string, error) {
+	for _, c := range []string{"first\n", "second"} {
+		if err := onChunk(c); err != nil {
+			return "", err
+		}
+	}
+	return "first\nsecond", nil
+}
+
+func (l *stubLLM) ExplainSpanStream(ctx context.Context, context string, onChunk func(string) error) (string, error) {
+	return "span explained", onChunk("span explained")
+}
+
+func strptr(s string) *string { return &s }
+
+func newTestServer(t *testing.T, llm ai.LLM) (*httptest.Server, []ptrace.Traces) {
+	t.Helper()
+	traces, err := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatalf("load bench traces: %v", err)
+	}
+	reader := synthetic.NewSyntheticTraceReader(traces)
+	s := &Server{
+		AI: &ai.AIQueryService{
+			LLM:                   llm,
+			Query:                 internal.NewQueryService(reader),
+			MaxExtractionAttempts: 1,
+			SearchDepth:           100,
+		},
+		RequestTimeout: 200 * time.Millisecond,
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts, traces
+}
+
+func do(t *testing.T, method, url, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp.StatusCode
+}
+
+type errorBody struct {
+	Error struct {
+		Code     string                 `json:"code"`
+		Message  string                 `json:"message"`
+		Attempts []ai.ExtractionAttempt `json:"attempts"`
+	} `json:"error"`
+}
+
+func TestSearch(t *testing.T) {
+	ts, _ := newTestServer(t, &stubLLM{ir: ai.SearchIR{Service: strptr("payment-svc")}})
+
+	var resp searchResponse
+	status := do(t, "POST", ts.URL+"/api/search", `{"query": "payment errors"}`, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if resp.IR.Service == nil || *resp.IR.Service != "payment-svc" {
+		t.Fatalf("response must carry the extracted IR, got %+v", resp.IR)
+	}
+	if len(resp.Traces) != 34 || len(resp.MatchedBy) != 34 {
+		t.Fatalf("got %d traces, want 34", len(resp.Traces))
+	}
+
+	// Traces are OTLP JSON, readable by the same unmarshaler as the bench.
+	td, err := (&ptrace.JSONUnmarshaler{}).UnmarshalTraces(resp.Traces[0])
+	if err != nil {
+		t.Fatalf("trace is not OTLP JSON: %v", err)
+	}
+	if !traceutil.HasService(td, "payment-svc") {
+		t.Fatalf("unexpected trace in response")
+	}
+}
+
+func TestSearch_BadRequests(t *testing.T) {
+	ts, _ := newTestServer(t, &stubLLM{})
+
+	for _, body := range []string{`not json`, `{"query": "  "}`, `{}`} {
+		var resp errorBody
+		if status := do(t, "POST", ts.URL+"/api/search", body, &resp); status != http.StatusBadRequest {
+			t.Fatalf("%s: got status %d", body, status)
+		}
+		if resp.Error.Code != "invalid_request" {
+			t.Fatalf("%s: got code %q", body, resp.Error.Code)
+		}
+	}
+}
+
+func TestSearch_ExtractionFailed(t *testing.T) {
+	ts, _ := newTestServer(t, &stubLLM{err: &ai.ExtractionError{Output: "no idea", Err: errors.New("no JSON object")}})
+
+	var resp errorBody
+	if status := do(t, "POST", ts.URL+"/api/search", `{"query": "?"}`, &resp); status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d", status)
+	}
+	if resp.Error.Code != "extraction_failed" || len(resp.Error.Attempts) != 1 || resp.Error.Attempts[0].Output != "no idea" {
+		t.Fatalf("unexpected error body %+v", resp.Error)
+	}
+}
+
+func TestSearch_Timeout(t *testing.T) {
+	ts, _ := newTestServer(t, &stubLLM{block: true})
+
+	var resp errorBody
+	if status := do(t, "POST", ts.URL+"/api/search", `{"query": "slow"}`, &resp); status != http.StatusGatewayTimeout {
+		t.Fatalf("got status %d", status)
+	}
+	if resp.Error.Code != "timeout" {
+		t.Fatalf("got code %q", resp.Error.Code)
+	}
+}
+
+// stallingReader yields the first matching trace, then fails with err, or
+// blocks until the request context ends when err is nil.
+type stallingReader struct {
+	internal.TraceReader
+	err error
+}
+
+func (r stallingReader) FindTraces(ctx context.Context, q internal.TraceQueryParams) iter.Seq2[[]ptrace.Traces, error] {
+	return func(yield func([]ptrace.Traces, error) bool) {
+		for batch, err := range r.TraceReader.FindTraces(ctx, q) {
+			if !yield(batch, err) || err != nil {
+				return
+			}
+			break
+		}
+		if r.err != nil {
+			yield(nil, r.err)
+			return
+		}
+		<-ctx.Done()
+		yield(nil, ctx.Err())
+	}
+}
+
+func TestSearch_PartialResults(t *testing.T) {
+	traces, err := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newServer := func(readErr error) *httptest.Server {
+		s := &Server{
+			AI: &ai.AIQueryService{
+				LLM:                &stubLLM{ir: ai.SearchIR{Service: strptr("payment-svc")}},
+				Query:              internal.NewQueryService(stallingReader{synthetic.NewSyntheticTraceReader(traces), readErr}),
+				KeepPartialResults: true,
+			},
+			RequestTimeout: 100 * time.Millisecond,
+		}
+		ts := httptest.NewServer(s.Handler())
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	// A reader failure keeps what was found, flagged as partial.
+	var partial struct {
+		Traces  []json.RawMessage `json:"traces"`
+		Partial bool              `json:"partial"`
+		Warning string            `json:"warning"`
+	}
+	ts := newServer(errors.New("storage unavailable"))
+	if status := do(t, "POST", ts.URL+"/api/search", `{"query": "payment errors"}`, &partial); status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", status)
+	}
+	if len(partial.Traces) != 1 || !partial.Partial || !strings.Contains(partial.Warning, "storage unavailable") {
+		t.Fatalf("unexpected partial response %+v", partial)
+	}
+
+	// Running out of time is a timeout, whatever was found before.
+	var timeout errorBody
+	ts = newServer(nil)
+	if status := do(t, "POST", ts.URL+"/api/search", `{"query": "payment errors"}`, &timeout); status != http.StatusGatewayTimeout {
+		t.Fatalf("got status %d, want 504", status)
+	}
+	if timeout.Error.Code != "timeout" {
+		t.Fatalf("got code %q", timeout.Error.Code)
+	}
+}
+
+func TestExplainTrace(t *testing.T) {
+	ts, traces := newTestServer(t, &stubLLM{})
+	id := traceutil.TraceID(traces[3]).String()
+
+	var resp explanationResponse
+	if status := do(t, "GET", ts.URL+"/api/traces/"+id+"/explain", "", &resp); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if resp.TraceID != id || resp.Explanation == "" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	// The bench checkout trace fails in payment-svc.
+	id = traceutil.TraceID(traces[0]).String()
+	resp = explanationResponse{}
+	if status := do(t, "GET", ts.URL+"/api/traces/"+id+"/explain", "", &resp); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if len(resp.RootCauses) == 0 || resp.RootCauses[0].Service != "payment-svc" {
+		t.Fatalf("unexpected root causes %+v", resp.RootCauses)
+	}
+
+	var errResp errorBody
+	if status := do(t, "GET", ts.URL+"/api/traces/ffff/explain", "", &errResp); status != http.StatusNotFound {
+		t.Fatalf("unknown trace: got status %d", status)
+	}
+	if status := do(t, "GET", ts.URL+"/api/traces/not-hex/explain", "", &errResp); status != http.StatusBadRequest {
+		t.Fatalf("bad trace ID: got status %d", status)
+	}
+}
+
+func TestExplainTrace_Stream(t *testing.T) {
+	ts, traces := newTestServer(t, &stubLLM{})
+	id := traceutil.TraceID(traces[3]).String()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/api/traces/"+id+"/explain", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	causes, _ := json.Marshal(ai.RankRootCauses(traces[3]))
+	body, _ := io.ReadAll(resp.Body)
+	want := "event: chunk\ndata: {\"text\":\"first\\n\"}\n\n" +
+		"event: chunk\ndata: {\"text\":\"second\"}\n\n" +
+		"event: done\ndata: {\"trace_id\":\"" + id + "\",\"explanation\":\"first\\nsecond\",\"root_causes\":" + string(causes) + "}\n\n"
+	if string(body) != want {
+		t.Fatalf("unexpected event stream:\n%s", body)
+	}
+}
+
+func TestExplainTrace_StreamNotFound(t *testing.T) {
+	ts, _ := newTestServer(t, &stubLLM{})
+
+	// Lookup failures happen before the stream starts, so they are plain
+	// JSON errors.
+	var errResp errorBody
+	if status := do(t, "GET", ts.URL+"/api/traces/ffff/explain?stream=true", "", &errResp); status != http.StatusNotFound {
+		t.Fatalf("got status %d", status)
+	}
+}
+
+func TestExplainSpan(t *testing.T) {
+	ts, traces := newTestServer(t, &stubLLM{})
+	trace := traces[0]
+	var spanID, service string
+	traceutil.ForEachSpan(trace, func(res pcommon.Resource, span ptrace.Span) bool {
+		if !span.ParentSpanID().IsEmpty() {
+			spanID, service = span.SpanID().String(), traceutil.ServiceName(res, span)
+			return false
+		}
+		return true
+	})
+	base := ts.URL + "/api/traces/" + traceutil.TraceID(trace).String() + "/spans/"
+
+	var resp explanationResponse
+	if status := do(t, "GET", base+spanID+"/explain", "", &resp); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if resp.SpanID != spanID || resp.Service != service || resp.Explanation != "span explained" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	var errResp errorBody
+	if status := do(t, "GET", base+"0000000000000bad/explain", "", &errResp); status != http.StatusNotFound {
+		t.Fatalf("unknown span: got status %d", status)
+	}
+	if status := do(t, "GET", base+"zz/explain", "", &errResp); status != http.StatusBadRequest {
+		t.Fatalf("bad span ID: got status %d", status)
+	}
+}
+
+func TestServe_GracefulShutdown(t *testing.T) {
+	s := &Server{AI: &ai.AIQueryService{LLM: &stubLLM{}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, lis) }()
+
+	var health map[string]string
+	if status := do(t, "GET", "http://"+lis.Addr().String()+"/healthz", "", &health); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected shutdown error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not shut down")
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	traces, err := synthetic.LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatalf("load bench traces: %v", err)
+	}
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, slog.LevelInfo, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		AI: &ai.AIQueryService{
+			LLM:    &stubLLM{ir: ai.SearchIR{Service: strptr("payment-svc")}},
+			Query:  internal.NewQueryService(synthetic.NewSyntheticTraceReader(traces)),
+			Logger: logger,
+		},
+		Logger: logger,
+	}
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL+"/api/search", strings.NewReader(`{"query": "payment errors"}`))
+	req.Header.Set("X-Request-ID", "req-42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Request-ID"); got != "req-42" {
+		t.Fatalf("request ID not echoed, got %q", got)
+	}
+
+	msgs := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		if rec["request_id"] != "req-42" {
+			t.Fatalf("log line without the request ID: %s", line)
+		}
+		msgs[rec["msg"].(string)] = true
+	}
+	if !msgs["search"] || !msgs["http request"] {
+		t.Fatalf("expected search and http request lines, got:\n%s", buf.String())
+	}
+
+	// Without a header an ID is generated.
+	resp, err = http.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(resp.Header.Get("X-Request-ID")) != 16 {
+		t.Fatalf("expected a generated request ID, got %q", resp.Header.Get("X-Request-ID"))
+	}
+}
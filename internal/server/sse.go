@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// sseWriter writes Server-Sent Events, flushing after each one so the client
+// sees output as soon as it is produced.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+// newSSEWriter returns a writer when the request asks for an event stream and
+// the connection supports flushing.
+func newSSEWriter(w http.ResponseWriter, r *http.Request) (*sseWriter, bool) {
+	wants := r.URL.Query().Get("stream") == "true" ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if !wants {
+		return nil, false
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+	return &sseWriter{w: w, flusher: flusher}, true
+}
+
+// send writes one event whose data is v encoded as JSON, which keeps
+// newlines in model output from breaking the event framing.
+func (s *sseWriter) send(event string, v any) error {
+	if !s.started {
+		h := s.w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Connection", "keep-alive")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,50 @@
+package synthetic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// GroundTruth is the known root cause of a generated trace, for scoring
+// explanations against.
+type GroundTruth struct {
+	TraceID  string `json:"trace_id"`
+	Scenario string `json:"scenario"`
+	// Fault is the scenario's description of the failure mode.
+	Fault string `json:"fault,omitempty"`
+
+	// Service, Operation and SpanID identify the span the fault originates in.
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	SpanID    string `json:"span_id"`
+
+	// Error is the status message of that span when it failed; latency
+	// faults have none.
+	Error string `json:"error,omitempty"`
+}
+
+// WriteGroundTruthToFile writes truth as an indented JSON array.
+func WriteGroundTruthToFile(path string, truth []GroundTruth) error {
+	data, err := json.MarshalIndent(truth, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal ground truth: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write ground truth: %w", err)
+	}
+	return nil
+}
+
+// LoadGroundTruthFromFile reads a file written by WriteGroundTruthToFile.
+func LoadGroundTruthFromFile(path string) ([]GroundTruth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	var truth []GroundTruth
+	if err := json.Unmarshal(data, &truth); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ground truth: %w", err)
+	}
+	return truth, nil
+}
@@ -0,0 +1,56 @@
+package synthetic
+
+import (
+	_ "embed"
+
+	"gopkg.in/yaml.v3"
+)
+
+// libraryYAML holds the built-in scenarios, one per failure mode.
+//
+//go:embed library.yaml
+var libraryYAML []byte
+
+var library = mustLoadLibrary()
+
+func mustLoadLibrary() []Scenario {
+	var cfg Config
+	if err := yaml.Unmarshal(libraryYAML, &cfg); err != nil {
+		panic("synthetic: library.yaml: " + err.Error())
+	}
+	return cfg.Scenarios
+}
+
+// Library returns the built-in scenarios: retry_storm, n_plus_one,
+// timeout_cascade, slow_shard, queue_backlog, cache_miss_burst and
+// clock_skew. Each marks its RootCause call, so the traces it produces come
+// with ground truth.
+func Library() []Scenario {
+	return append([]Scenario(nil), library...)
+}
+
+// LibraryNames returns the names of the built-in scenarios.
+func LibraryNames() []string {
+	names := make([]string, len(library))
+	for i, s := range library {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// LibraryScenario returns the built-in scenario called name.
+func LibraryScenario(name string) (Scenario, bool) {
+	for _, s := range library {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// LibraryConfig mixes every built-in scenario with equal weight.
+func LibraryConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Scenarios = Library()
+	return cfg
+}
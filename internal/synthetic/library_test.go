@@ -0,0 +1,216 @@
+package synthetic
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+func generateLibrary(t *testing.T, name string, n int) ([]*traceutil.Tree, []GroundTruth) {
+	t.Helper()
+	cfg := Config{Seed: 3, Scenarios: []Scenario{{Use: name}}}
+	traces, truth, err := GenerateLabeled(cfg, n)
+	if err != nil {
+		t.Fatalf("generate %s: %v", name, err)
+	}
+	if len(truth) != n {
+		t.Fatalf("%s: %d of %d traces labeled", name, len(truth), n)
+	}
+	trees := make([]*traceutil.Tree, n)
+	for i, tr := range traces {
+		trees[i] = traceutil.BuildTree(tr)
+		got := truth[i]
+		if got.TraceID != traceutil.TraceID(tr).String() || got.Scenario != name || got.Fault == "" {
+			t.Fatalf("%s: ground truth %+v does not describe trace %d", name, got, i)
+		}
+		id, err := traceutil.ParseSpanID(got.SpanID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		span, res, ok := traceutil.FindSpan(tr, id)
+		if !ok || span.Name() != got.Operation || traceutil.ServiceName(res, span) != got.Service {
+			t.Fatalf("%s: ground truth span %s %s %s is not in the trace", name, got.Service, got.Operation, got.SpanID)
+		}
+	}
+	return trees, truth
+}
+
+// spans returns the nodes of tree for service and operation, in walk order.
+func spans(tree *traceutil.Tree, service, operation string) []*traceutil.Node {
+	var out []*traceutil.Node
+	tree.Walk(func(n *traceutil.Node) bool {
+		if traceutil.ServiceName(n.Resource, n.Span) == service && n.Span.Name() == operation {
+			out = append(out, n)
+		}
+		return true
+	})
+	return out
+}
+
+func TestLibrary_Scenarios(t *testing.T) {
+	checks := map[string]func(t *testing.T, tree *traceutil.Tree, truth GroundTruth){
+		"retry_storm": func(t *testing.T, tree *traceutil.Tree, truth GroundTruth) {
+			attempts := spans(tree, "inventory-svc", "Reserve")
+			if len(attempts) != 5 {
+				t.Fatalf("got %d attempts, want 5", len(attempts))
+			}
+			for _, a := range attempts {
+				if !traceutil.IsError(a.Span) {
+					t.Fatalf("attempt did not fail")
+				}
+			}
+			if truth.SpanID != attempts[0].Span.SpanID().String() || !strings.Contains(truth.Error, "resource exhausted") {
+				t.Fatalf("ground truth %+v, want the first attempt", truth)
+			}
+			if root := tree.Roots[0].Span; root.Status().Message() != "inventory unavailable" {
+				t.Fatalf("root status %q", root.Status().Message())
+			}
+		},
+		"n_plus_one": func(t *testing.T, tree *traceutil.Tree, _ GroundTruth) {
+			if n := len(spans(tree, "orders-db", "SELECT order_lines")); n != 40 {
+				t.Fatalf("got %d line queries, want 40", n)
+			}
+		},
+		"timeout_cascade": func(t *testing.T, tree *traceutil.Tree, _ GroundTruth) {
+			price := spans(tree, "pricing-svc", "GetPrice")[0]
+			if price.Duration() != 800*time.Millisecond || price.Span.Status().Message() != "context deadline exceeded" {
+				t.Fatalf("GetPrice took %v with status %q, want an 800ms timeout", price.Duration(), price.Span.Status().Message())
+			}
+			db := spans(tree, "pricing-db", "SELECT prices")[0]
+			if db.Span.EndTimestamp() <= price.Span.EndTimestamp() {
+				t.Fatalf("the slow query does not outlive the timed out call")
+			}
+			if root := tree.Roots[0].Span; !traceutil.IsError(root) {
+				t.Fatalf("the timeout did not cascade to the root")
+			}
+		},
+		"slow_shard": func(t *testing.T, tree *traceutil.Tree, truth GroundTruth) {
+			var onPath []string
+			for _, c := range tree.CriticalPath() {
+				onPath = append(onPath, traceutil.ServiceName(c.Node.Resource, c.Node.Span))
+			}
+			if !strings.Contains(strings.Join(onPath, ","), truth.Service) {
+				t.Fatalf("critical path %v misses %s", onPath, truth.Service)
+			}
+		},
+		"queue_backlog": func(t *testing.T, tree *traceutil.Tree, _ GroundTruth) {
+			publish := spans(tree, "orders-svc", "orders publish")[0]
+			process := spans(tree, "orders-worker", "orders process")[0]
+			wait := time.Duration(process.Span.StartTimestamp() - publish.Span.StartTimestamp())
+			if process.Parent != publish || process.Span.Kind() != ptrace.SpanKindConsumer || wait < 20*time.Second {
+				t.Fatalf("consumer waited %v under %v", wait, process.Parent.Span.Name())
+			}
+		},
+		"cache_miss_burst": func(t *testing.T, tree *traceutil.Tree, _ GroundTruth) {
+			gets := spans(tree, "redis", "GET")
+			if len(gets) != 20 {
+				t.Fatalf("got %d cache lookups, want 20", len(gets))
+			}
+			for _, g := range gets {
+				if v, _ := g.Span.Attributes().Get("cache.hit"); v.Bool() {
+					t.Fatalf("cache lookup hit")
+				}
+			}
+			if n := len(spans(tree, "catalog-db", "SELECT products")); n != 20 {
+				t.Fatalf("got %d fallback queries, want 20", n)
+			}
+		},
+		"clock_skew": func(t *testing.T, tree *traceutil.Tree, truth GroundTruth) {
+			stock := spans(tree, truth.Service, truth.Operation)[0]
+			if skew := stock.ClockSkew(); skew < 150*time.Millisecond {
+				t.Fatalf("clock skew %v, want about 200ms", skew)
+			}
+		},
+	}
+
+	if got, want := len(checks), len(LibraryNames()); got != want {
+		t.Fatalf("%d checks for %d library scenarios", got, want)
+	}
+	for _, name := range LibraryNames() {
+		t.Run(name, func(t *testing.T) {
+			check, ok := checks[name]
+			if !ok {
+				t.Fatalf("no check for %s", name)
+			}
+			trees, truth := generateLibrary(t, name, 5)
+			for i, tree := range trees {
+				check(t, tree, truth[i])
+			}
+		})
+	}
+}
+
+func TestGenerateLabeled_Mix(t *testing.T) {
+	cfg := Config{Seed: 1, Scenarios: []Scenario{
+		{Name: "slow", Use: "slow_shard", Weight: 2},
+		{Name: "healthy", Root: Call{Service: "api", Operation: "GET /", Latency: FixedLatency(time.Millisecond)}},
+		{Name: "flaky", Root: Call{Service: "api", Operation: "GET /", ErrorRate: 0.5, RootCause: true, Error: Fault{Message: "boom"}}},
+	}}
+	traces, truth, err := GenerateLabeled(cfg, 40)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(traces) != 40 {
+		t.Fatalf("got %d traces", len(traces))
+	}
+
+	counts := map[string]int{}
+	for _, gt := range truth {
+		counts[gt.Scenario]++
+		if gt.Scenario == "flaky" && gt.Error == "" {
+			t.Fatalf("flaky trace labeled without failing")
+		}
+	}
+	// Ten rotations of slow, slow, healthy, flaky.
+	if counts["slow"] != 20 || counts["healthy"] != 0 {
+		t.Fatalf("labels per scenario %v", counts)
+	}
+	if counts["flaky"] == 0 || counts["flaky"] == 10 {
+		t.Fatalf("%d of 10 flaky traces labeled, want only the failed ones", counts["flaky"])
+	}
+
+	if _, _, err := GenerateLabeled(Config{Scenarios: []Scenario{{Use: "meltdown"}}}, 1); err == nil ||
+		!strings.Contains(err.Error(), "retry_storm") {
+		t.Fatalf("got %v, want an error listing the library", err)
+	}
+}
+
+func TestGroundTruth_Bench(t *testing.T) {
+	_, truth, err := GenerateLabeled(DefaultConfig(), 100)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "labels.json")
+	if err := WriteGroundTruthToFile(path, truth); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got, err := LoadGroundTruthFromFile(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(got, truth) {
+		t.Fatalf("round trip changed the ground truth")
+	}
+
+	bench, err := LoadGroundTruthFromFile("../../traces_bench.labels.json")
+	if err != nil {
+		t.Fatalf("load bench labels: %v", err)
+	}
+	if !reflect.DeepEqual(bench, truth) {
+		t.Fatalf("traces_bench.labels.json is stale; regenerate it with -generate")
+	}
+	if len(truth) != 34 {
+		t.Fatalf("got %d labeled traces, want the 34 checkouts", len(truth))
+	}
+	for _, gt := range truth {
+		if gt.Service != "payment-svc" || gt.Operation != "Authorize" || gt.Error != "insufficient_funds" {
+			t.Fatalf("bench ground truth %+v", gt)
+		}
+	}
+}
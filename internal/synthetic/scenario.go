@@ -0,0 +1,250 @@
+package synthetic
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a set of synthetic traces. Generate turns the same Config
+// into the same traces, byte for byte.
+type Config struct {
+	// Seed drives every random choice: span IDs, latencies, injected errors
+	// and attribute templates.
+	Seed int64 `yaml:"seed"`
+
+	// Start is when the first trace starts; Interval separates trace starts.
+	// Zero values mean 2024-01-01T12:00:00Z and one second.
+	Start    time.Time     `yaml:"start"`
+	Interval time.Duration `yaml:"interval"`
+
+	// Scenarios are used in turn: each one contributes Weight consecutive
+	// traces before the next one takes over.
+	Scenarios []Scenario `yaml:"scenarios"`
+}
+
+// Scenario is one kind of request flowing through the system.
+type Scenario struct {
+	Name string `yaml:"name"`
+
+	// Use names a scenario of the built-in Library to use instead of Root;
+	// Name and Weight still apply when set.
+	Use string `yaml:"use"`
+
+	// Fault describes the failure mode the scenario reproduces, e.g.
+	// "orders-svc queries orders-db once per order line". It becomes part of
+	// the ground truth of traces that reach a RootCause call.
+	Fault string `yaml:"fault"`
+
+	// Weight is how many traces of this scenario each rotation produces;
+	// zero means one.
+	Weight int `yaml:"weight"`
+
+	// Services holds extra resource attributes per service name, e.g.
+	// service.version or k8s.pod.name. service.name is always set.
+	Services map[string]map[string]any `yaml:"services"`
+
+	// Root is the entry call of the trace.
+	Root Call `yaml:"root"`
+}
+
+// Call is one span and, through Calls, the spans it makes.
+type Call struct {
+	Service   string `yaml:"service"`
+	Operation string `yaml:"operation"`
+
+	// Kind is server, client, internal, producer or consumer; empty means
+	// server for the root and client otherwise.
+	Kind string `yaml:"kind"`
+
+	// Delay is the gap before the call starts: after the parent's start when
+	// the parent is Parallel or this is its first call, else after the
+	// previous call ended.
+	Delay Latency `yaml:"delay"`
+
+	// Latency is the span's own duration. A span never ends before its
+	// synchronous calls do.
+	Latency Latency `yaml:"latency"`
+
+	// Repeat makes the call this many times in a row, as in an N+1 query;
+	// zero means once.
+	Repeat int `yaml:"repeat"`
+
+	// Parallel starts all of Calls at once instead of one after another.
+	Parallel bool `yaml:"parallel"`
+
+	// Async calls do not hold up their parent, which may end first.
+	Async bool `yaml:"async"`
+
+	// Timeout ends the span once it has run this long and fails it, while
+	// the calls it was waiting on keep running. Zero means no timeout.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Skew shifts the span's timestamps, as if its host's clock were off,
+	// without changing when anything actually ran.
+	Skew time.Duration `yaml:"skew"`
+
+	// Attributes are span attributes. String values are Go templates, see
+	// Generate; other values are stored with their YAML type.
+	Attributes map[string]any `yaml:"attributes"`
+
+	// ErrorRate is the probability, 0 to 1, that the call fails.
+	ErrorRate float64 `yaml:"error_rate"`
+
+	// Propagate fails the call whenever one of its calls fails.
+	Propagate bool `yaml:"propagate"`
+
+	// Error describes how a failing call looks. A call that times out
+	// without a message gets "context deadline exceeded".
+	Error Fault `yaml:"error"`
+
+	// RootCause marks the call the scenario's fault originates in. The first
+	// such span of a trace is its ground truth; with an ErrorRate, only once
+	// the call actually fails.
+	RootCause bool `yaml:"root_cause"`
+
+	Calls []Call `yaml:"calls"`
+}
+
+// Fault is what an injected or propagated failure sets on a span: an error
+// status with Message, an exception event when Exception is set, and
+// Attributes over the call's own.
+type Fault struct {
+	Message    string         `yaml:"message"`
+	Exception  string         `yaml:"exception"`
+	Attributes map[string]any `yaml:"attributes"`
+}
+
+// Latency distributions.
+const (
+	Fixed     = "fixed"
+	Uniform   = "uniform"
+	Normal    = "normal"
+	LogNormal = "lognormal"
+)
+
+// Latency is a duration distribution. Fixed always gives Mean; Uniform draws
+// from [Min, Max]; Normal and LogNormal draw around Mean with StdDev. Draws
+// are clamped to [Min, Max] when Max is set, and never go below zero.
+//
+// In YAML a plain duration such as "50ms" is a fixed latency.
+type Latency struct {
+	Dist   string        `yaml:"dist"`
+	Mean   time.Duration `yaml:"mean"`
+	StdDev time.Duration `yaml:"stddev"`
+	Min    time.Duration `yaml:"min"`
+	Max    time.Duration `yaml:"max"`
+}
+
+// FixedLatency returns a latency that is always d.
+func FixedLatency(d time.Duration) Latency {
+	return Latency{Mean: d}
+}
+
+// UnmarshalYAML accepts a plain duration as well as the full mapping.
+func (l *Latency) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		d, err := time.ParseDuration(n.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: latency: %w", n.Line, err)
+		}
+		*l = FixedLatency(d)
+		return nil
+	}
+	type plain Latency
+	return n.Decode((*plain)(l))
+}
+
+func (l Latency) validate() error {
+	switch l.Dist {
+	case "", Fixed, Normal, LogNormal:
+	case Uniform:
+		if l.Max < l.Min {
+			return fmt.Errorf("uniform latency: max %v is below min %v", l.Max, l.Min)
+		}
+	default:
+		return fmt.Errorf("unknown latency distribution %q, want fixed, uniform, normal or lognormal", l.Dist)
+	}
+	if l.Mean < 0 || l.StdDev < 0 || l.Min < 0 || l.Max < 0 {
+		return fmt.Errorf("latency durations cannot be negative")
+	}
+	return nil
+}
+
+var spanKinds = map[string]ptrace.SpanKind{
+	"server":   ptrace.SpanKindServer,
+	"client":   ptrace.SpanKindClient,
+	"internal": ptrace.SpanKindInternal,
+	"producer": ptrace.SpanKindProducer,
+	"consumer": ptrace.SpanKindConsumer,
+}
+
+// LoadConfig reads a scenario file in YAML.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse scenario file: %w", err)
+	}
+	return cfg, nil
+}
+
+// DefaultConfig reproduces traces_bench.json: a checkout that fails in
+// payment-svc, a product search and a catalog listing with a slow query,
+// one second apart from 2024-01-01T12:00:00Z.
+func DefaultConfig() Config {
+	ms := func(n int) Latency { return FixedLatency(time.Duration(n) * time.Millisecond) }
+	return Config{
+		Seed:     42,
+		Start:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Interval: time.Second,
+		Scenarios: []Scenario{
+			{
+				Name:  "checkout",
+				Fault: "payment-svc declines the card for insufficient funds",
+				Root: Call{
+					Service: "frontend", Operation: "POST /checkout", Latency: ms(300),
+					Attributes: map[string]any{"http.status_code": 402},
+					Calls: []Call{{
+						Service: "payment-svc", Operation: "Authorize", Delay: ms(50), Latency: ms(150),
+						Attributes: map[string]any{"http.status_code": 402},
+						ErrorRate:  1,
+						Error:      Fault{Message: "insufficient_funds"},
+						RootCause:  true,
+					}},
+				},
+			},
+			{
+				Name: "search",
+				Root: Call{
+					Service: "frontend", Operation: "GET /search", Latency: ms(50),
+					Attributes: map[string]any{"http.method": "GET", "http.status_code": 200},
+					Calls: []Call{{
+						Service: "search-db", Operation: "SELECT products", Delay: ms(5), Latency: ms(20),
+						Attributes: map[string]any{"db.system": "postgres"},
+					}},
+				},
+			},
+			{
+				Name: "catalog",
+				Root: Call{
+					Service: "frontend", Operation: "GET /items", Latency: ms(100),
+					Attributes: map[string]any{"http.method": "GET", "http.status_code": 200},
+					Calls: []Call{{
+						Service: "catalog-svc", Operation: "GetItems", Kind: "server", Delay: ms(10), Latency: ms(80),
+						Calls: []Call{{
+							Service: "catalog-db", Operation: "FETCH", Delay: ms(10), Latency: ms(60),
+							Attributes: map[string]any{"slow_query": true},
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
@@ -1,10 +1,18 @@
 package synthetic
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/rand"
 	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
 
+	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 )
 
@@ -35,117 +43,440 @@ func LoadTracesFromFile(path string) ([]ptrace.Traces, error) {
 	return out, nil
 }
 
-// import (
-// 	"encoding/json"
-// 	"math/rand"
-// 	"os"
-// 	"time"
-
-// 	"go.opentelemetry.io/collector/pdata/pcommon"
-// 	"go.opentelemetry.io/collector/pdata/ptrace"
-// )
-
-// func main() {
-// 	// Fixed seed for reproducibility
-// 	r := rand.New(rand.NewSource(42))
-// 	count := 100 // Total number of traces to generate
-
-// 	marshaler := ptrace.JSONMarshaler{}
-// 	var allTraces []interface{}
-
-// 	baseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
-
-// 	for i := 0; i < count; i++ {
-// 		td := ptrace.NewTraces()
-// 		tid := fixedTraceID(i + 1)
-
-// 		// Rotate through 3 stories
-// 		switch i % 3 {
-// 		case 0:
-// 			generateCheckoutStory(td, tid, baseTime.Add(time.Duration(i)*time.Second), r)
-// 		case 1:
-// 			generateSearchStory(td, tid, baseTime.Add(time.Duration(i)*time.Second), r)
-// 		case 2:
-// 			generateCatalogStory(td, tid, baseTime.Add(time.Duration(i)*time.Second), r)
-// 		}
-
-// 		// Convert to raw JSON map for storage
-// 		buf, _ := marshaler.MarshalTraces(td)
-// 		var raw map[string]interface{}
-// 		json.Unmarshal(buf, &raw)
-// 		allTraces = append(allTraces, raw)
-// 	}
-
-// 	f, _ := os.Create("traces_bench.json")
-// 	encoder := json.NewEncoder(f)
-// 	encoder.SetIndent("", "  ")
-// 	encoder.Encode(allTraces)
-// }
-
-// // --- Story Logic ---
-
-// func generateCheckoutStory(td ptrace.Traces, tid pcommon.TraceID, start time.Time, r *rand.Rand) {
-// 	fe := addSpan(td, "frontend", "POST /checkout", tid, pcommon.SpanID{}, ptrace.SpanKindServer, start, 300)
-// 	fe.Attributes().PutInt("http.status_code", 402)
-
-// 	pay := addSpan(td, "payment-svc", "Authorize", tid, fe.SpanID(), ptrace.SpanKindClient, start.Add(50*time.Millisecond), 150)
-// 	pay.Status().SetCode(ptrace.StatusCodeError)
-// 	pay.Status().SetMessage("insufficient_funds")
-// 	pay.Attributes().PutInt("http.status_code", 402)
-// }
-
-// func generateSearchStory(td ptrace.Traces, tid pcommon.TraceID, start time.Time, r *rand.Rand) {
-// 	fe := addSpan(td, "frontend", "GET /search", tid, pcommon.SpanID{}, ptrace.SpanKindServer, start, 50)
-// 	setHTTP(fe, "GET", 200)
-
-// 	db := addSpan(td, "search-db", "SELECT products", tid, fe.SpanID(), ptrace.SpanKindClient, start.Add(5*time.Millisecond), 20)
-// 	db.Attributes().PutStr("db.system", "postgres")
-// }
-
-// func generateCatalogStory(td ptrace.Traces, tid pcommon.TraceID, start time.Time, r *rand.Rand) {
-// 	fe := addSpan(td, "frontend", "GET /items", tid, pcommon.SpanID{}, ptrace.SpanKindServer, start, 100)
-// 	setHTTP(fe, "GET", 200)
-
-// 	cat := addSpan(td, "catalog-svc", "GetItems", tid, fe.SpanID(), ptrace.SpanKindServer, start.Add(10*time.Millisecond), 80)
-
-// 	// Simulate a slow DB call in the catalog
-// 	db := addSpan(td, "catalog-db", "FETCH", tid, cat.SpanID(), ptrace.SpanKindClient, start.Add(20*time.Millisecond), 60)
-// 	db.Attributes().PutBool("slow_query", true)
-// }
-
-// // --- Helpers ---
-
-// func addSpan(t ptrace.Traces, svc, name string, tid pcommon.TraceID, pid pcommon.SpanID, kind ptrace.SpanKind, start time.Time, durMs int) ptrace.Span {
-// 	rs := t.ResourceSpans().AppendEmpty()
-// 	rs.Resource().Attributes().PutStr("service.name", svc)
-// 	s := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
-// 	s.SetTraceID(tid)
-// 	s.SetSpanID(fixedSpanID(uint64(rand.Int63())))
-// 	if !pid.IsEmpty() {
-// 		s.SetParentSpanID(pid)
-// 	}
-// 	s.SetName(name)
-// 	s.SetKind(kind)
-// 	s.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
-// 	s.SetEndTimestamp(pcommon.NewTimestampFromTime(start.Add(time.Duration(durMs) * time.Millisecond)))
-// 	return s
-// }
-
-// func setHTTP(s ptrace.Span, method string, code int) {
-// 	s.Attributes().PutStr("http.method", method)
-// 	s.Attributes().PutInt("http.status_code", int64(code))
-// }
-
-// func fixedTraceID(i int) pcommon.TraceID {
-// 	var b [16]byte
-// 	b[15] = byte(i)
-// 	return pcommon.TraceID(b)
-// }
-
-// func fixedSpanID(i uint64) pcommon.SpanID {
-// 	var b [8]byte
-// 	for j := 0; j < 8; j++ {
-// 		b[j] = byte(i >> (j * 8))
-// 	}
-// 	return pcommon.SpanID(b)
-// }
+// WriteTracesToFile writes traces in the format LoadTracesFromFile reads: an
+// indented JSON array of OTLP JSON trace objects.
+func WriteTracesToFile(path string, traces []ptrace.Traces) error {
+	marshaler := &ptrace.JSONMarshaler{}
+	all := make([]any, 0, len(traces))
+	for i, t := range traces {
+		buf, err := marshaler.MarshalTraces(t)
+		if err != nil {
+			return fmt.Errorf("failed to marshal trace at index %d: %w", i, err)
+		}
+		// Decoding into a map sorts the keys, which keeps the file stable.
+		var raw map[string]any
+		if err := json.Unmarshal(buf, &raw); err != nil {
+			return fmt.Errorf("failed to marshal trace at index %d: %w", i, err)
+		}
+		all = append(all, raw)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(all); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write traces: %w", err)
+	}
+	return f.Close()
+}
+
+// GenerateTraces returns the first n traces of DefaultConfig, the traces of
+// traces_bench.json.
+func GenerateTraces(n int) []ptrace.Traces {
+	traces, err := Generate(DefaultConfig(), n)
+	if err != nil {
+		panic("synthetic: default config: " + err.Error())
+	}
+	return traces
+}
+
+// Generate builds n traces from cfg. Trace IDs count up from 1; span IDs and
+// every random choice come from cfg.Seed, so the same cfg always gives the
+// same traces.
+//
+// The ground truth of the traces is dropped; see GenerateLabeled.
+//
+// String attribute values, including resource attributes, are Go templates
+// with these fields and functions:
+//
+//	.Trace, .TraceID       the trace's index (from 0) and hex ID
+//	.Scenario              the scenario name
+//	.Service, .Operation   the call, for span attributes
+//	rand MIN MAX           a random integer in [MIN, MAX]
+//	pick A B ...           one of its arguments at random
+//	hex N                  N random hex digits
+//
+// so "user-{{rand 1 500}}" gives a different user per call.
+func Generate(cfg Config, n int) ([]ptrace.Traces, error) {
+	traces, _, err := GenerateLabeled(cfg, n)
+	return traces, err
+}
+
+// GenerateLabeled is Generate that also returns the ground truth of every
+// trace that reached a RootCause call, in trace order.
+func GenerateLabeled(cfg Config, n int) ([]ptrace.Traces, []GroundTruth, error) {
+	if len(cfg.Scenarios) == 0 {
+		return nil, nil, fmt.Errorf("no scenarios")
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = DefaultConfig().Start
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = time.Second
+	}
+
+	g := &generator{r: rand.New(rand.NewSource(cfg.Seed)), templates: map[string]*template.Template{}}
+	var rotation []*Scenario
+	for _, s := range cfg.Scenarios {
+		s, err := resolve(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := g.compileScenario(s); err != nil {
+			return nil, nil, fmt.Errorf("scenario %q: %w", s.Name, err)
+		}
+		for w := max(s.Weight, 1); w > 0; w-- {
+			rotation = append(rotation, s)
+		}
+	}
+
+	out := make([]ptrace.Traces, 0, n)
+	var truth []GroundTruth
+	for i := 0; i < n; i++ {
+		start := cfg.Start.Add(time.Duration(i) * cfg.Interval)
+		s := rotation[i%len(rotation)]
+		out = append(out, g.trace(s, i, start))
+		if g.err != nil {
+			return nil, nil, fmt.Errorf("scenario %q: %w", s.Name, g.err)
+		}
+		if g.truth != nil {
+			truth = append(truth, *g.truth)
+		}
+	}
+	return out, truth, nil
+}
+
+// resolve returns s, or the Library scenario it uses.
+func resolve(s Scenario) (*Scenario, error) {
+	if s.Use == "" {
+		return &s, nil
+	}
+	lib, ok := LibraryScenario(s.Use)
+	if !ok {
+		return nil, fmt.Errorf("scenario %q: unknown library scenario %q, want one of %s",
+			s.Name, s.Use, strings.Join(LibraryNames(), ", "))
+	}
+	if s.Name != "" {
+		lib.Name = s.Name
+	}
+	if s.Weight != 0 {
+		lib.Weight = s.Weight
+	}
+	return &lib, nil
+}
+
+type generator struct {
+	r         *rand.Rand
+	templates map[string]*template.Template
+
+	// Per trace.
+	td    ptrace.Traces
+	spans map[string]ptrace.SpanSlice
+	tid   pcommon.TraceID
+	data  templateData
+	scene *Scenario
+	truth *GroundTruth
+	// err is the first template that failed to execute.
+	err error
+}
+
+type templateData struct {
+	Trace     int
+	TraceID   string
+	Scenario  string
+	Service   string
+	Operation string
+}
+
+func (g *generator) compileScenario(s *Scenario) error {
+	if s.Weight < 0 {
+		return fmt.Errorf("weight cannot be negative")
+	}
+	for svc, attrs := range s.Services {
+		if err := g.compileAttrs(attrs); err != nil {
+			return fmt.Errorf("service %s: %w", svc, err)
+		}
+	}
+	return g.compileCall(&s.Root)
+}
+
+func (g *generator) compileCall(c *Call) error {
+	if c.Service == "" || c.Operation == "" {
+		return fmt.Errorf("call %q/%q: service and operation are required", c.Service, c.Operation)
+	}
+	where := func(err error) error {
+		return fmt.Errorf("call %s %s: %w", c.Service, c.Operation, err)
+	}
+	if _, ok := spanKinds[c.Kind]; !ok && c.Kind != "" {
+		return where(fmt.Errorf("unknown span kind %q", c.Kind))
+	}
+	if err := c.Delay.validate(); err != nil {
+		return where(err)
+	}
+	if err := c.Latency.validate(); err != nil {
+		return where(err)
+	}
+	if c.Timeout < 0 {
+		return where(fmt.Errorf("timeout cannot be negative"))
+	}
+	if c.Repeat < 0 {
+		return where(fmt.Errorf("repeat cannot be negative"))
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return where(fmt.Errorf("error_rate %v is outside [0, 1]", c.ErrorRate))
+	}
+	if err := g.compileAttrs(c.Attributes); err != nil {
+		return where(err)
+	}
+	if err := g.compileAttrs(c.Error.Attributes); err != nil {
+		return where(err)
+	}
+	for i := range c.Calls {
+		if err := g.compileCall(&c.Calls[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileAttrs parses the templates among attrs and checks that every other
+// value can be stored.
+func (g *generator) compileAttrs(attrs map[string]any) error {
+	for k, v := range attrs {
+		s, ok := v.(string)
+		if !ok {
+			if err := pcommon.NewValueEmpty().FromRaw(v); err != nil {
+				return fmt.Errorf("attribute %s: %w", k, err)
+			}
+			continue
+		}
+		if _, done := g.templates[s]; done || !strings.Contains(s, "{{") {
+			continue
+		}
+		t, err := template.New(k).Option("missingkey=error").Funcs(g.funcs()).Parse(s)
+		if err != nil {
+			return fmt.Errorf("attribute %s: %w", k, err)
+		}
+		g.templates[s] = t
+	}
+	return nil
+}
+
+func (g *generator) funcs() template.FuncMap {
+	return template.FuncMap{
+		"rand": func(lo, hi int) (int, error) {
+			if hi < lo {
+				return 0, fmt.Errorf("rand %d %d: max is below min", lo, hi)
+			}
+			return lo + g.r.Intn(hi-lo+1), nil
+		},
+		"pick": func(choices ...any) (any, error) {
+			if len(choices) == 0 {
+				return nil, fmt.Errorf("pick needs at least one choice")
+			}
+			return choices[g.r.Intn(len(choices))], nil
+		},
+		"hex": func(n int) string {
+			const digits = "0123456789abcdef"
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = digits[g.r.Intn(16)]
+			}
+			return string(b)
+		},
+	}
+}
+
+func (g *generator) trace(s *Scenario, i int, start time.Time) ptrace.Traces {
+	g.td = ptrace.NewTraces()
+	g.spans = map[string]ptrace.SpanSlice{}
+	g.tid = traceID(i + 1)
+	g.scene = s
+	g.truth = nil
+	g.data = templateData{Trace: i, TraceID: g.tid.String(), Scenario: s.Name}
+	g.call(&s.Root, pcommon.SpanID{}, start, true)
+	return g.td
+}
+
+// call adds the span of c and of everything it calls, and returns when the
+// span ended and whether it failed.
+func (g *generator) call(c *Call, parent pcommon.SpanID, start time.Time, root bool) (time.Time, bool) {
+	span := g.scopeSpans(c.Service).AppendEmpty()
+	span.SetTraceID(g.tid)
+	span.SetSpanID(g.spanID())
+	if !parent.IsEmpty() {
+		span.SetParentSpanID(parent)
+	}
+	span.SetName(c.Operation)
+	span.SetKind(spanKind(c.Kind, root))
+
+	dur := g.sample(c.Latency)
+	failed := c.ErrorRate >= 1 || c.ErrorRate > 0 && g.r.Float64() < c.ErrorRate
+	g.data.Service, g.data.Operation = c.Service, c.Operation
+	g.putAttrs(span.Attributes(), c.Attributes)
+
+	end := start.Add(dur)
+	cursor := start
+	childFailed := false
+	for i := range c.Calls {
+		child := &c.Calls[i]
+		for n := max(child.Repeat, 1); n > 0; n-- {
+			childStart := cursor
+			if c.Parallel {
+				childStart = start
+			}
+			childStart = childStart.Add(g.sample(child.Delay))
+			childEnd, f := g.call(child, span.SpanID(), childStart, false)
+			childFailed = childFailed || f
+			if child.Async {
+				continue
+			}
+			if !c.Parallel {
+				cursor = childEnd
+			}
+			if childEnd.After(end) {
+				end = childEnd
+			}
+		}
+	}
+
+	message := c.Error.Message
+	if c.Timeout > 0 && end.Sub(start) > c.Timeout {
+		end = start.Add(c.Timeout)
+		failed = true
+		if message == "" {
+			message = "context deadline exceeded"
+		}
+	}
+
+	if failed || c.Propagate && childFailed {
+		failed = true
+		span.Status().SetCode(ptrace.StatusCodeError)
+		span.Status().SetMessage(message)
+		if c.Error.Exception != "" {
+			ev := span.Events().AppendEmpty()
+			ev.SetName("exception")
+			ev.SetTimestamp(pcommon.NewTimestampFromTime(end.Add(c.Skew)))
+			ev.Attributes().PutStr("exception.type", c.Error.Exception)
+			if message != "" {
+				ev.Attributes().PutStr("exception.message", message)
+			}
+		}
+		g.data.Service, g.data.Operation = c.Service, c.Operation
+		g.putAttrs(span.Attributes(), c.Error.Attributes)
+	}
+
+	if c.RootCause && g.truth == nil && (c.ErrorRate == 0 || failed) {
+		g.truth = &GroundTruth{
+			TraceID:   g.tid.String(),
+			Scenario:  g.scene.Name,
+			Fault:     g.scene.Fault,
+			Service:   c.Service,
+			Operation: c.Operation,
+			SpanID:    span.SpanID().String(),
+		}
+		if failed {
+			g.truth.Error = message
+		}
+	}
+
+	span.SetStartTimestamp(pcommon.NewTimestampFromTime(start.Add(c.Skew)))
+	span.SetEndTimestamp(pcommon.NewTimestampFromTime(end.Add(c.Skew)))
+	return end, failed
+}
+
+// scopeSpans returns the span list of service, adding its resource the first
+// time the trace reaches it.
+func (g *generator) scopeSpans(service string) ptrace.SpanSlice {
+	if spans, ok := g.spans[service]; ok {
+		return spans
+	}
+	rs := g.td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().PutStr("service.name", service)
+	g.data.Service, g.data.Operation = service, ""
+	g.putAttrs(rs.Resource().Attributes(), g.scene.Services[service])
+	spans := rs.ScopeSpans().AppendEmpty().Spans()
+	g.spans[service] = spans
+	return spans
+}
+
+// putAttrs stores attrs in sorted key order, so templates draw random values
+// in the same order every run.
+func (g *generator) putAttrs(m pcommon.Map, attrs map[string]any) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s, ok := attrs[k].(string)
+		if !ok {
+			// Checked by compileAttrs.
+			_ = m.PutEmpty(k).FromRaw(attrs[k])
+			continue
+		}
+		if t := g.templates[s]; t != nil {
+			var b strings.Builder
+			if err := t.Execute(&b, g.data); err != nil && g.err == nil {
+				g.err = err
+			}
+			s = b.String()
+		}
+		m.PutStr(k, s)
+	}
+}
+
+func (g *generator) sample(l Latency) time.Duration {
+	var d float64
+	switch l.Dist {
+	case "", Fixed:
+		return l.Mean
+	case Uniform:
+		d = float64(l.Min) + g.r.Float64()*float64(l.Max-l.Min)
+	case Normal:
+		d = float64(l.Mean) + g.r.NormFloat64()*float64(l.StdDev)
+	case LogNormal:
+		// Parameters of the underlying normal that give mean Mean and
+		// standard deviation StdDev.
+		m, s := float64(l.Mean), float64(l.StdDev)
+		if m <= 0 {
+			return 0
+		}
+		sigma := math.Sqrt(math.Log(1 + s*s/(m*m)))
+		mu := math.Log(m) - sigma*sigma/2
+		d = math.Exp(mu + sigma*g.r.NormFloat64())
+	}
+	if l.Max > 0 {
+		d = math.Min(d, float64(l.Max))
+	}
+	d = math.Max(d, float64(l.Min))
+	return time.Duration(d)
+}
+
+func (g *generator) spanID() pcommon.SpanID {
+	var id pcommon.SpanID
+	for id.IsEmpty() {
+		binary.BigEndian.PutUint64(id[:], g.r.Uint64())
+	}
+	return id
+}
+
+func spanKind(kind string, root bool) ptrace.SpanKind {
+	if k, ok := spanKinds[kind]; ok {
+		return k
+	}
+	if root {
+		return ptrace.SpanKindServer
+	}
+	return ptrace.SpanKindClient
+}
+
+func traceID(i int) pcommon.TraceID {
+	var id pcommon.TraceID
+	binary.BigEndian.PutUint64(id[8:], uint64(i))
+	return id
+}
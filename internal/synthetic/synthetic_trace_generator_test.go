@@ -0,0 +1,301 @@
+package synthetic
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+func TestGenerateTraces_ReproducesBench(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	if err := WriteTracesToFile(path, GenerateTraces(100)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("generated traces differ from traces_bench.json; regenerate it with -generate")
+	}
+}
+
+const testScenarios = `
+seed: 7
+start: 2025-03-01T00:00:00Z
+interval: 10s
+scenarios:
+  - name: order
+    weight: 2
+    services:
+      api:
+        service.version: "1.{{rand 0 9}}"
+    root:
+      service: api
+      operation: POST /orders
+      latency: 10ms
+      propagate: true
+      error:
+        message: upstream failed
+        attributes:
+          http.status_code: 500
+      attributes:
+        http.status_code: 200
+        user.id: 'user-{{.Trace}}'
+      calls:
+        - service: db
+          operation: SELECT item
+          repeat: 3
+          delay: 1ms
+          latency: {dist: uniform, min: 2ms, max: 4ms}
+        - service: stock
+          operation: Reserve
+          parallel: true
+          latency: 5ms
+          error_rate: 1
+          error: {message: out of stock, exception: StockError}
+          calls:
+            - {service: cache, operation: GET, latency: 1ms}
+            - {service: cache, operation: GET, latency: 3ms}
+        - service: mailer
+          operation: Send
+          kind: producer
+          async: true
+          latency: 1s
+  - name: health
+    root: {service: api, operation: GET /health, latency: 1ms}
+`
+
+func loadTestConfig(t *testing.T) Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scenarios.yaml")
+	if err := os.WriteFile(path, []byte(testScenarios), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return cfg
+}
+
+func TestGenerate_Scenario(t *testing.T) {
+	traces, err := Generate(loadTestConfig(t), 3)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	// Weight 2: order, order, health.
+	if root, _, _ := traceutil.RootSpan(traces[2]); root.Name() != "GET /health" {
+		t.Fatalf("third trace is %q, want the health scenario", root.Name())
+	}
+	if got := traceutil.TraceID(traces[1]).String(); got != "00000000000000000000000000000002" {
+		t.Fatalf("second trace ID %s", got)
+	}
+
+	tree := traceutil.BuildTree(traces[1])
+	if len(tree.Roots) != 1 || len(tree.ByID) != 8 {
+		t.Fatalf("got %d roots and %d spans, want 1 and 8", len(tree.Roots), len(tree.ByID))
+	}
+	root := tree.Roots[0]
+	start := time.Date(2025, 3, 1, 0, 0, 10, 0, time.UTC)
+	if got := root.Span.StartTimestamp().AsTime(); !got.Equal(start) {
+		t.Fatalf("root starts at %v, want %v", got, start)
+	}
+
+	names := make([]string, len(root.Children))
+	for i, c := range root.Children {
+		names[i] = c.Span.Name()
+	}
+	if got := strings.Join(names, ","); got != "SELECT item,SELECT item,SELECT item,Reserve,Send" {
+		t.Fatalf("children %s", got)
+	}
+
+	// The selects run one after another, each 1ms after the previous one.
+	prevEnd := root.Span.StartTimestamp()
+	for _, c := range root.Children[:3] {
+		d := c.Duration()
+		if d < 2*time.Millisecond || d > 4*time.Millisecond {
+			t.Fatalf("select took %v, want within [2ms, 4ms]", d)
+		}
+		if gap := time.Duration(c.Span.StartTimestamp() - prevEnd); gap != time.Millisecond {
+			t.Fatalf("select starts %v after the previous call, want 1ms", gap)
+		}
+		prevEnd = c.Span.EndTimestamp()
+	}
+
+	// Parallel cache calls start together, so Reserve lasts 5ms.
+	reserve := root.Children[3]
+	for _, c := range reserve.Children {
+		if c.Span.StartTimestamp() != reserve.Span.StartTimestamp() {
+			t.Fatalf("parallel call does not start with its parent")
+		}
+	}
+	if d := reserve.Duration(); d != 5*time.Millisecond {
+		t.Fatalf("Reserve took %v, want 5ms", d)
+	}
+	if reserve.Span.Status().Code() != ptrace.StatusCodeError || reserve.Span.Status().Message() != "out of stock" {
+		t.Fatalf("Reserve status %v %q", reserve.Span.Status().Code(), reserve.Span.Status().Message())
+	}
+	ev := reserve.Span.Events()
+	if ev.Len() != 1 || ev.At(0).Name() != "exception" {
+		t.Fatalf("Reserve has no exception event")
+	}
+	if v, _ := ev.At(0).Attributes().Get("exception.type"); v.AsString() != "StockError" {
+		t.Fatalf("exception.type %q", v.AsString())
+	}
+
+	// The async mailer outlives the root, which waits only for Reserve.
+	send := root.Children[4]
+	if send.Span.Kind() != ptrace.SpanKindProducer {
+		t.Fatalf("Send kind %v", send.Span.Kind())
+	}
+	if root.Span.EndTimestamp() != reserve.Span.EndTimestamp() {
+		t.Fatalf("root does not end with its last synchronous call")
+	}
+	if send.Span.EndTimestamp() <= root.Span.EndTimestamp() {
+		t.Fatalf("async call does not outlive its parent")
+	}
+
+	// The root propagates the failure with its own fault.
+	if root.Span.Status().Code() != ptrace.StatusCodeError || root.Span.Status().Message() != "upstream failed" {
+		t.Fatalf("root status %v %q", root.Span.Status().Code(), root.Span.Status().Message())
+	}
+	attrs := root.Span.Attributes()
+	if v, _ := attrs.Get("http.status_code"); v.Int() != 500 {
+		t.Fatalf("http.status_code %v, want the fault's 500", v.AsRaw())
+	}
+	if v, _ := attrs.Get("user.id"); v.Str() != "user-1" {
+		t.Fatalf("user.id %q", v.Str())
+	}
+	if v, ok := root.Resource.Attributes().Get("service.version"); !ok || !strings.HasPrefix(v.Str(), "1.") {
+		t.Fatalf("service.version %q", v.Str())
+	}
+
+	// Spans of one service share a resource.
+	if n := traces[1].ResourceSpans().Len(); n != 5 {
+		t.Fatalf("got %d resources, want one per service", n)
+	}
+}
+
+func TestGenerate_Deterministic(t *testing.T) {
+	cfg := loadTestConfig(t)
+	cfg.Scenarios[0].Root.Calls[0].Latency = Latency{Dist: LogNormal, Mean: 3 * time.Millisecond, StdDev: 2 * time.Millisecond}
+	cfg.Scenarios[0].Root.Calls[1].ErrorRate = 0.5
+
+	marshal := func(seed int64) []byte {
+		t.Helper()
+		cfg.Seed = seed
+		traces, err := Generate(cfg, 20)
+		if err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+		var b bytes.Buffer
+		for _, tr := range traces {
+			buf, err := (&ptrace.JSONMarshaler{}).MarshalTraces(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Write(buf)
+		}
+		return b.Bytes()
+	}
+
+	if !bytes.Equal(marshal(1), marshal(1)) {
+		t.Fatalf("same seed gave different traces")
+	}
+	if bytes.Equal(marshal(1), marshal(2)) {
+		t.Fatalf("different seeds gave the same traces")
+	}
+}
+
+func TestGenerate_ErrorRate(t *testing.T) {
+	cfg := Config{Seed: 1, Scenarios: []Scenario{{
+		Name: "flaky",
+		Root: Call{Service: "api", Operation: "GET /", Latency: FixedLatency(time.Millisecond), ErrorRate: 0.2},
+	}}}
+	traces, err := Generate(cfg, 1000)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	failed := 0
+	for _, tr := range traces {
+		if traceutil.HasError(tr) {
+			failed++
+		}
+	}
+	if failed < 150 || failed > 250 {
+		t.Fatalf("%d of 1000 traces failed at error rate 0.2", failed)
+	}
+}
+
+func TestLatency_Sample(t *testing.T) {
+	g := &generator{r: rand.New(rand.NewSource(1))}
+	tests := []struct {
+		name     string
+		l        Latency
+		min, max time.Duration
+	}{
+		{"fixed", FixedLatency(7 * time.Millisecond), 7 * time.Millisecond, 7 * time.Millisecond},
+		{"uniform", Latency{Dist: Uniform, Min: time.Millisecond, Max: 3 * time.Millisecond}, time.Millisecond, 3 * time.Millisecond},
+		{"normal clamped", Latency{Dist: Normal, Mean: 10 * time.Millisecond, StdDev: 50 * time.Millisecond, Min: 5 * time.Millisecond, Max: 15 * time.Millisecond}, 5 * time.Millisecond, 15 * time.Millisecond},
+		{"normal never negative", Latency{Dist: Normal, Mean: time.Millisecond, StdDev: 10 * time.Millisecond}, 0, time.Hour},
+		{"lognormal", Latency{Dist: LogNormal, Mean: 10 * time.Millisecond, StdDev: 5 * time.Millisecond}, 0, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 500; i++ {
+				if d := g.sample(tt.l); d < tt.min || d > tt.max {
+					t.Fatalf("sample %v outside [%v, %v]", d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerate_InvalidConfig(t *testing.T) {
+	ok := Call{Service: "api", Operation: "GET /"}
+	tests := []struct {
+		name string
+		call Call
+		want string
+	}{
+		{"missing operation", Call{Service: "api"}, "service and operation are required"},
+		{"unknown kind", Call{Service: "api", Operation: "GET /", Kind: "rpc"}, "unknown span kind"},
+		{"unknown distribution", Call{Service: "api", Operation: "GET /", Latency: Latency{Dist: "pareto"}}, "unknown latency distribution"},
+		{"inverted uniform", Call{Service: "api", Operation: "GET /", Latency: Latency{Dist: Uniform, Min: 2, Max: 1}}, "below min"},
+		{"error rate", Call{Service: "api", Operation: "GET /", ErrorRate: 1.5}, "outside [0, 1]"},
+		{"bad template", Call{Service: "api", Operation: "GET /", Attributes: map[string]any{"k": "{{rand"}}, "attribute k"},
+		{"nested call", Call{Service: "api", Operation: "GET /", Calls: []Call{ok, {Service: "db"}}}, "service and operation are required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Generate(Config{Scenarios: []Scenario{{Name: "bad", Root: tt.call}}}, 1)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Generate(Config{}, 1); err == nil {
+		t.Fatalf("expected an error without scenarios")
+	}
+	bad := Config{Scenarios: []Scenario{{Name: "bad", Root: Call{
+		Service: "api", Operation: "GET /", Attributes: map[string]any{"k": "{{pick}}"},
+	}}}}
+	if _, err := Generate(bad, 1); err == nil || !strings.Contains(err.Error(), "pick") {
+		t.Fatalf("got %v, want the template's execution error", err)
+	}
+}
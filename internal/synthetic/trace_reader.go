@@ -2,16 +2,25 @@ package synthetic
 
 import (
 	"context"
-
+	"errors"
 	"iter"
+	"log/slog"
+	"time"
 
+	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 
 	"github.com/jaeger-ai-assist-prototype/internal"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
 )
 
 type SyntheticTraceReader struct {
 	traces []ptrace.Traces
+
+	// Logger records each call with its duration at debug level and
+	// failures as warnings; nil uses slog.Default().
+	Logger *slog.Logger
 }
 
 func NewSyntheticTraceReader(traces []ptrace.Traces) *SyntheticTraceReader {
@@ -23,23 +32,93 @@ func (r *SyntheticTraceReader) FindTraces(
 	query internal.TraceQueryParams,
 ) iter.Seq2[[]ptrace.Traces, error] {
 	return func(yield func([]ptrace.Traces, error) bool) {
+		began := time.Now()
+		found := 0
+		var failed error
+		for batch, err := range r.findTraces(ctx, query) {
+			if err != nil {
+				failed = err
+			} else {
+				found += len(batch)
+			}
+			if !yield(batch, err) {
+				break
+			}
+		}
+
+		attrs := []any{
+			"service", query.ServiceName,
+			"operation", query.OperationName,
+			"traces", found,
+			"duration", time.Since(began),
+		}
+		if failed != nil {
+			logging.OrDefault(r.Logger).WarnContext(ctx, "synthetic find traces failed", append(attrs, "error", failed)...)
+			return
+		}
+		logging.OrDefault(r.Logger).DebugContext(ctx, "synthetic find traces", attrs...)
+	}
+}
+
+func (r *SyntheticTraceReader) findTraces(
+	ctx context.Context,
+	query internal.TraceQueryParams,
+) iter.Seq2[[]ptrace.Traces, error] {
+	return func(yield func([]ptrace.Traces, error) bool) {
+		found := 0
 		for _, t := range r.traces {
 			if ctx.Err() != nil {
 				yield(nil, ctx.Err())
 				return
 			}
 
-			if query.ServiceName != "" && !internal.TraceMatchesService(t, query.ServiceName) {
+			if !internal.TraceMatchesSpanFilters(t, query.ServiceName, query.OperationName, query.Attributes) {
 				continue
 			}
 
-			if !internal.TraceMatchesMinDuration(t, query.DurationMin) {
+			if !internal.TraceMatchesDuration(t, query.DurationMin, query.DurationMax) {
+				continue
+			}
+
+			if !internal.TraceMatchesStartTime(t, query.StartTimeMin, query.StartTimeMax) {
 				continue
 			}
 
 			if !yield([]ptrace.Traces{t}, nil) {
 				return
 			}
+
+			// SearchDepth caps the number of traces returned, as in Jaeger.
+			found++
+			if query.SearchDepth > 0 && found >= query.SearchDepth {
+				return
+			}
+		}
+	}
+}
+
+// GetTrace returns the trace with the given ID, or internal.ErrTraceNotFound.
+func (r *SyntheticTraceReader) GetTrace(ctx context.Context, id pcommon.TraceID) (ptrace.Traces, error) {
+	t, err := r.getTrace(ctx, id)
+
+	logger := logging.OrDefault(r.Logger)
+	switch {
+	case err == nil:
+		logger.DebugContext(ctx, "synthetic get trace", "trace_id", id.String(), "spans", t.SpanCount())
+	case !errors.Is(err, internal.ErrTraceNotFound):
+		logger.WarnContext(ctx, "synthetic get trace failed", "trace_id", id.String(), "error", err)
+	}
+	return t, err
+}
+
+func (r *SyntheticTraceReader) getTrace(ctx context.Context, id pcommon.TraceID) (ptrace.Traces, error) {
+	if err := ctx.Err(); err != nil {
+		return ptrace.Traces{}, err
+	}
+	for _, t := range r.traces {
+		if traceutil.TraceID(t) == id {
+			return t, nil
 		}
 	}
+	return ptrace.Traces{}, internal.ErrTraceNotFound
 }
@@ -0,0 +1,159 @@
+package synthetic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/jaeger-ai-assist-prototype/internal"
+	"github.com/jaeger-ai-assist-prototype/internal/logging"
+	"github.com/jaeger-ai-assist-prototype/internal/traceutil"
+)
+
+// traces_bench.json holds 100 traces rotating through three stories:
+// checkout (34, root 300ms), search (33, root 50ms), catalog (33, root 100ms),
+// starting one second apart from 2024-01-01T12:00:00Z.
+var benchStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func loadBench(t *testing.T) *SyntheticTraceReader {
+	t.Helper()
+	traces, err := LoadTracesFromFile("../../traces_bench.json")
+	if err != nil {
+		t.Fatalf("load bench traces: %v", err)
+	}
+	return NewSyntheticTraceReader(traces)
+}
+
+func attrs(kv ...string) pcommon.Map {
+	m := pcommon.NewMap()
+	for i := 0; i+1 < len(kv); i += 2 {
+		m.PutStr(kv[i], kv[i+1])
+	}
+	return m
+}
+
+func collect(t *testing.T, r *SyntheticTraceReader, q internal.TraceQueryParams) []ptrace.Traces {
+	t.Helper()
+	var out []ptrace.Traces
+	for batch, err := range r.FindTraces(context.Background(), q) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		out = append(out, batch...)
+	}
+	return out
+}
+
+func TestSyntheticTraceReader_FindTraces(t *testing.T) {
+	r := loadBench(t)
+
+	tests := []struct {
+		name  string
+		query internal.TraceQueryParams
+		want  int
+	}{
+		{"no filters", internal.TraceQueryParams{}, 100},
+		{"service from resource", internal.TraceQueryParams{ServiceName: "payment-svc"}, 34},
+		{"service on every trace", internal.TraceQueryParams{ServiceName: "frontend"}, 100},
+		{"unknown service", internal.TraceQueryParams{ServiceName: "payment-service"}, 0},
+		{"operation", internal.TraceQueryParams{OperationName: "Authorize"}, 34},
+		{"service and operation on one span", internal.TraceQueryParams{ServiceName: "payment-svc", OperationName: "Authorize"}, 34},
+		{"service and operation on different spans", internal.TraceQueryParams{ServiceName: "frontend", OperationName: "Authorize"}, 0},
+		{"error tag matches error status", internal.TraceQueryParams{Attributes: attrs("error", "true")}, 34},
+		{"root operation", internal.TraceQueryParams{OperationName: "GET /search"}, 33},
+		{"unknown operation", internal.TraceQueryParams{OperationName: "DELETE /cart"}, 0},
+		{"span attribute", internal.TraceQueryParams{Attributes: attrs("http.method", "GET")}, 66},
+		{"int attribute as string", internal.TraceQueryParams{Attributes: attrs("http.status_code", "402")}, 34},
+		{"bool attribute as string", internal.TraceQueryParams{Attributes: attrs("slow_query", "true")}, 33},
+		{"resource attribute", internal.TraceQueryParams{Attributes: attrs("service.name", "catalog-db")}, 33},
+		{"attributes must share a span", internal.TraceQueryParams{Attributes: attrs("http.method", "GET", "db.system", "postgres")}, 0},
+		{"span and resource attribute", internal.TraceQueryParams{Attributes: attrs("service.name", "search-db", "db.system", "postgres")}, 33},
+		{"operation and attribute", internal.TraceQueryParams{OperationName: "FETCH", Attributes: attrs("slow_query", "true")}, 33},
+		{"min duration uses root span", internal.TraceQueryParams{DurationMin: 200 * time.Millisecond}, 34},
+		{"max duration uses root span", internal.TraceQueryParams{DurationMax: 60 * time.Millisecond}, 33},
+		{"duration range", internal.TraceQueryParams{DurationMin: 60 * time.Millisecond, DurationMax: 150 * time.Millisecond}, 33},
+		{"start time range", internal.TraceQueryParams{StartTimeMin: benchStart, StartTimeMax: benchStart.Add(9 * time.Second)}, 10},
+		{"start time min only", internal.TraceQueryParams{StartTimeMin: benchStart.Add(90 * time.Second)}, 10},
+		{"start time in the past", internal.TraceQueryParams{StartTimeMax: benchStart.Add(-time.Second)}, 0},
+		{"search depth limits results", internal.TraceQueryParams{SearchDepth: 5}, 5},
+		{"search depth above matches", internal.TraceQueryParams{OperationName: "GetItems", SearchDepth: 50}, 33},
+		{"combined", internal.TraceQueryParams{
+			OperationName: "GetItems",
+			DurationMin:   90 * time.Millisecond,
+			StartTimeMin:  benchStart,
+			StartTimeMax:  benchStart.Add(29 * time.Second),
+		}, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collect(t, r, tt.query)
+			if len(got) != tt.want {
+				t.Fatalf("got %d traces, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestSyntheticTraceReader_CancelledContext(t *testing.T) {
+	r := loadBench(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, err := range r.FindTraces(ctx, internal.TraceQueryParams{}) {
+		if err == nil {
+			t.Fatalf("expected context error")
+		}
+		return
+	}
+	t.Fatalf("expected the iterator to yield an error")
+}
+
+func TestSyntheticTraceReader_GetTrace(t *testing.T) {
+	r := loadBench(t)
+
+	want := r.traces[42]
+	got, err := r.GetTrace(context.Background(), traceutil.TraceID(want))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if traceutil.TraceID(got) != traceutil.TraceID(want) {
+		t.Fatalf("got trace %v", traceutil.TraceID(got))
+	}
+
+	if _, err := r.GetTrace(context.Background(), pcommon.TraceID{1}); !errors.Is(err, internal.ErrTraceNotFound) {
+		t.Fatalf("got %v, want ErrTraceNotFound", err)
+	}
+}
+
+func TestSyntheticTraceReader_Logger(t *testing.T) {
+	r := loadBench(t)
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, slog.LevelDebug, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logger = logger
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	for _, err := range r.FindTraces(ctx, internal.TraceQueryParams{ServiceName: "payment-svc"}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	out := buf.String()
+	for _, want := range []string{`msg="synthetic find traces"`, "request_id=req-1", "service=payment-svc", "traces=34"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %q:\n%s", want, out)
+		}
+	}
+}
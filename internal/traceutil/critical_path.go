@@ -0,0 +1,105 @@
+package traceutil
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// CriticalSpan is a span on the critical path.
+type CriticalSpan struct {
+	Node *Node
+	// Exclusive is the time the span itself holds the path, as opposed to
+	// waiting on a child that is also on the path.
+	Exclusive time.Duration
+}
+
+// CriticalPath returns the spans that bound the latency of the trace's first
+// root, in the order the path enters them; a parent comes before the children
+// it waits on. The Exclusive times add up to the root's duration.
+//
+// The path is walked backwards from the root's end: at each step it descends
+// into the child that finished last before the current point and continues
+// from that child's start, so concurrent children only count while nothing
+// later was still running. Children are clipped to their parent: async spans
+// that start after their parent ended are off the path, and those that
+// outlive it count only up to the parent's end. Ties go to the child that
+// started last, then to the lower span ID, so the result is deterministic.
+func (t *Tree) CriticalPath() []CriticalSpan {
+	var root *Node
+	for _, r := range t.Roots {
+		if !r.Orphan {
+			root = r
+			break
+		}
+	}
+	if root == nil {
+		if len(t.Roots) == 0 {
+			return nil
+		}
+		root = t.Roots[0]
+	}
+
+	type visit struct {
+		node *Node
+		lo   pcommon.Timestamp
+		idx  int
+	}
+	var visits []visit
+	exclusive := map[*Node]time.Duration{}
+
+	var walk func(n *Node, lo, hi pcommon.Timestamp)
+	walk = func(n *Node, lo, hi pcommon.Timestamp) {
+		visits = append(visits, visit{node: n, lo: lo, idx: len(visits)})
+
+		cursor := hi
+		for cursor > lo {
+			var next *Node
+			var nextLo, nextHi pcommon.Timestamp
+			for _, c := range n.Children {
+				clo, chi := max(c.Span.StartTimestamp(), lo), min(c.Span.EndTimestamp(), hi)
+				if chi <= clo || chi > cursor {
+					continue
+				}
+				if next == nil || chi > nextHi ||
+					chi == nextHi && (clo > nextLo || clo == nextLo && lessID(c, next)) {
+					next, nextLo, nextHi = c, clo, chi
+				}
+			}
+			if next == nil {
+				break
+			}
+			exclusive[n] += time.Duration(cursor - nextHi)
+			walk(next, nextLo, nextHi)
+			cursor = nextLo
+		}
+		exclusive[n] += time.Duration(cursor - lo)
+	}
+
+	start, end := root.Span.StartTimestamp(), root.Span.EndTimestamp()
+	if end < start {
+		end = start
+	}
+	walk(root, start, end)
+
+	// Visits were recorded walking backwards; order them by entry time, with
+	// earlier visits (parents) first among spans entered at the same instant.
+	sort.Slice(visits, func(i, j int) bool {
+		if visits[i].lo != visits[j].lo {
+			return visits[i].lo < visits[j].lo
+		}
+		return visits[i].idx < visits[j].idx
+	})
+	path := make([]CriticalSpan, 0, len(visits))
+	for _, v := range visits {
+		path = append(path, CriticalSpan{Node: v.node, Exclusive: exclusive[v.node]})
+	}
+	return path
+}
+
+func lessID(a, b *Node) bool {
+	ai, bi := a.Span.SpanID(), b.Span.SpanID()
+	return bytes.Compare(ai[:], bi[:]) < 0
+}
@@ -0,0 +1,89 @@
+package traceutil
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+type step struct {
+	name string
+	ms   int
+}
+
+func checkPath(t *testing.T, got []CriticalSpan, want []step) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d spans on the path, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Node.Span.Name() != w.name || got[i].Exclusive != time.Duration(w.ms)*time.Millisecond {
+			t.Fatalf("step %d: got %s %v, want %s %dms", i, got[i].Node.Span.Name(), got[i].Exclusive, w.name, w.ms)
+		}
+	}
+}
+
+func TestCriticalPath_Sequential(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "a", "root", 1, 0, 0, 100)
+	addSpan(td, "b", "auth", 2, 1, 5, 20)   // 5-25
+	addSpan(td, "c", "query", 3, 1, 30, 50) // 30-80
+	addSpan(td, "c", "index", 4, 3, 40, 30) // 40-70
+
+	checkPath(t, BuildTree(td).CriticalPath(), []step{
+		{"root", 5 + 5 + 20},
+		{"auth", 20},
+		{"query", 10 + 10},
+		{"index", 30},
+	})
+}
+
+func TestCriticalPath_ConcurrentChildren(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "a", "root", 1, 0, 0, 100)
+	addSpan(td, "b", "fast", 2, 1, 10, 30) // 10-40, hidden by slow
+	addSpan(td, "c", "slow", 3, 1, 10, 80) // 10-90
+	addSpan(td, "d", "prep", 4, 1, 0, 8)   // 0-8, ends before slow starts
+
+	path := BuildTree(td).CriticalPath()
+	checkPath(t, path, []step{
+		{"root", 2 + 10},
+		{"prep", 8},
+		{"slow", 80},
+	})
+
+	var total time.Duration
+	for _, s := range path {
+		total += s.Exclusive
+	}
+	if total != 100*time.Millisecond {
+		t.Fatalf("exclusive times add up to %v, want the root's 100ms", total)
+	}
+}
+
+func TestCriticalPath_AsyncChildren(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "a", "root", 1, 0, 0, 50)
+	addSpan(td, "b", "publish", 2, 1, 10, 100) // outlives root, clipped to 10-50
+	addSpan(td, "c", "consume", 3, 1, 60, 10)  // starts after root ended
+
+	checkPath(t, BuildTree(td).CriticalPath(), []step{
+		{"root", 10},
+		{"publish", 40},
+	})
+}
+
+func TestCriticalPath_TieBreak(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "a", "root", 1, 0, 0, 50)
+	addSpan(td, "b", "second", 3, 1, 10, 30)
+	addSpan(td, "c", "first", 2, 1, 10, 30)
+
+	for i := 0; i < 5; i++ {
+		checkPath(t, BuildTree(td).CriticalPath(), []step{
+			{"root", 20},
+			{"first", 30},
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Package traceutil holds the read-only helpers used to inspect ptrace.Traces:
+// which service emitted a span, whether it failed, where the trace starts and
+// how long it took.
+package traceutil
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+const serviceNameKey = "service.name"
+
+// ErrInvalidTraceID and ErrInvalidSpanID are wrapped by the errors
+// ParseTraceID and ParseSpanID return.
+var (
+	ErrInvalidTraceID = errors.New("invalid trace ID")
+	ErrInvalidSpanID  = errors.New("invalid span ID")
+)
+
+// ForEachSpan calls f for every span in t together with the resource that
+// emitted it. Iteration stops when f returns false.
+func ForEachSpan(t ptrace.Traces, f func(res pcommon.Resource, span ptrace.Span) bool) {
+	rs := t.ResourceSpans()
+	for i := 0; i < rs.Len(); i++ {
+		res := rs.At(i).Resource()
+		ss := rs.At(i).ScopeSpans()
+		for j := 0; j < ss.Len(); j++ {
+			spans := ss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				if !f(res, spans.At(k)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// TraceID returns the trace ID of the first span in t, or an empty ID for a
+// trace without spans.
+func TraceID(t ptrace.Traces) pcommon.TraceID {
+	var id pcommon.TraceID
+	ForEachSpan(t, func(_ pcommon.Resource, span ptrace.Span) bool {
+		id = span.TraceID()
+		return false
+	})
+	return id
+}
+
+// ServiceName returns the service that emitted span. OTLP puts service.name
+// on the resource; a span-level attribute is only consulted as a fallback for
+// data converted from older formats. It returns "" when neither is set.
+func ServiceName(res pcommon.Resource, span ptrace.Span) string {
+	if v, ok := res.Attributes().Get(serviceNameKey); ok && v.Str() != "" {
+		return v.Str()
+	}
+	if v, ok := span.Attributes().Get(serviceNameKey); ok {
+		return v.Str()
+	}
+	return ""
+}
+
+// Services returns the distinct service names in t in first-seen order.
+func Services(t ptrace.Traces) []string {
+	var out []string
+	seen := map[string]bool{}
+	ForEachSpan(t, func(res pcommon.Resource, span ptrace.Span) bool {
+		if svc := ServiceName(res, span); svc != "" && !seen[svc] {
+			seen[svc] = true
+			out = append(out, svc)
+		}
+		return true
+	})
+	return out
+}
+
+// HasService reports whether any span of t was emitted by service.
+func HasService(t ptrace.Traces, service string) bool {
+	found := false
+	ForEachSpan(t, func(res pcommon.Resource, span ptrace.Span) bool {
+		found = ServiceName(res, span) == service
+		return !found
+	})
+	return found
+}
+
+// IsError reports whether span failed, either through its OTLP status or the
+// legacy OpenTracing "error" tag.
+func IsError(span ptrace.Span) bool {
+	if span.Status().Code() == ptrace.StatusCodeError {
+		return true
+	}
+	v, ok := span.Attributes().Get("error")
+	if !ok {
+		return false
+	}
+	switch v.Type() {
+	case pcommon.ValueTypeBool:
+		return v.Bool()
+	case pcommon.ValueTypeStr:
+		return strings.EqualFold(v.Str(), "true")
+	default:
+		return false
+	}
+}
+
+// HasError reports whether any span of t failed.
+func HasError(t ptrace.Traces) bool {
+	found := false
+	ForEachSpan(t, func(_ pcommon.Resource, span ptrace.Span) bool {
+		found = IsError(span)
+		return !found
+	})
+	return found
+}
+
+// RootSpan returns the first span without a parent and its resource.
+// Partial traces may have none.
+func RootSpan(t ptrace.Traces) (ptrace.Span, pcommon.Resource, bool) {
+	var root ptrace.Span
+	var rootRes pcommon.Resource
+	found := false
+	ForEachSpan(t, func(res pcommon.Resource, span ptrace.Span) bool {
+		if span.ParentSpanID().IsEmpty() {
+			root, rootRes, found = span, res, true
+			return false
+		}
+		return true
+	})
+	return root, rootRes, found
+}
+
+// SpanDuration returns the wall-clock duration of span.
+func SpanDuration(span ptrace.Span) time.Duration {
+	return span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime())
+}
+
+// Bounds returns the earliest span start and the latest span end in t.
+func Bounds(t ptrace.Traces) (time.Time, time.Time) {
+	var start, end time.Time
+	ForEachSpan(t, func(_ pcommon.Resource, span ptrace.Span) bool {
+		s := span.StartTimestamp().AsTime()
+		e := span.EndTimestamp().AsTime()
+		if start.IsZero() || s.Before(start) {
+			start = s
+		}
+		if e.After(end) {
+			end = e
+		}
+		return true
+	})
+	return start, end
+}
+
+// Duration is the duration of the root span, as Jaeger reports it. Traces
+// without a root span fall back to the envelope of all spans.
+func Duration(t ptrace.Traces) time.Duration {
+	if root, _, ok := RootSpan(t); ok {
+		return SpanDuration(root)
+	}
+	start, end := Bounds(t)
+	return end.Sub(start)
+}
+
+// StartTime is the start of the earliest span in t.
+func StartTime(t ptrace.Traces) time.Time {
+	start, _ := Bounds(t)
+	return start
+}
+
+// FindSpan returns the span of t with the given ID and its resource.
+func FindSpan(t ptrace.Traces, id pcommon.SpanID) (ptrace.Span, pcommon.Resource, bool) {
+	var found ptrace.Span
+	var foundRes pcommon.Resource
+	ok := false
+	ForEachSpan(t, func(res pcommon.Resource, span ptrace.Span) bool {
+		if span.SpanID() == id {
+			found, foundRes, ok = span, res, true
+			return false
+		}
+		return true
+	})
+	return found, foundRes, ok
+}
+
+// ParseTraceID decodes a hex trace ID as shown by the Jaeger UI. IDs shorter
+// than 32 digits, such as 64-bit Jaeger IDs, are left-padded with zeros.
+func ParseTraceID(s string) (pcommon.TraceID, error) {
+	var id pcommon.TraceID
+	if err := parseHexID(id[:], s); err != nil {
+		return id, fmt.Errorf("%w %q: %w", ErrInvalidTraceID, s, err)
+	}
+	return id, nil
+}
+
+// ParseSpanID decodes a hex span ID of up to 16 digits.
+func ParseSpanID(s string) (pcommon.SpanID, error) {
+	var id pcommon.SpanID
+	if err := parseHexID(id[:], s); err != nil {
+		return id, fmt.Errorf("%w %q: %w", ErrInvalidSpanID, s, err)
+	}
+	return id, nil
+}
+
+func parseHexID(dst []byte, s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return errors.New("empty ID")
+	}
+	if len(s) > 2*len(dst) {
+		return fmt.Errorf("longer than %d hex digits", 2*len(dst))
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	copy(dst[len(dst)-len(b):], b)
+	return nil
+}
@@ -0,0 +1,164 @@
+package traceutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+var base = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func addSpan(t ptrace.Traces, svc, name string, id, parent byte, startMs, durMs int) ptrace.Span {
+	rs := t.ResourceSpans().AppendEmpty()
+	if svc != "" {
+		rs.Resource().Attributes().PutStr("service.name", svc)
+	}
+	s := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	s.SetTraceID(pcommon.TraceID{15: 1})
+	s.SetSpanID(pcommon.SpanID{7: id})
+	if parent != 0 {
+		s.SetParentSpanID(pcommon.SpanID{7: parent})
+	}
+	s.SetName(name)
+	start := base.Add(time.Duration(startMs) * time.Millisecond)
+	s.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+	s.SetEndTimestamp(pcommon.NewTimestampFromTime(start.Add(time.Duration(durMs) * time.Millisecond)))
+	return s
+}
+
+func TestServiceName(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "frontend", "GET /", 1, 0, 0, 10)
+	legacy := addSpan(td, "", "legacy", 2, 1, 1, 5)
+	legacy.Attributes().PutStr("service.name", "old-svc")
+	addSpan(td, "", "anonymous", 3, 1, 2, 5)
+
+	var got []string
+	ForEachSpan(td, func(res pcommon.Resource, span ptrace.Span) bool {
+		got = append(got, ServiceName(res, span))
+		return true
+	})
+
+	want := []string{"frontend", "old-svc", ""}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("span %d: got service %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	if !HasService(td, "old-svc") || HasService(td, "missing") {
+		t.Fatalf("HasService mismatch")
+	}
+	if svcs := Services(td); len(svcs) != 2 {
+		t.Fatalf("expected 2 distinct services, got %v", svcs)
+	}
+}
+
+func TestIsError(t *testing.T) {
+	td := ptrace.NewTraces()
+
+	ok := addSpan(td, "a", "ok", 1, 0, 0, 1)
+	if IsError(ok) {
+		t.Fatalf("span without status or tag must not be an error")
+	}
+
+	status := addSpan(td, "a", "status", 2, 1, 0, 1)
+	status.Status().SetCode(ptrace.StatusCodeError)
+
+	boolTag := addSpan(td, "a", "bool", 3, 1, 0, 1)
+	boolTag.Attributes().PutBool("error", true)
+
+	strTag := addSpan(td, "a", "str", 4, 1, 0, 1)
+	strTag.Attributes().PutStr("error", "true")
+
+	falseTag := addSpan(td, "a", "false", 5, 1, 0, 1)
+	falseTag.Attributes().PutBool("error", false)
+
+	for _, s := range []ptrace.Span{status, boolTag, strTag} {
+		if !IsError(s) {
+			t.Fatalf("%s: expected error", s.Name())
+		}
+	}
+	if IsError(falseTag) {
+		t.Fatalf("error=false must not be an error")
+	}
+	if !HasError(td) {
+		t.Fatalf("expected HasError")
+	}
+}
+
+func TestRootSpanAndDuration(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "db", "SELECT", 2, 1, 5, 20)
+	addSpan(td, "frontend", "GET /", 1, 0, 0, 50)
+	addSpan(td, "async", "publish", 3, 1, 40, 100)
+
+	root, res, ok := RootSpan(td)
+	if !ok || root.Name() != "GET /" {
+		t.Fatalf("unexpected root span %q", root.Name())
+	}
+	if svc, _ := res.Attributes().Get("service.name"); svc.Str() != "frontend" {
+		t.Fatalf("root resource mismatch")
+	}
+
+	if d := Duration(td); d != 50*time.Millisecond {
+		t.Fatalf("trace duration should come from the root span, got %v", d)
+	}
+
+	start, end := Bounds(td)
+	if !start.Equal(base) || !end.Equal(base.Add(140*time.Millisecond)) {
+		t.Fatalf("unexpected bounds [%v, %v]", start, end)
+	}
+}
+
+func TestDuration_NoRoot(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "a", "x", 2, 1, 10, 20)
+	addSpan(td, "b", "y", 3, 1, 25, 20)
+
+	if _, _, ok := RootSpan(td); ok {
+		t.Fatalf("expected no root span")
+	}
+	if d := Duration(td); d != 35*time.Millisecond {
+		t.Fatalf("expected envelope duration, got %v", d)
+	}
+}
+
+func TestFindSpan(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "frontend", "GET /", 1, 0, 0, 50)
+	addSpan(td, "db", "SELECT", 2, 1, 5, 20)
+
+	span, res, ok := FindSpan(td, pcommon.SpanID{7: 2})
+	if !ok || span.Name() != "SELECT" || ServiceName(res, span) != "db" {
+		t.Fatalf("unexpected span %q (found=%v)", span.Name(), ok)
+	}
+	if _, _, ok := FindSpan(td, pcommon.SpanID{7: 9}); ok {
+		t.Fatalf("expected no span")
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	id, err := ParseTraceID("0000000000000000000000000000000a")
+	if err != nil || id != (pcommon.TraceID{15: 0x0a}) {
+		t.Fatalf("got %v, %v", id, err)
+	}
+	if id, err := ParseTraceID("abc"); err != nil || id != (pcommon.TraceID{14: 0x0a, 15: 0xbc}) {
+		t.Fatalf("short IDs must be left-padded, got %v, %v", id, err)
+	}
+	if sid, err := ParseSpanID("00000000000000ff"); err != nil || sid != (pcommon.SpanID{7: 0xff}) {
+		t.Fatalf("got %v, %v", sid, err)
+	}
+
+	for _, bad := range []string{"", "xyz", "00000000000000000000000000000000ff"} {
+		if _, err := ParseTraceID(bad); !errors.Is(err, ErrInvalidTraceID) {
+			t.Fatalf("%q: got %v, want ErrInvalidTraceID", bad, err)
+		}
+	}
+	if _, err := ParseSpanID("00000000000000000"); !errors.Is(err, ErrInvalidSpanID) {
+		t.Fatalf("17-digit span ID: got %v, want ErrInvalidSpanID", err)
+	}
+}
@@ -0,0 +1,205 @@
+package traceutil
+
+import (
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// Node is a span in the call tree rebuilt from ParentSpanID.
+type Node struct {
+	Span     ptrace.Span
+	Resource pcommon.Resource
+	Parent   *Node
+	// Children are ordered by start time.
+	Children []*Node
+	Depth    int
+	// Orphan is set when the span names a parent that is not in the trace,
+	// or when its parent links form a cycle. Orphans are listed as roots.
+	Orphan bool
+}
+
+// Tree is the call tree of one trace.
+type Tree struct {
+	// Roots holds spans without a parent, then orphans, each by start time.
+	Roots []*Node
+	ByID  map[pcommon.SpanID]*Node
+}
+
+// BuildTree reconstructs the call tree of t. It never fails: spans whose
+// parent is missing become orphan roots, so every span appears exactly once.
+func BuildTree(t ptrace.Traces) *Tree {
+	tree := &Tree{ByID: map[pcommon.SpanID]*Node{}}
+	var nodes []*Node
+	ForEachSpan(t, func(res pcommon.Resource, span ptrace.Span) bool {
+		n := &Node{Span: span, Resource: res}
+		nodes = append(nodes, n)
+		tree.ByID[span.SpanID()] = n
+		return true
+	})
+
+	for _, n := range nodes {
+		pid := n.Span.ParentSpanID()
+		if pid.IsEmpty() {
+			continue
+		}
+		if p, ok := tree.ByID[pid]; ok && p != n {
+			n.Parent = p
+			p.Children = append(p.Children, n)
+		} else {
+			n.Orphan = true
+		}
+	}
+
+	// Spans on a parent cycle are unreachable from any root; cut each cycle
+	// at its first span and treat that span as an orphan.
+	reached := map[*Node]bool{}
+	var mark func(n *Node)
+	mark = func(n *Node) {
+		reached[n] = true
+		for _, c := range n.Children {
+			if !reached[c] {
+				mark(c)
+			}
+		}
+	}
+	for _, n := range nodes {
+		if n.Parent == nil {
+			mark(n)
+		}
+	}
+	for _, n := range nodes {
+		if reached[n] {
+			continue
+		}
+		p := n.Parent
+		for i, c := range p.Children {
+			if c == n {
+				p.Children = append(p.Children[:i], p.Children[i+1:]...)
+				break
+			}
+		}
+		n.Parent = nil
+		n.Orphan = true
+		mark(n)
+	}
+
+	var roots, orphans []*Node
+	for _, n := range nodes {
+		switch {
+		case n.Parent != nil:
+		case n.Orphan:
+			orphans = append(orphans, n)
+		default:
+			roots = append(roots, n)
+		}
+	}
+	byStart(roots)
+	byStart(orphans)
+	tree.Roots = append(roots, orphans...)
+
+	tree.Walk(func(n *Node) bool {
+		byStart(n.Children)
+		if n.Parent != nil {
+			n.Depth = n.Parent.Depth + 1
+		}
+		return true
+	})
+	return tree
+}
+
+func byStart(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Span.StartTimestamp() < nodes[j].Span.StartTimestamp()
+	})
+}
+
+// Walk visits the tree depth first, parents before children. Returning false
+// from f skips the node's children.
+func (t *Tree) Walk(f func(n *Node) bool) {
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		if !f(n) {
+			return
+		}
+		for _, c := range n.Children {
+			visit(c)
+		}
+	}
+	for _, r := range t.Roots {
+		visit(r)
+	}
+}
+
+// Start is the start of the first root span, the reference for offsets.
+// Traces made only of orphans fall back to the earliest span.
+func (t *Tree) Start() time.Time {
+	for _, r := range t.Roots {
+		if !r.Orphan {
+			return r.Span.StartTimestamp().AsTime()
+		}
+	}
+	var start time.Time
+	for _, r := range t.Roots {
+		if s := r.Span.StartTimestamp().AsTime(); start.IsZero() || s.Before(start) {
+			start = s
+		}
+	}
+	return start
+}
+
+// Duration is the span's own duration.
+func (n *Node) Duration() time.Duration {
+	return SpanDuration(n.Span)
+}
+
+// SelfTime is the part of the span's duration not covered by any child.
+// Overlapping children count once and child time outside the span is ignored.
+func (n *Node) SelfTime() time.Duration {
+	start, end := n.Span.StartTimestamp(), n.Span.EndTimestamp()
+	if end <= start {
+		return 0
+	}
+
+	type interval struct{ lo, hi pcommon.Timestamp }
+	var covered []interval
+	for _, c := range n.Children {
+		lo, hi := max(c.Span.StartTimestamp(), start), min(c.Span.EndTimestamp(), end)
+		if hi > lo {
+			covered = append(covered, interval{lo, hi})
+		}
+	}
+	sort.Slice(covered, func(i, j int) bool { return covered[i].lo < covered[j].lo })
+
+	busy := time.Duration(0)
+	var cur interval
+	for i, iv := range covered {
+		if i > 0 && iv.lo <= cur.hi {
+			cur.hi = max(cur.hi, iv.hi)
+			continue
+		}
+		if i > 0 {
+			busy += time.Duration(cur.hi - cur.lo)
+		}
+		cur = iv
+	}
+	if len(covered) > 0 {
+		busy += time.Duration(cur.hi - cur.lo)
+	}
+	return time.Duration(end-start) - busy
+}
+
+// ClockSkew is how long before its parent the span claims to start, a sign
+// that the two hosts' clocks disagree. It is 0 for well-ordered spans.
+func (n *Node) ClockSkew() time.Duration {
+	if n.Parent == nil {
+		return 0
+	}
+	ps, cs := n.Parent.Span.StartTimestamp(), n.Span.StartTimestamp()
+	if cs >= ps {
+		return 0
+	}
+	return time.Duration(ps - cs)
+}
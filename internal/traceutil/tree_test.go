@@ -0,0 +1,105 @@
+package traceutil
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func names(nodes []*Node) []string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, n.Span.Name())
+	}
+	return out
+}
+
+func TestBuildTree(t *testing.T) {
+	td := ptrace.NewTraces()
+	// Listed out of order on purpose: the tree must not depend on it.
+	addSpan(td, "db", "SELECT", 3, 2, 20, 30)
+	addSpan(td, "frontend", "GET /", 1, 0, 0, 100)
+	addSpan(td, "api", "Load", 2, 1, 10, 60)
+	addSpan(td, "cache", "GET", 4, 2, 12, 5)
+	addSpan(td, "lost", "orphan", 5, 9, 40, 10)
+
+	tree := BuildTree(td)
+
+	if got := names(tree.Roots); len(got) != 2 || got[0] != "GET /" || got[1] != "orphan" {
+		t.Fatalf("unexpected roots %v", got)
+	}
+	if !tree.Roots[1].Orphan || tree.Roots[0].Orphan {
+		t.Fatalf("orphan flag mismatch")
+	}
+
+	load := tree.ByID[pcommon.SpanID{7: 2}]
+	if got := names(load.Children); len(got) != 2 || got[0] != "GET" || got[1] != "SELECT" {
+		t.Fatalf("children must be ordered by start, got %v", got)
+	}
+	if load.Depth != 1 || load.Children[1].Depth != 2 {
+		t.Fatalf("unexpected depths %d, %d", load.Depth, load.Children[1].Depth)
+	}
+
+	var order []string
+	tree.Walk(func(n *Node) bool {
+		order = append(order, n.Span.Name())
+		return true
+	})
+	want := []string{"GET /", "Load", "GET", "SELECT", "orphan"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("walk order %v, want %v", order, want)
+		}
+	}
+
+	if !tree.Start().Equal(base) {
+		t.Fatalf("unexpected start %v", tree.Start())
+	}
+}
+
+func TestNode_SelfTime(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "a", "root", 1, 0, 0, 100)
+	addSpan(td, "b", "first", 2, 1, 10, 30)   // 10-40
+	addSpan(td, "c", "overlap", 3, 1, 30, 20) // 30-50, overlaps first
+	addSpan(td, "d", "late", 4, 1, 90, 40)    // 90-130, clipped to 100
+
+	root := BuildTree(td).Roots[0]
+	// Covered: 10-50 and 90-100, so self time is 100 - 40 - 10.
+	if got := root.SelfTime(); got != 50*time.Millisecond {
+		t.Fatalf("got self time %v, want 50ms", got)
+	}
+	if got := root.Children[0].SelfTime(); got != 30*time.Millisecond {
+		t.Fatalf("leaf self time should be its duration, got %v", got)
+	}
+}
+
+func TestNode_ClockSkew(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "a", "root", 1, 0, 10, 100)
+	addSpan(td, "b", "early", 2, 1, 4, 20)
+	addSpan(td, "c", "fine", 3, 1, 20, 20)
+
+	tree := BuildTree(td)
+	if got := tree.ByID[pcommon.SpanID{7: 2}].ClockSkew(); got != 6*time.Millisecond {
+		t.Fatalf("got skew %v, want 6ms", got)
+	}
+	if got := tree.ByID[pcommon.SpanID{7: 3}].ClockSkew(); got != 0 {
+		t.Fatalf("unexpected skew %v", got)
+	}
+}
+
+func TestBuildTree_ParentCycle(t *testing.T) {
+	td := ptrace.NewTraces()
+	addSpan(td, "a", "x", 1, 2, 0, 10)
+	addSpan(td, "b", "y", 2, 1, 5, 10)
+
+	tree := BuildTree(td)
+	count := 0
+	tree.Walk(func(*Node) bool { count++; return true })
+	if count != 2 || len(tree.Roots) != 1 || !tree.Roots[0].Orphan {
+		t.Fatalf("cycle must be cut into one orphan root, got %d roots, %d spans", len(tree.Roots), count)
+	}
+}